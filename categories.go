@@ -0,0 +1,72 @@
+package paprika
+
+import (
+	"cmp"
+	"slices"
+)
+
+// CategoryNode is a category in a hierarchy reconstructed from a flat list of categories.
+type CategoryNode struct {
+	Category
+	Children []*CategoryNode
+}
+
+// BuildCategoryTree reconstructs the category hierarchy described by the ParentUID of each category
+// and returns its root nodes. Categories whose parent is not present in categories are treated as roots,
+// as are categories that are only reachable through a cycle of parent references.
+// Siblings are ordered by OrderFlag, then by Name.
+func BuildCategoryTree(categories []Category) []*CategoryNode {
+	nodes := make(map[string]*CategoryNode, len(categories))
+	for _, c := range categories {
+		nodes[c.UID] = &CategoryNode{Category: c}
+	}
+
+	var roots []*CategoryNode
+	for _, c := range categories {
+		node := nodes[c.UID]
+		if parent, ok := nodes[c.ParentUID]; ok && c.ParentUID != "" && parent != node {
+			parent.Children = append(parent.Children, node)
+		} else {
+			roots = append(roots, node)
+		}
+	}
+
+	// Categories in a cycle are unreachable from any root; promote the first of each cycle to a root.
+	reached := make(map[*CategoryNode]bool, len(nodes))
+	var visit func(*CategoryNode)
+	visit = func(n *CategoryNode) {
+		if reached[n] {
+			return
+		}
+		reached[n] = true
+		for _, child := range n.Children {
+			visit(child)
+		}
+	}
+	for _, r := range roots {
+		visit(r)
+	}
+	for _, c := range categories {
+		node := nodes[c.UID]
+		if reached[node] {
+			continue
+		}
+		parent := nodes[c.ParentUID]
+		parent.Children = slices.DeleteFunc(parent.Children, func(n *CategoryNode) bool { return n == node })
+		roots = append(roots, node)
+		visit(node)
+	}
+
+	sortCategoryNodes(roots)
+	return roots
+}
+
+// sortCategoryNodes recursively sorts nodes and their descendants by OrderFlag, then by Name.
+func sortCategoryNodes(nodes []*CategoryNode) {
+	slices.SortStableFunc(nodes, func(a, b *CategoryNode) int {
+		return cmp.Or(cmp.Compare(a.OrderFlag, b.OrderFlag), cmp.Compare(a.Name, b.Name))
+	})
+	for _, n := range nodes {
+		sortCategoryNodes(n.Children)
+	}
+}
@@ -0,0 +1,46 @@
+package paprika
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBuildCategoryTree(t *testing.T) {
+	names := func(nodes []*CategoryNode) []string {
+		var out []string
+		for _, n := range nodes {
+			out = append(out, n.Name)
+		}
+		return out
+	}
+
+	t.Run("nested", func(t *testing.T) {
+		roots := BuildCategoryTree([]Category{
+			{UID: "c", Name: "Cookies", ParentUID: "d"},
+			{UID: "d", Name: "Desserts", OrderFlag: 2},
+			{UID: "b", Name: "Breakfast", OrderFlag: 1},
+			{UID: "p", Name: "Pies", ParentUID: "d"},
+			{UID: "x", Name: "Chocolate Chip", ParentUID: "c"},
+		})
+		assert.Equal(t, []string{"Breakfast", "Desserts"}, names(roots))
+		assert.Empty(t, roots[0].Children)
+		assert.Equal(t, []string{"Cookies", "Pies"}, names(roots[1].Children))
+		assert.Equal(t, []string{"Chocolate Chip"}, names(roots[1].Children[0].Children))
+	})
+
+	t.Run("missingParentIsRoot", func(t *testing.T) {
+		roots := BuildCategoryTree([]Category{{UID: "a", Name: "Orphan", ParentUID: "gone"}})
+		assert.Equal(t, []string{"Orphan"}, names(roots))
+	})
+
+	t.Run("cycleIsBroken", func(t *testing.T) {
+		roots := BuildCategoryTree([]Category{
+			{UID: "a", Name: "A", ParentUID: "b"},
+			{UID: "b", Name: "B", ParentUID: "a"},
+		})
+		assert.Equal(t, []string{"A"}, names(roots))
+		assert.Equal(t, []string{"B"}, names(roots[0].Children))
+		assert.Empty(t, roots[0].Children[0].Children)
+	})
+}
@@ -2,33 +2,168 @@ package paprika
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
+	"iter"
 	"net/http"
 	"net/url"
+	"slices"
 	"strings"
+	"unicode/utf8"
 )
 
 const DefaultBaseURL = "https://www.paprikaapp.com/api/v1/sync/"
 
+// DefaultErrorBodyLimit is the default maximum number of response body bytes included in APIError messages.
+const DefaultErrorBodyLimit = 512
+
 type Client struct {
 	username   string
 	password   string
 	httpClient http.Client
 	baseURL    *url.URL
+
+	// Additional headers sent with every request
+	headers http.Header
+	// User-Agent header sent with every request, if set
+	userAgent string
+
+	// Transport configuration, finalized when the client is constructed.
+	transport  *http.Transport
+	middleware []func(http.RoundTripper) http.RoundTripper
+
+	// Maximum number of response body bytes included in APIError messages
+	errorBodyLimit int
+	// Whether APIError messages include request and response context
+	verboseErrors bool
+}
+
+// APIError is returned when the Paprika API responds with an unexpected status code.
+type APIError struct {
+	// Method and URL identify the request, if known. Credentials are redacted from the URL.
+	Method string
+	URL    string
+
+	StatusCode int
+	Status     string
+	// Body is the complete response body. It may be truncated in the error message.
+	Body []byte
+
+	bodyLimit int
+	verbose   bool
+}
+
+func (e *APIError) Error() string {
+	if e.verbose {
+		return e.verboseError()
+	}
+	return fmt.Sprintf("unexpected status code: %s %s", e.Status, truncateBody(e.Body, e.bodyLimit))
+}
+
+// verboseError formats the error over multiple lines, with the request method and URL
+// in addition to the response status and (possibly truncated) body.
+func (e *APIError) verboseError() string {
+	var b strings.Builder
+	b.WriteString("unexpected status code: " + e.Status)
+	if e.Method != "" {
+		b.WriteString("\n  method: " + e.Method)
+	}
+	if e.URL != "" {
+		b.WriteString("\n  url: " + e.URL)
+	}
+	fmt.Fprintf(&b, "\n  status: %d", e.StatusCode)
+	fmt.Fprintf(&b, "\n  body: %q", truncateBody(e.Body, e.bodyLimit))
+	return b.String()
+}
+
+// truncateBody returns body as a string, truncated with an ellipsis if it is longer than limit bytes.
+// Truncation never splits a multi-byte character. A limit of zero or less disables truncation.
+func truncateBody(body []byte, limit int) string {
+	if limit <= 0 || len(body) <= limit {
+		return string(body)
+	}
+	cut := limit
+	for cut > 0 && !utf8.RuneStart(body[cut]) {
+		cut--
+	}
+	return string(body[:cut]) + "…"
+}
+
+// ClientOption configures optional Client behavior.
+type ClientOption func(*Client)
+
+// WithDisableHTTP2 configures the client to use HTTP/1.1 exclusively.
+func WithDisableHTTP2() ClientOption {
+	return func(c *Client) {
+		c.transport.ForceAttemptHTTP2 = false
+		// A non-nil (empty) TLSNextProto map prevents the transport from negotiating HTTP/2 via ALPN.
+		c.transport.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+	}
 }
 
-func NewClient(username, password string) (*Client, error) {
+// WithDisableKeepAlives configures the client to use each connection for a single request,
+// rather than keeping idle connections open for reuse. This suits short-lived, one-shot invocations.
+func WithDisableKeepAlives() ClientOption {
+	return func(c *Client) {
+		c.transport.DisableKeepAlives = true
+	}
+}
+
+// WithHeader adds a header that is sent with every request.
+// Headers managed by the client (Authorization and Content-Type) cannot be overridden,
+// and NewClient/NewClientWithURL return an error if such a header is provided.
+func WithHeader(key, value string) ClientOption {
+	return func(c *Client) {
+		c.headers.Add(key, value)
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with every request.
+// It takes precedence over any User-Agent header provided using WithHeader.
+func WithUserAgent(userAgent string) ClientOption {
+	return func(c *Client) {
+		c.userAgent = userAgent
+	}
+}
+
+// WithErrorBodyLimit sets the maximum number of response body bytes included in the message of an APIError.
+// The complete body remains available from APIError.Body. A limit of zero or less disables truncation.
+// When not provided, DefaultErrorBodyLimit is used.
+func WithErrorBodyLimit(limit int) ClientOption {
+	return func(c *Client) {
+		c.errorBodyLimit = limit
+	}
+}
+
+// WithVerboseErrors configures the client to return APIError values whose messages span multiple lines
+// and include the method and URL of the request, as well as the status and body of the response,
+// e.g. for bug reports. Credentials are never included.
+func WithVerboseErrors() ClientOption {
+	return func(c *Client) {
+		c.verboseErrors = true
+	}
+}
+
+// WithMiddleware wraps the client's HTTP transport with mw.
+// When provided multiple times, middleware is applied in order, so the last one provided is outermost.
+func WithMiddleware(mw func(http.RoundTripper) http.RoundTripper) ClientOption {
+	return func(c *Client) {
+		c.middleware = append(c.middleware, mw)
+	}
+}
+
+func NewClient(username, password string, opts ...ClientOption) (*Client, error) {
 	// Must parse DefaultBaseURL
 	u, err := url.Parse(DefaultBaseURL)
 	if err != nil {
 		panic(err)
 	}
-	return NewClientWithURL(username, password, u)
+	return NewClientWithURL(username, password, u, opts...)
 }
 
-func NewClientWithURL(username, password string, baseURL *url.URL) (*Client, error) {
+func NewClientWithURL(username, password string, baseURL *url.URL, opts ...ClientOption) (*Client, error) {
 	if strings.TrimSpace(username) == "" {
 		return nil, fmt.Errorf("username must not be empty")
 	}
@@ -37,12 +172,38 @@ func NewClientWithURL(username, password string, baseURL *url.URL) (*Client, err
 		return nil, fmt.Errorf("password must not be empty")
 	}
 
-	return &Client{
-		httpClient: http.Client{},
-		username:   username,
-		password:   password,
-		baseURL:    baseURL,
-	}, nil
+	// Ensure the base URL path ends with a slash, so that it is always treated as a directory
+	// when endpoint paths are resolved against it. The caller's URL is not modified.
+	normalizedURL := *baseURL
+	if !strings.HasSuffix(normalizedURL.Path, "/") {
+		normalizedURL.Path += "/"
+		if normalizedURL.RawPath != "" {
+			normalizedURL.RawPath += "/"
+		}
+	}
+
+	c := &Client{
+		username:  username,
+		password:  password,
+		baseURL:   &normalizedURL,
+		headers:   http.Header{},
+		transport: http.DefaultTransport.(*http.Transport).Clone(),
+
+		errorBodyLimit: DefaultErrorBodyLimit,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if err := validateHeaders(c.headers); err != nil {
+		return nil, err
+	}
+
+	var rt http.RoundTripper = c.transport
+	for _, mw := range c.middleware {
+		rt = mw(rt)
+	}
+	c.httpClient = http.Client{Transport: rt}
+	return c, nil
 }
 
 func (c *Client) Recipes(ctx context.Context) ([]RecipeItem, error) {
@@ -55,6 +216,31 @@ func (c *Client) Recipes(ctx context.Context) ([]RecipeItem, error) {
 	return rs, err
 }
 
+// RecipesSeq returns an iterator over the recipes index. Unlike Recipes, index items are decoded
+// as the response is received, so that they may be processed before the entire index has been transferred.
+// Iteration stops after the first error, which is yielded along with a zero RecipeItem.
+func (c *Client) RecipesSeq(ctx context.Context) iter.Seq2[RecipeItem, error] {
+	return func(yield func(RecipeItem, error) bool) {
+		resp, err := c.Get(ctx, "recipes")
+		if err != nil {
+			yield(RecipeItem{}, err)
+			return
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			bodyText, err := io.ReadAll(resp.Body)
+			if err != nil {
+				yield(RecipeItem{}, fmt.Errorf("error reading response body: %w", err))
+				return
+			}
+			yield(RecipeItem{}, c.newAPIError(resp, bodyText))
+			return
+		}
+		streamWrappedArray(resp.Body, yield)
+	}
+}
+
 func (c *Client) RecipesRequest(ctx context.Context) (*http.Request, error) {
 	return c.prepareGet(ctx, "recipes")
 }
@@ -69,6 +255,19 @@ func (c *Client) Recipe(ctx context.Context, uid string) (Recipe, error) {
 	return rs, err
 }
 
+// RecipeRaw returns the recipe identified by uid as the raw JSON provided by the API.
+// Unlike Recipe, it preserves fields unknown to the Recipe type as well as the exact
+// representation of numeric values, which may otherwise lose precision when decoded.
+func (c *Client) RecipeRaw(ctx context.Context, uid string) (json.RawMessage, error) {
+	var raw json.RawMessage
+	req, err := c.RecipeRequest(ctx, uid)
+	if err != nil {
+		return nil, err
+	}
+	err = c.DoRequest(req, &raw)
+	return raw, err
+}
+
 func (c *Client) RecipeRequest(ctx context.Context, uid string) (*http.Request, error) {
 	return c.prepareGet(ctx, "recipe", uid)
 }
@@ -101,6 +300,28 @@ func (c *Client) CategoriesRequest(ctx context.Context) (*http.Request, error) {
 	return c.prepareGet(ctx, "categories")
 }
 
+// EndpointRequest returns an authenticated GET request for the API endpoint at path, relative to the base URL.
+// Together with DoRequest, it allows access to endpoints for which the client has no dedicated methods.
+func (c *Client) EndpointRequest(ctx context.Context, path string) (*http.Request, error) {
+	return c.prepareGet(ctx, path)
+}
+
+// Get requests the API endpoint at the path formed by joining paths (relative to the base URL),
+// and returns the raw response so that callers may inspect its status and headers.
+// Unlike the typed methods, responses with unsuccessful status codes are not treated as errors and the body is not unwrapped
+// (see UnwrapResult and UnmarshalWrappedResponse). The caller must close the response body.
+func (c *Client) Get(ctx context.Context, paths ...string) (*http.Response, error) {
+	req, err := c.prepareGet(ctx, paths...)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s %s: %w", req.Method, req.URL.Redacted(), err)
+	}
+	return resp, nil
+}
+
 func (c *Client) UnmarshalWrappedResponse(resp *http.Response, target any) error {
 	bodyText, err := io.ReadAll(resp.Body)
 	if err != nil {
@@ -108,7 +329,7 @@ func (c *Client) UnmarshalWrappedResponse(resp *http.Response, target any) error
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %s %s", resp.Status, bodyText)
+		return c.newAPIError(resp, bodyText)
 	}
 
 	err = UnwrapResult(bodyText, target)
@@ -119,21 +340,69 @@ func (c *Client) UnmarshalWrappedResponse(resp *http.Response, target any) error
 	return nil
 }
 
+// newAPIError returns an APIError for the unexpected response resp with the given body.
+func (c *Client) newAPIError(resp *http.Response, body []byte) *APIError {
+	e := &APIError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       body,
+		bodyLimit:  c.errorBodyLimit,
+		verbose:    c.verboseErrors,
+	}
+	if resp.Request != nil {
+		e.Method = resp.Request.Method
+		if resp.Request.URL != nil {
+			e.URL = resp.Request.URL.Redacted()
+		}
+	}
+	return e
+}
+
 func (c *Client) prepareGet(ctx context.Context, paths ...string) (*http.Request, error) {
 	url := c.baseURL.JoinPath(paths...).String()
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return nil, err
 	}
+	for key, values := range c.headers {
+		req.Header[key] = slices.Clone(values)
+	}
 	req.Header.Add("Content-Type", "application/json")
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
 	req.SetBasicAuth(c.username, c.password)
 	return req, nil
 }
 
+// protectedHeaders are managed by the client and cannot be set using WithHeader.
+var protectedHeaders = []string{"Authorization", "Content-Type"}
+
+// validateHeaders checks that custom headers have valid names and values
+// and do not override headers managed by the client.
+func validateHeaders(h http.Header) error {
+	for key, values := range h {
+		if key == "" || strings.ContainsFunc(key, func(r rune) bool {
+			return r <= ' ' || r >= 0x7f || strings.ContainsRune(`"(),/:;<=>?@[\]{}`, r)
+		}) {
+			return fmt.Errorf("invalid header name %q", key)
+		}
+		if slices.Contains(protectedHeaders, http.CanonicalHeaderKey(key)) {
+			return fmt.Errorf("header %q cannot be overridden", key)
+		}
+		for _, v := range values {
+			if strings.ContainsAny(v, "\r\n\x00") {
+				return fmt.Errorf("invalid value for header %q", key)
+			}
+		}
+	}
+	return nil
+}
+
 func (c *Client) DoRequest(req *http.Request, value any) error {
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
-		return fmt.Errorf("failed to %s %s: %w", req.Method, req.URL, err)
+		return fmt.Errorf("failed to %s %s: %w", req.Method, req.URL.Redacted(), err)
 	}
 	defer resp.Body.Close()
 
@@ -143,7 +412,7 @@ func (c *Client) DoRequest(req *http.Request, value any) error {
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("unexpected status code: %s %s", resp.Status, bodyText)
+		return c.newAPIError(resp, bodyText)
 	}
 
 	err = UnwrapResult(bodyText, value)
@@ -154,6 +423,77 @@ func (c *Client) DoRequest(req *http.Request, value any) error {
 	return nil
 }
 
+// streamWrappedArray decodes each element of the array in the result wrapper read from r,
+// passing each to yield as soon as it is decoded. A null or missing result yields no elements.
+func streamWrappedArray[T any](r io.Reader, yield func(T, error) bool) {
+	var zero T
+	fail := func(err error) {
+		yield(zero, fmt.Errorf("failed to decode streamed result: %w", err))
+	}
+	expectDelim := func(dec *json.Decoder, want json.Delim) error {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		if tok != want {
+			return fmt.Errorf("expected %q but found %v", want, tok)
+		}
+		return nil
+	}
+
+	dec := json.NewDecoder(r)
+	if err := expectDelim(dec, '{'); err != nil {
+		fail(err)
+		return
+	}
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			fail(err)
+			return
+		}
+		// Match object keys case-insensitively, consistent with UnwrapResult
+		if k, _ := key.(string); !strings.EqualFold(k, "result") {
+			var skipped json.RawMessage
+			if err := dec.Decode(&skipped); err != nil {
+				fail(err)
+				return
+			}
+			continue
+		}
+
+		tok, err := dec.Token()
+		if err != nil {
+			fail(err)
+			return
+		}
+		if tok == nil {
+			continue
+		}
+		if tok != json.Delim('[') {
+			fail(fmt.Errorf("expected result array but found %v", tok))
+			return
+		}
+		for dec.More() {
+			var v T
+			if err := dec.Decode(&v); err != nil {
+				fail(err)
+				return
+			}
+			if !yield(v, nil) {
+				return
+			}
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			fail(err)
+			return
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		fail(err)
+	}
+}
+
 func UnwrapResult(jsonData []byte, value any) error {
 	var wrapper Result
 
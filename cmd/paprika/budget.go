@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// errByteBudgetExhausted is returned for Paprika API requests made (and response bodies read)
+// after the maximum total number of bytes has been downloaded (see --max-total-bytes).
+var errByteBudgetExhausted = errors.New("byte budget exhausted")
+
+// byteBudget tracks the total number of response body bytes downloaded by the Paprika API clients
+// that share it (see byteBudgetMiddleware), up to a limit. It is safe for concurrent use.
+// A nil *byteBudget is valid and is never exhausted.
+type byteBudget struct {
+	limit     int64
+	used      atomic.Int64
+	once      sync.Once
+	exhausted chan struct{}
+}
+
+// newByteBudget returns a byteBudget that is exhausted once more than limit bytes have been downloaded.
+func newByteBudget(limit int64) *byteBudget {
+	return &byteBudget{limit: limit, exhausted: make(chan struct{})}
+}
+
+// add records that n bytes were downloaded, and reports whether the budget remains unexhausted.
+func (b *byteBudget) add(n int) bool {
+	if b.used.Add(int64(n)) <= b.limit {
+		return true
+	}
+	b.once.Do(func() { close(b.exhausted) })
+	return false
+}
+
+// isExhausted reports whether more than the budgeted number of bytes have been downloaded.
+func (b *byteBudget) isExhausted() bool {
+	if b == nil {
+		return false
+	}
+	select {
+	case <-b.exhausted:
+		return true
+	default:
+		return false
+	}
+}
+
+// bytesUsed returns the total number of bytes downloaded.
+func (b *byteBudget) bytesUsed() int64 {
+	if b == nil {
+		return 0
+	}
+	return b.used.Load()
+}
+
+// withCancel returns a copy of ctx that is canceled with errByteBudgetExhausted once the budget is exhausted,
+// so that in-flight work can be stopped. The returned function releases the context's resources.
+func (b *byteBudget) withCancel(ctx context.Context) (context.Context, context.CancelFunc) {
+	if b == nil {
+		return ctx, func() {}
+	}
+	ctx, cancel := context.WithCancelCause(ctx)
+	go func() {
+		select {
+		case <-b.exhausted:
+			cancel(errByteBudgetExhausted)
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, func() { cancel(context.Canceled) }
+}
+
+// byteBudgetMiddleware counts the bytes of each Paprika API response body against budget.
+// Once the budget is exhausted, reading a response body fails with errByteBudgetExhausted,
+// and further requests fail with errByteBudgetExhausted without being sent.
+func byteBudgetMiddleware(budget *byteBudget) func(http.RoundTripper) http.RoundTripper {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if budget.isExhausted() {
+				return nil, errByteBudgetExhausted
+			}
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				return resp, err
+			}
+			resp.Body = &budgetedBody{ReadCloser: resp.Body, budget: budget}
+			return resp, nil
+		})
+	}
+}
+
+// budgetedBody is a response body whose bytes are counted against a byteBudget as they are read.
+type budgetedBody struct {
+	io.ReadCloser
+	budget *byteBudget
+}
+
+func (b *budgetedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if !b.budget.add(n) {
+		return n, errByteBudgetExhausted
+	}
+	return n, err
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/TylerHendrickson/paprika"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestByteBudgetMiddleware(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		_, _ = io.WriteString(w, strings.Repeat("x", 100))
+	}))
+	defer server.Close()
+
+	budget := newByteBudget(250)
+	client := &http.Client{Transport: byteBudgetMiddleware(budget)(http.DefaultTransport)}
+	get := func() (string, error) {
+		resp, err := client.Get(server.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	for range 2 {
+		body, err := get()
+		require.NoError(t, err)
+		assert.Len(t, body, 100)
+	}
+	assert.False(t, budget.isExhausted())
+
+	_, err := get()
+	require.ErrorIs(t, err, errByteBudgetExhausted)
+	assert.True(t, budget.isExhausted())
+	assert.Equal(t, int64(300), budget.bytesUsed())
+
+	_, err = get()
+	require.ErrorIs(t, err, errByteBudgetExhausted)
+	assert.Equal(t, 3, requests, "no requests should be sent once the budget is exhausted")
+}
+
+func TestByteBudgetConcurrent(t *testing.T) {
+	budget := newByteBudget(1000)
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			for range 100 {
+				budget.add(1)
+			}
+		})
+	}
+	wg.Wait()
+	assert.Equal(t, int64(1000), budget.bytesUsed())
+	assert.False(t, budget.isExhausted())
+	assert.False(t, budget.add(1))
+	assert.True(t, budget.isExhausted())
+}
+
+func TestByteBudgetWithCancel(t *testing.T) {
+	t.Run("exhausted", func(t *testing.T) {
+		budget := newByteBudget(0)
+		ctx, stop := budget.withCancel(context.Background())
+		defer stop()
+		budget.add(1)
+		<-ctx.Done()
+		assert.ErrorIs(t, context.Cause(ctx), errByteBudgetExhausted)
+	})
+
+	t.Run("stopped", func(t *testing.T) {
+		ctx, stop := newByteBudget(10).withCancel(context.Background())
+		require.NoError(t, ctx.Err())
+		stop()
+		assert.NotErrorIs(t, context.Cause(ctx), errByteBudgetExhausted)
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		var budget *byteBudget
+		ctx, stop := budget.withCancel(context.Background())
+		defer stop()
+		assert.NoError(t, ctx.Err())
+		assert.False(t, budget.isExhausted())
+	})
+}
+
+func TestSyncRunMaxTotalBytes(t *testing.T) {
+	padding := strings.Repeat("x", 4096)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/recipes" {
+			_, _ = io.WriteString(w, `{"result":[{"uid":"uid-a","hash":"h1"},{"uid":"uid-b","hash":"h2"},{"uid":"uid-c","hash":"h3"},{"uid":"uid-d","hash":"h4"}]}`)
+			return
+		}
+		uid := strings.TrimPrefix(r.URL.Path, "/recipe/")
+		hash := map[string]string{"uid-a": "h1", "uid-b": "h2", "uid-c": "h3", "uid-d": "h4"}[uid]
+		_, _ = io.WriteString(w, `{"result":{"uid":"`+uid+`","hash":"`+hash+`","notes":"`+padding+`"}}`)
+	}))
+	defer server.Close()
+
+	// The budget allows the index and one recipe to be downloaded in full
+	budget := newByteBudget(6000)
+	cli := &CLI{DataDir: t.TempDir(), MaxTotalBytes: 6000, byteBudget: budget}
+	client := newMockClient(t, server, paprika.WithMiddleware(byteBudgetMiddleware(budget)))
+	purgeAfter := PurgeAfter(0)
+	cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1, PurgeAfter: &purgeAfter}
+
+	err := cmd.Run(context.Background(), cli, client, newTestLogger())
+	require.ErrorIs(t, err, errByteBudgetExhausted)
+	assert.ErrorContains(t, err, "sync incomplete: byte budget exhausted")
+	assert.FileExists(t, pathToRecipesIndexFile(cli.DataDir))
+	assert.FileExists(t, pathToRecipeJSONFile(cli.DataDir, "uid-a"))
+	for _, uid := range []string{"uid-b", "uid-c", "uid-d"} {
+		assert.NoFileExists(t, pathToRecipeJSONFile(cli.DataDir, uid))
+	}
+	assert.Greater(t, budget.bytesUsed(), int64(6000))
+}
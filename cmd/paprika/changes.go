@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// ChangesCMD is the sub-command for listing recipe changes recorded in the journal (see sync --journal).
+// It does not make any requests to the Paprika API.
+type ChangesCMD struct {
+	Since *Date  `help:"Only list changes recorded on or after the given date (YYYY-MM-DD) or RFC 3339 timestamp." placeholder:"DATE"`
+	UID   string `help:"Only list changes to the recipe with the given UID." placeholder:"UID"`
+	JSON  bool   `help:"Print each change as a line of JSON, as recorded in the journal."`
+}
+
+func (cmd *ChangesCMD) Run(ctx context.Context, cli *CLI, log zerolog.Logger) error {
+	path := pathToJournalFile(cli.DataDir)
+	f, err := os.Open(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		log.Debug().Str("path", path).Msg("no journal file; no changes to list")
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to open journal: %w", err)
+	}
+	defer f.Close()
+
+	w := bufio.NewWriter(cli.stdout)
+	var count int
+	err = scanJournal(f, func(entry journalEntry) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !cmd.matches(entry) {
+			return nil
+		}
+		count++
+		return cmd.writeEntry(w, entry)
+	})
+	if err != nil {
+		return err
+	}
+	log.Debug().Int("changes-count", count).Msg("listed journal changes")
+	return w.Flush()
+}
+
+// matches reports whether entry satisfies the command's filters.
+func (cmd *ChangesCMD) matches(entry journalEntry) bool {
+	if cmd.UID != "" && entry.UID != cmd.UID {
+		return false
+	}
+	if cmd.Since != nil && entry.Timestamp.Before(time.Time(*cmd.Since)) {
+		return false
+	}
+	return true
+}
+
+// writeEntry writes entry to w as either a line of JSON or human-readable text.
+func (cmd *ChangesCMD) writeEntry(w io.Writer, entry journalEntry) error {
+	if cmd.JSON {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", line)
+		return err
+	}
+	hashes := entry.NewHash
+	if entry.OldHash != "" {
+		hashes = entry.OldHash + " -> " + entry.NewHash
+	}
+	_, err := fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", entry.Timestamp.Format(time.RFC3339), entry.Action, entry.UID, hashes)
+	return err
+}
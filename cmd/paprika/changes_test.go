@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangesCMDRun(t *testing.T) {
+	dataDir := t.TempDir()
+	day := func(d int) time.Time { return time.Date(2024, time.March, d, 12, 0, 0, 0, time.UTC) }
+	for _, entry := range []journalEntry{
+		{Timestamp: day(1), Action: "create", UID: "aaaaa", NewHash: "h1"},
+		{Timestamp: day(2), Action: "create", UID: "bbbbb", NewHash: "h2"},
+		{Timestamp: day(3), Action: "update", UID: "aaaaa", OldHash: "h1", NewHash: "h3"},
+	} {
+		require.NoError(t, appendJournalEntry(pathToJournalFile(dataDir), entry))
+	}
+
+	runChanges := func(t *testing.T, cmd ChangesCMD, dataDir string) string {
+		out, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+		require.NoError(t, err)
+		defer out.Close()
+		require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: dataDir, stdout: out}, newTestLogger()))
+		data, err := os.ReadFile(out.Name())
+		require.NoError(t, err)
+		return string(data)
+	}
+	since := func(t time.Time) *Date { d := Date(t); return &d }
+
+	t.Run("all", func(t *testing.T) {
+		assert.Equal(t,
+			"2024-03-01T12:00:00Z\tcreate\taaaaa\th1\n"+
+				"2024-03-02T12:00:00Z\tcreate\tbbbbb\th2\n"+
+				"2024-03-03T12:00:00Z\tupdate\taaaaa\th1 -> h3\n",
+			runChanges(t, ChangesCMD{}, dataDir))
+	})
+
+	t.Run("uid", func(t *testing.T) {
+		out := runChanges(t, ChangesCMD{UID: "aaaaa"}, dataDir)
+		assert.Equal(t, 2, strings.Count(out, "\n"))
+		assert.NotContains(t, out, "bbbbb")
+	})
+
+	t.Run("since", func(t *testing.T) {
+		out := runChanges(t, ChangesCMD{Since: since(day(2))}, dataDir)
+		assert.Equal(t, "2024-03-02T12:00:00Z\tcreate\tbbbbb\th2\n"+
+			"2024-03-03T12:00:00Z\tupdate\taaaaa\th1 -> h3\n", out)
+	})
+
+	t.Run("sinceAndUIDAsJSON", func(t *testing.T) {
+		out := runChanges(t, ChangesCMD{Since: since(day(2)), UID: "aaaaa", JSON: true}, dataDir)
+		assert.JSONEq(t, `{"timestamp":"2024-03-03T12:00:00Z","action":"update","uid":"aaaaa","old_hash":"h1","new_hash":"h3"}`, out)
+		assert.Equal(t, 1, strings.Count(out, "\n"))
+	})
+
+	t.Run("missingJournal", func(t *testing.T) {
+		assert.Empty(t, runChanges(t, ChangesCMD{}, t.TempDir()))
+	})
+
+	t.Run("malformedJournal", func(t *testing.T) {
+		dataDir := t.TempDir()
+		require.NoError(t, os.WriteFile(pathToJournalFile(dataDir), []byte("{}\nnot json\n"), 0666))
+		err := (&ChangesCMD{}).Run(context.Background(), &CLI{DataDir: dataDir, stdout: os.Stdout}, newTestLogger())
+		require.ErrorContains(t, err, "failed to decode journal entry on line 2")
+	})
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/rs/zerolog"
+)
+
+// ClearMarkersCMD is the sub-command for removing all deletion marker files, which restarts the purge grace period
+// of every unindexed recipe. It does not read the recipes index or make any requests to the Paprika API.
+type ClearMarkersCMD struct {
+	DryRun bool `help:"Log the deletion marker files that would be removed without removing them." env:"PAPRIKA_CLEAR_MARKERS_DRY_RUN"`
+}
+
+func (cmd *ClearMarkersCMD) Run(ctx context.Context, cli *CLI, log zerolog.Logger) error {
+	log = log.With().Bool("dry-run", cmd.DryRun).Logger()
+	count, err := clearDeleteMarkers(ctx, pathToRecipesDir(cli.DataDir), cmd.DryRun, log)
+	if err != nil {
+		log.Err(err).Int("cleared-markers-count", count).Msg("failed to clear deletion markers")
+		return err
+	}
+	if cmd.DryRun {
+		log.Info().Int("cleared-markers-count", count).Msg("would clear deletion markers")
+		return nil
+	}
+	log.Info().Int("cleared-markers-count", count).Msg("cleared deletion markers")
+	return nil
+}
+
+// clearDeleteMarkers removes every deletion marker file under recipesDataRoot (unless dryRun is set),
+// and returns the number of marker files removed (or that would be removed).
+// A missing recipes data root has no markers to clear.
+func clearDeleteMarkers(ctx context.Context, recipesDataRoot string, dryRun bool, log zerolog.Logger) (int, error) {
+	var count int
+	err := filepath.WalkDir(recipesDataRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == recipesDataRoot && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.IsDir() || d.Name() != filenameRecipeDeleteMarker {
+			return nil
+		}
+
+		log := log.With().Str("deletion-marker-file", path).Logger()
+		if dryRun {
+			log.Info().Msg("would remove deletion marker file")
+			count++
+			return nil
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+		log.Debug().Msg("removed deletion marker file")
+		count++
+		return nil
+	})
+	return count, err
+}
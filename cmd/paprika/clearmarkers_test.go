@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/TylerHendrickson/paprika"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClearMarkersCMDRun(t *testing.T) {
+	newDataDir := func(t *testing.T) string {
+		dataDir := t.TempDir()
+		// Markers for both indexed and unindexed recipes are cleared, since the index is not consulted
+		require.NoError(t, saveAsJSON([]paprika.RecipeItem{{UID: "aaaaa", Hash: "h1"}}, pathToRecipesIndexFile(dataDir)))
+		for _, uid := range []string{"aaaaa", "bbbbb", "ccccc", "ddddd"} {
+			require.NoError(t, saveAsJSON(paprika.Recipe{UID: uid}, pathToRecipeJSONFile(dataDir, uid)))
+		}
+		for _, uid := range []string{"aaaaa", "bbbbb", "ccccc"} {
+			marker := deleteMarker{UnindexedSince: time.Now().Add(-time.Hour), Reason: deleteMarkerReasonUnindexed}
+			require.NoError(t, writeDeleteMarker(pathToRecipeDeleteMarkerFile(dataDir, uid), marker))
+		}
+		return dataDir
+	}
+
+	t.Run("clears", func(t *testing.T) {
+		dataDir := newDataDir(t)
+		var buf safeBuffer
+		cmd := ClearMarkersCMD{}
+		require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: dataDir}, zerolog.New(&buf)))
+
+		for _, uid := range []string{"aaaaa", "bbbbb", "ccccc", "ddddd"} {
+			assert.NoFileExists(t, pathToRecipeDeleteMarkerFile(dataDir, uid))
+			assert.FileExists(t, pathToRecipeJSONFile(dataDir, uid), "recipe files should be retained")
+		}
+		assert.Contains(t, buf.String(), `"cleared-markers-count":3,"message":"cleared deletion markers"`)
+	})
+
+	t.Run("dryRun", func(t *testing.T) {
+		dataDir := newDataDir(t)
+		var buf safeBuffer
+		cmd := ClearMarkersCMD{DryRun: true}
+		require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: dataDir}, zerolog.New(&buf)))
+
+		for _, uid := range []string{"aaaaa", "bbbbb", "ccccc"} {
+			assert.FileExists(t, pathToRecipeDeleteMarkerFile(dataDir, uid))
+		}
+		assert.Contains(t, buf.String(), `"cleared-markers-count":3,"message":"would clear deletion markers"`)
+	})
+
+	t.Run("noRecipesDir", func(t *testing.T) {
+		var buf safeBuffer
+		cmd := ClearMarkersCMD{}
+		require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: t.TempDir()}, zerolog.New(&buf)))
+		assert.Contains(t, buf.String(), `"cleared-markers-count":0`)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		dataDir := newDataDir(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		cmd := ClearMarkersCMD{}
+		require.ErrorIs(t, cmd.Run(ctx, &CLI{DataDir: dataDir}, newTestLogger()), context.Canceled)
+		_, err := os.Stat(pathToRecipeDeleteMarkerFile(dataDir, "aaaaa"))
+		require.NoError(t, err)
+	})
+}
@@ -6,6 +6,9 @@ import (
 	"io"
 	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/TylerHendrickson/paprika"
 	"github.com/alecthomas/kong"
@@ -18,18 +21,76 @@ type reportedErr struct {
 	error
 }
 
+// Header is an HTTP request header parsed from "Key: Value" CLI argument input.
+type Header struct {
+	Key, Value string
+}
+
+// UnmarshalText parses CLI argument header input bytes.
+func (h *Header) UnmarshalText(b []byte) error {
+	key, value, ok := strings.Cut(string(b), ":")
+	if !ok {
+		return fmt.Errorf("header must be formatted as \"Key: Value\"")
+	}
+	key = strings.TrimSpace(key)
+	if key == "" {
+		return fmt.Errorf("header key must not be empty")
+	}
+	h.Key, h.Value = key, strings.TrimSpace(value)
+	return nil
+}
+
+// MarshalText formats the header as "Key: Value", which is accepted by UnmarshalText.
+func (h Header) MarshalText() ([]byte, error) {
+	return []byte(h.Key + ": " + h.Value), nil
+}
+
 // CLI is the command-line application root.
 type CLI struct {
 	Version     kong.VersionFlag `help:"Print version information and exit." short:"v"`
 	VersionFull VersionFullFlag  `help:"Print detailed version information and exit."`
+	NoEnv       bool             `help:"Ignore environment variables (like PAPRIKA_* and LOG_*), so that only command-line flags determine behavior."`
 
-	DataDir string `help:"Path for the directory used to store Paprika data." env:"PAPRIKA_DATA_DIR" type:"existingdir" default:"data"`
+	DataDir             string   `help:"Path for the directory used to store Paprika data." env:"PAPRIKA_DATA_DIR" type:"existingdir" default:"data"`
+	ExtraDataDirs       []string `help:"Additional data directory (e.g. an older backup) from which commands that only read local recipes (like export) also read recipes. May be repeated. When a recipe is found in multiple data directories, its most recently modified recipe file is used." name:"extra-data-dir" env:"PAPRIKA_EXTRA_DATA_DIRS" type:"existingdir" placeholder:"PATH"`
+	RecipesIndexName    string   `help:"Path of the recipes index file, relative to the data directory." env:"PAPRIKA_RECIPES_INDEX_NAME" default:"${recipesIndexFile}" placeholder:"PATH"`
+	IndexFormat         string   `help:"Format of the saved recipes index file: json (a JSON array) or ndjson (newline-delimited JSON, with one recipe item per line, for streaming consumers). Saved indexes in either format are read regardless of this setting. [default: ${default}]" enum:"json,ndjson" default:"json" env:"PAPRIKA_INDEX_FORMAT" placeholder:"FORMAT"`
+	CategoriesIndexName string   `help:"Path of the categories index file, relative to the data directory." env:"PAPRIKA_CATEGORIES_INDEX_NAME" default:"${categoriesIndexFile}" placeholder:"PATH"`
+	ContentAddressed    bool     `help:"Store each recipe in a file named by its hash (e.g. recipes/.../<uid>/<hash>.json) rather than recipe.json, so that identical recipe versions can be deduplicated (e.g. by hard links) across backups. Prior versions are retained alongside the current version, which is identified by the recipes index." env:"PAPRIKA_CONTENT_ADDRESSED"`
+	MirrorDir           string   `help:"Secondary directory to which each saved recipe file is also written, and from which unindexed recipes are also purged (according to the recipes index of the data directory), for redundancy. Failures to write to or purge the mirror directory are logged as warnings." env:"PAPRIKA_MIRROR_DIR" placeholder:"PATH"`
+	TempDir             string   `help:"Directory in which to stage files before they are atomically moved into place. If it is on a different filesystem than the data directory, staged files are copied alongside their destination before they are moved. [default: (the destination file's directory)]" env:"PAPRIKA_TEMP_DIR" type:"existingdir" placeholder:"PATH"`
+	JSONTrailingNewline bool     `help:"End saved recipe and index files with a newline." negatable:"" default:"true" env:"PAPRIKA_JSON_TRAILING_NEWLINE"`
 
-	PaprikaUsername string   `help:"Username for Paprika API auth." env:"PAPRIKA_USER"`
-	PaprikaPassword string   `help:"Password for Paprika API auth." env:"PAPRIKA_PASSWORD"`
-	PaprikaBaseURL  *url.URL `help:"Base URL for the Paprika API." env:"PAPRIKA_BASE_URL" hidden:""`
+	Preset string `help:"Named preset for download and request throttling settings, which apply unless set explicitly by flags or environment variables (${presetHelp})." env:"PAPRIKA_PRESET" placeholder:"NAME"`
 
-	Sync SyncCMD `cmd:"" name:"sync" help:"Sync (back up) data from the Paprika API to the local file system."`
+	PaprikaUsername   string   `help:"Username for Paprika API auth." env:"PAPRIKA_USER"`
+	PaprikaPassword   string   `help:"Password for Paprika API auth." env:"PAPRIKA_PASSWORD"`
+	PaprikaBaseURL    *url.URL `help:"Base URL for the Paprika API." env:"PAPRIKA_BASE_URL" hidden:""`
+	FixtureDir        string   `help:"Read recipes and categories to sync from the JSON files in the given directory (laid out like the data directory, e.g. a previous backup) instead of the Paprika API. Intended for development and demonstrations." type:"existingdir" env:"PAPRIKA_FIXTURE_DIR" placeholder:"PATH"`
+	DisableHTTP2      bool     `help:"Disable HTTP/2 and use HTTP/1.1 exclusively for Paprika API requests." env:"PAPRIKA_DISABLE_HTTP2"`
+	DisableKeepAlives bool     `help:"Disable HTTP keep-alives, so that no idle connections to the Paprika API linger. Useful for short-lived (e.g. scheduled one-shot) syncs on constrained devices." name:"disable-keepalives" env:"PAPRIKA_DISABLE_KEEPALIVES"`
+	TraceHTTP         bool     `help:"Log the DNS lookup, connection, TLS handshake, and time-to-first-byte events of each Paprika API request. Requires --log-level=trace." name:"trace-http" env:"PAPRIKA_TRACE_HTTP"`
+	UserAgent         string   `help:"User-Agent header to send with Paprika API requests." default:"${userAgent}" env:"PAPRIKA_USER_AGENT"`
+	ErrorBodyLimit    int      `help:"Maximum number of bytes of a Paprika API error response body to include in error messages. The complete body is logged at debug level. Set to zero to disable truncation." default:"${errorBodyLimit}" env:"PAPRIKA_ERROR_BODY_LIMIT" placeholder:"BYTES"`
+	VerboseErrors     bool     `help:"Include the request method and URL, and the response status and body, in multi-line Paprika API error messages (e.g. for bug reports). Credentials are never included." env:"PAPRIKA_VERBOSE_ERRORS"`
+	MaxTotalBytes     int64    `help:"Maximum total number of bytes of Paprika API responses to download. Once exceeded, no further requests are made and in-flight downloads are canceled, so that a sync stops early (with an error) after saving the recipes downloaded so far. Set to zero for no limit." default:"0" env:"PAPRIKA_MAX_TOTAL_BYTES" placeholder:"BYTES"`
+	CaptureHeaders    []string `help:"Comma-separated names of Paprika API response headers (e.g. X-RateLimit-Remaining,ETag) whose values are logged for each response at debug level." env:"PAPRIKA_CAPTURE_HEADERS" placeholder:"NAMES"`
+	Headers           []Header `help:"Additional header to send with every Paprika API request. May be repeated." name:"header" sep:"none" placeholder:"'KEY: VALUE'"`
+
+	RateLimit    float64       `help:"Maximum number of Paprika API requests to send per second, shared by all download workers. Set to zero for no limit." default:"0" env:"PAPRIKA_RATE_LIMIT" placeholder:"N"`
+	RequestDelay time.Duration `help:"Time to wait before sending each Paprika API request, in addition to any wait imposed by --rate-limit." default:"0" env:"PAPRIKA_REQUEST_DELAY" placeholder:"DURATION"`
+
+	Sync         SyncCMD         `cmd:"" name:"sync" help:"Sync (back up) data from the Paprika API to the local file system."`
+	Purge        PurgeCMD        `cmd:"" name:"purge" help:"Purge local data for recipes that are absent from the saved recipes index, without contacting the Paprika API."`
+	ClearMarkers ClearMarkersCMD `cmd:"" name:"clear-markers" help:"Remove all deletion markers, restarting the purge grace period of unindexed recipes, without contacting the Paprika API."`
+	Reindex      ReindexCMD      `cmd:"" name:"reindex" help:"Rebuild the recipes index file from locally-stored recipe files (e.g. if it is lost or corrupt), without contacting the Paprika API."`
+	Export       ExportCMD       `cmd:"" name:"export" help:"Export locally-stored recipes, without contacting the Paprika API."`
+	Import       ImportCMD       `cmd:"" name:"import" help:"Import recipes from a .paprikarecipes file into the local data directory, without contacting the Paprika API."`
+	Raw          RawCMD          `cmd:"" name:"raw" help:"Request an arbitrary Paprika API endpoint and print its result." hidden:""`
+	Config       ConfigCMD       `cmd:"" name:"config" help:"Print the resolved configuration as JSON (with secrets redacted), without contacting the Paprika API."`
+	Stats        StatsCMD        `cmd:"" name:"stats" help:"Report statistics about locally-stored recipes, like the number of recipes in each category, without contacting the Paprika API."`
+	Changes      ChangesCMD      `cmd:"" name:"changes" help:"List recipe changes recorded in the journal (see sync --journal)."`
+	RecipeDiff   RecipeDiffCMD   `cmd:"" name:"recipe-diff" help:"Show the differences between stored versions of a recipe (see sync --keep-versions)."`
 
 	LoggingOpts struct {
 		Level  zerolog.Level `help:"Minimum log level. [default: ${default}] " enum:"${logLevelEnum}" default:"INFO" env:"LOG_LEVEL"`
@@ -39,16 +100,65 @@ type CLI struct {
 		} `embed:""`
 		TimestampLayout string `help:"Layout for formatting logged timestamps. Expects a Go time layout string. [default: \"${default}\" (${logTimestampDefaultName})] " default:"${logTimestampDefaultLayout}" placeholder:"LAYOUT" env:"LOG_TIMESTAMP_LAYOUT"`
 		NoColor         bool   `help:"Disable colorized log output (affects pretty logs only). " default:"false" env:"NO_COLOR,LOG_NO_COLOR"`
+		TimeField       string `help:"Name of the timestamp field in logged events. [default: ${default}] " default:"time" placeholder:"NAME" env:"LOG_TIME_FIELD"`
+		MessageField    string `help:"Name of the message field in logged events. [default: ${default}] " default:"message" placeholder:"NAME" env:"LOG_MESSAGE_FIELD"`
+		LevelField      string `help:"Name of the level field in logged events. [default: ${default}] " default:"level" placeholder:"NAME" env:"LOG_LEVEL_FIELD"`
+		KeyStyle        string `help:"Naming style for logged field keys (e.g. recipe-uid or recipe_uid). [default: ${default}] " enum:"dash,snake" default:"dash" placeholder:"STYLE" env:"LOG_KEY_STYLE"`
+		KeyPrefix       string `help:"Prefix for logged field keys, excluding the timestamp, level, message, error, and caller fields." placeholder:"PREFIX" env:"LOG_KEY_PREFIX"`
 	} `embed:"" prefix:"log-" group:"Logging Options" description:"Control Logging Behaviors"`
 
 	// Not controllable through CLI arguments:
 	// CLI output streams
 	stdout, stderr *os.File
+	// Total bytes downloaded by Paprika API clients, if limited by --max-total-bytes
+	byteBudget *byteBudget
+	// Spacing of Paprika API requests, if throttled by --rate-limit or --request-delay
+	requestThrottle *requestThrottle
+}
+
+// Validate checks the CLI configuration state after parsing.
+func (cli *CLI) Validate() error {
+	for _, f := range []struct{ flag, name string }{
+		{"--recipes-index-name", cli.RecipesIndexName},
+		{"--categories-index-name", cli.CategoriesIndexName},
+	} {
+		if !filepath.IsLocal(f.name) {
+			return fmt.Errorf("%s must be a relative path within the data directory", f.flag)
+		}
+	}
+	for _, f := range []struct{ flag, name string }{
+		{"--log-time-field", cli.LoggingOpts.TimeField},
+		{"--log-message-field", cli.LoggingOpts.MessageField},
+		{"--log-level-field", cli.LoggingOpts.LevelField},
+	} {
+		if strings.TrimSpace(f.name) == "" {
+			return fmt.Errorf("%s must not be empty", f.flag)
+		}
+	}
+	if cli.ContentAddressed && cli.Sync.KeepVersions > 0 {
+		return fmt.Errorf("--keep-versions cannot be used with --content-addressed, which retains all versions")
+	}
+	if cli.MirrorDir != "" && filepath.Clean(cli.MirrorDir) == filepath.Clean(cli.DataDir) {
+		return fmt.Errorf("--mirror-dir must not be the data directory")
+	}
+	if cli.RateLimit < 0 {
+		return fmt.Errorf("--rate-limit must not be negative")
+	}
+	if cli.RequestDelay < 0 {
+		return fmt.Errorf("--request-delay must not be negative")
+	}
+	if _, ok := presets[cli.Preset]; cli.Preset != "" && !ok {
+		return fmt.Errorf("--preset must be one of %s", strings.Join(presetNames(), ", "))
+	}
+	return nil
 }
 
 // newLogger creates and returns a new logger according to the CLI configuration state.
 func (cli *CLI) newLogger() zerolog.Logger {
 	zerolog.TimeFieldFormat = cli.LoggingOpts.TimestampLayout
+	zerolog.TimestampFieldName = cli.LoggingOpts.TimeField
+	zerolog.MessageFieldName = cli.LoggingOpts.MessageField
+	zerolog.LevelFieldName = cli.LoggingOpts.LevelField
 	var logWriter io.Writer = cli.stderr
 	if (isatty.IsTerminal(cli.stderr.Fd()) || cli.LoggingOpts.Format.Pretty) && !cli.LoggingOpts.Format.JSON {
 		logWriter = zerolog.NewConsoleWriter(func(w *zerolog.ConsoleWriter) {
@@ -57,6 +167,7 @@ func (cli *CLI) newLogger() zerolog.Logger {
 			w.NoColor = cli.LoggingOpts.NoColor
 		})
 	}
+	logWriter = newLogKeyWriter(logWriter, cli.LoggingOpts.KeyStyle, cli.LoggingOpts.KeyPrefix)
 	logger := zerolog.New(logWriter).With().
 		Timestamp().
 		Logger().
@@ -68,24 +179,88 @@ func (cli *CLI) newLogger() zerolog.Logger {
 	return logger
 }
 
-// AfterApply is a hook that configures the application after parsing.
-func (cli *CLI) AfterApply(ctx context.Context, kctx *kong.Context) error {
-	kctx.Bind(cli)
-	logger := cli.newLogger().With().Str("dataDir", cli.DataDir).Logger()
-	kctx.Bind(logger)
+// newPaprikaClient creates and returns a new Paprika API client according to the CLI configuration state.
+func (cli *CLI) newPaprikaClient(logger zerolog.Logger) (*paprika.Client, error) {
+	clientOpts := []paprika.ClientOption{
+		paprika.WithMiddleware(connDiagnosticsMiddleware(logger)),
+		paprika.WithErrorBodyLimit(cli.ErrorBodyLimit),
+		paprika.WithUserAgent(cli.UserAgent),
+	}
+	if cli.DisableHTTP2 {
+		clientOpts = append(clientOpts, paprika.WithDisableHTTP2())
+	}
+	if cli.byteBudget != nil {
+		clientOpts = append(clientOpts, paprika.WithMiddleware(byteBudgetMiddleware(cli.byteBudget)))
+	}
+	if cli.requestThrottle != nil {
+		clientOpts = append(clientOpts, paprika.WithMiddleware(throttleMiddleware(cli.requestThrottle)))
+	}
+	if cli.VerboseErrors {
+		clientOpts = append(clientOpts, paprika.WithVerboseErrors())
+	}
+	if len(cli.CaptureHeaders) > 0 {
+		clientOpts = append(clientOpts, paprika.WithMiddleware(captureHeadersMiddleware(cli.CaptureHeaders, logger)))
+	}
+	if cli.DisableKeepAlives {
+		clientOpts = append(clientOpts, paprika.WithDisableKeepAlives())
+	}
+	if cli.TraceHTTP {
+		if logger.GetLevel() > zerolog.TraceLevel {
+			logger.Warn().Msg("--trace-http has no effect unless the log level is trace")
+		}
+		clientOpts = append(clientOpts, paprika.WithMiddleware(httpTraceMiddleware(logger)))
+	}
+	for _, h := range cli.Headers {
+		clientOpts = append(clientOpts, paprika.WithHeader(h.Key, h.Value))
+	}
 	var (
 		paprikaClient    *paprika.Client
 		paprikaClientErr error
 	)
 	if cli.PaprikaBaseURL != nil {
-		paprikaClient, paprikaClientErr = paprika.NewClientWithURL(cli.PaprikaUsername, cli.PaprikaPassword, cli.PaprikaBaseURL)
+		paprikaClient, paprikaClientErr = paprika.NewClientWithURL(cli.PaprikaUsername, cli.PaprikaPassword, cli.PaprikaBaseURL, clientOpts...)
 	} else {
-		paprikaClient, paprikaClientErr = paprika.NewClient(cli.PaprikaUsername, cli.PaprikaPassword)
+		paprikaClient, paprikaClientErr = paprika.NewClient(cli.PaprikaUsername, cli.PaprikaPassword, clientOpts...)
 	}
 	if paprikaClientErr != nil {
-		return fmt.Errorf("failed to create Paprika API client: %w", paprikaClientErr)
+		return nil, fmt.Errorf("failed to create Paprika API client: %w", paprikaClientErr)
+	}
+	return paprikaClient, nil
+}
+
+// AfterApply is a hook that configures the application after parsing.
+func (cli *CLI) AfterApply(ctx context.Context, kctx *kong.Context) error {
+	cli.applyPreset(kctx)
+	kctx.Bind(cli)
+	logger := cli.newLogger().With().Str("dataDir", cli.DataDir).Logger()
+	kctx.Bind(logger)
+	if cli.MaxTotalBytes > 0 {
+		cli.byteBudget = newByteBudget(cli.MaxTotalBytes)
+	}
+	if cli.RateLimit > 0 || cli.RequestDelay > 0 {
+		cli.requestThrottle = newRequestThrottle(cli.RateLimit, cli.RequestDelay)
+	}
+	if cli.TempDir != "" {
+		if err := checkAtomicWriteTempDir(cli.TempDir, cli.DataDir, os.Rename, logger); err != nil {
+			return err
+		}
+	}
+	// The Paprika API client is only created for commands that require it,
+	// so that commands operating on local data alone do not require API credentials.
+	if err := kctx.BindSingletonProvider(func() (*paprika.Client, error) {
+		return cli.newPaprikaClient(logger)
+	}); err != nil {
+		return err
+	}
+	if err := kctx.BindSingletonProvider(func() (RecipeFetcher, error) {
+		if cli.FixtureDir != "" {
+			logger.Info().Str("fixture-dir", cli.FixtureDir).Msg("reading recipe data from fixture files instead of the Paprika API")
+			return &FixtureClient{Dir: cli.FixtureDir}, nil
+		}
+		return cli.newPaprikaClient(logger)
+	}); err != nil {
+		return err
 	}
-	kctx.Bind(paprikaClient)
 
 	logger.Debug().
 		// zerolog.Array.Type() does not exist; see https://github.com/rs/zerolog/issues/729
@@ -93,9 +268,10 @@ func (cli *CLI) AfterApply(ctx context.Context, kctx *kong.Context) error {
 		Array("bound-types", zerolog.Arr().
 			Str(fmt.Sprintf("%T", cli)).
 			Str(fmt.Sprintf("%T", logger)).
-			Str(fmt.Sprintf("%T", paprikaClient)),
+			Str(fmt.Sprintf("%T", (*paprika.Client)(nil))).
+			Str(fmt.Sprintf("%T", (*RecipeFetcher)(nil))),
 		).Msg("adding bindings to application context")
 
-	logger.Trace().Interface("configuration", cli).Msg("dump final application configuration")
+	logger.Trace().Interface("configuration", cli.redactedConfig()).Msg("dump final application configuration")
 	return nil
 }
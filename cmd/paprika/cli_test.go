@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeaderUnmarshalText(t *testing.T) {
+	var h Header
+	require.NoError(t, h.UnmarshalText([]byte("CF-Access-Client-Id:  abc:123 ")))
+	assert.Equal(t, Header{Key: "CF-Access-Client-Id", Value: "abc:123"}, h)
+
+	require.EqualError(t, h.UnmarshalText([]byte("no-separator")), `header must be formatted as "Key: Value"`)
+	require.EqualError(t, h.UnmarshalText([]byte(" : value")), "header key must not be empty")
+
+	text, err := Header{Key: "X-Key", Value: "a: b"}.MarshalText()
+	require.NoError(t, err)
+	require.NoError(t, h.UnmarshalText(text))
+	assert.Equal(t, Header{Key: "X-Key", Value: "a: b"}, h)
+}
+
+func TestHeaderFlagRepeatable(t *testing.T) {
+	var cli struct {
+		Headers []Header `name:"header" sep:"none"`
+	}
+	parser, err := kong.New(&cli)
+	require.NoError(t, err)
+	_, err = parser.Parse([]string{"--header", "X-One: a,b", "--header", "X-Two: c"})
+	require.NoError(t, err)
+	assert.Equal(t, []Header{{Key: "X-One", Value: "a,b"}, {Key: "X-Two", Value: "c"}}, cli.Headers)
+}
+
+// validTestCLI returns a CLI configured with valid values for all validated flags.
+func validTestCLI() *CLI {
+	cli := &CLI{RecipesIndexName: filenameRecipesIndex, CategoriesIndexName: filenameCategoriesIndex}
+	cli.LoggingOpts.TimeField = zerolog.TimestampFieldName
+	cli.LoggingOpts.MessageField = zerolog.MessageFieldName
+	cli.LoggingOpts.LevelField = zerolog.LevelFieldName
+	return cli
+}
+
+func TestCLIValidateIndexNames(t *testing.T) {
+	cli := validTestCLI()
+	cli.RecipesIndexName, cli.CategoriesIndexName = "recipes.json", "sub/categories.json"
+	require.NoError(t, cli.Validate())
+
+	cli = validTestCLI()
+	cli.RecipesIndexName = "../recipes.json"
+	require.EqualError(t, cli.Validate(), "--recipes-index-name must be a relative path within the data directory")
+
+	cli = validTestCLI()
+	cli.CategoriesIndexName = "/tmp/c.json"
+	require.EqualError(t, cli.Validate(), "--categories-index-name must be a relative path within the data directory")
+}
+
+func TestCLIValidateLogFieldNames(t *testing.T) {
+	cli := validTestCLI()
+	cli.LoggingOpts.TimeField = " "
+	require.EqualError(t, cli.Validate(), "--log-time-field must not be empty")
+
+	cli = validTestCLI()
+	cli.LoggingOpts.MessageField = ""
+	require.EqualError(t, cli.Validate(), "--log-message-field must not be empty")
+
+	cli = validTestCLI()
+	cli.LoggingOpts.LevelField = ""
+	require.EqualError(t, cli.Validate(), "--log-level-field must not be empty")
+}
+
+func TestCLIValidateThrottle(t *testing.T) {
+	cli := validTestCLI()
+	cli.RateLimit, cli.RequestDelay = 0.5, time.Second
+	require.NoError(t, cli.Validate())
+
+	cli = validTestCLI()
+	cli.RateLimit = -1
+	require.EqualError(t, cli.Validate(), "--rate-limit must not be negative")
+
+	cli = validTestCLI()
+	cli.RequestDelay = -time.Second
+	require.EqualError(t, cli.Validate(), "--request-delay must not be negative")
+}
+
+// restoreZerologFieldNamesCleanup restores the global zerolog field names (which are set by newLogger) after each test.
+func restoreZerologFieldNamesCleanup(t *testing.T) {
+	timestamp, message, level := zerolog.TimestampFieldName, zerolog.MessageFieldName, zerolog.LevelFieldName
+	t.Cleanup(func() {
+		zerolog.TimestampFieldName, zerolog.MessageFieldName, zerolog.LevelFieldName = timestamp, message, level
+	})
+}
+
+func TestNewLoggerFieldNames(t *testing.T) {
+	restoreZerologFieldNamesCleanup(t)
+	stderr, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	require.NoError(t, err)
+	defer stderr.Close()
+
+	cli := validTestCLI()
+	cli.stderr = stderr
+	cli.LoggingOpts.Format.JSON = true
+	cli.LoggingOpts.TimestampLayout = time.RFC3339
+	cli.LoggingOpts.TimeField, cli.LoggingOpts.MessageField, cli.LoggingOpts.LevelField = "@timestamp", "msg", "severity"
+	log := cli.newLogger()
+	log.Warn().Msg("hello")
+
+	data, err := os.ReadFile(stderr.Name())
+	require.NoError(t, err)
+	var event map[string]any
+	require.NoError(t, json.Unmarshal(data, &event))
+	assert.Equal(t, "hello", event["msg"])
+	assert.Equal(t, "warn", event["severity"])
+	assert.Contains(t, event, "@timestamp")
+	assert.Len(t, event, 3)
+}
+
+func TestNewPaprikaClientUserAgent(t *testing.T) {
+	resetBuildVarsTestCleanup(t)
+	BuildVersion = "v9.8.7"
+
+	baseURL, err := url.Parse("https://example.com/api/")
+	require.NoError(t, err)
+	cli := &CLI{PaprikaUsername: "user", PaprikaPassword: "pass", PaprikaBaseURL: baseURL, UserAgent: defaultUserAgent()}
+	c, err := cli.newPaprikaClient(newTestLogger())
+	require.NoError(t, err)
+
+	req, err := c.RecipesRequest(context.Background())
+	require.NoError(t, err)
+	assert.Contains(t, req.Header.Get("User-Agent"), "/v9.8.7 ")
+}
@@ -0,0 +1,13 @@
+package main
+
+import "time"
+
+// Clock provides the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is a Clock that reports the current system time.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
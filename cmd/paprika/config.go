@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"slices"
+)
+
+// redacted replaces secret configuration values in output.
+const redacted = "REDACTED"
+
+// ConfigCMD is the sub-command for printing the resolved configuration (from flags, environment variables,
+// and defaults). It does not make any requests to the Paprika API.
+type ConfigCMD struct{}
+
+func (cmd *ConfigCMD) Run(cli *CLI) error {
+	out, err := json.MarshalIndent(cli.redactedConfig(), "", "  ")
+	if err != nil {
+		return err
+	}
+	out = append(out, '\n')
+	_, err = cli.stdout.Write(out)
+	return err
+}
+
+// configView is a view of the CLI configuration with secret values redacted.
+// Its fields shadow the corresponding fields of the embedded CLI when encoded.
+type configView struct {
+	*CLI
+	PaprikaPassword string
+	PaprikaBaseURL  string
+	Headers         []Header
+}
+
+// redactedConfig returns the configuration with the password and header values redacted,
+// which is suitable for printing or logging.
+func (cli *CLI) redactedConfig() configView {
+	v := configView{CLI: cli, Headers: slices.Clone(cli.Headers)}
+	if cli.PaprikaPassword != "" {
+		v.PaprikaPassword = redacted
+	}
+	if cli.PaprikaBaseURL != nil {
+		v.PaprikaBaseURL = cli.PaprikaBaseURL.Redacted()
+	}
+	for i := range v.Headers {
+		v.Headers[i].Value = redacted
+	}
+	return v
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/TylerHendrickson/paprika"
+	"github.com/rs/zerolog"
+)
+
+// dataDirs returns the data directory followed by any extra data directories (see --extra-data-dir)
+// from which local recipes are read.
+func (cli *CLI) dataDirs() []string {
+	return append([]string{cli.DataDir}, cli.ExtraDataDirs...)
+}
+
+// loadLocalRecipes reads the indexed recipes stored in each of the CLI's data directories.
+// Recipes found in multiple data directories are de-duplicated by UID, keeping the recipe whose file was
+// most recently modified (or, when modified at the same time, the one from the earliest data directory).
+// Recipes are returned in the order in which they are first indexed. Indexed recipes without a local recipe file
+// are skipped.
+func loadLocalRecipes(ctx context.Context, cli *CLI, log zerolog.Logger) ([]paprika.Recipe, error) {
+	type localRecipe struct {
+		recipe  paprika.Recipe
+		modTime time.Time
+	}
+	var uids []string
+	byUID := make(map[string]localRecipe)
+	for _, dataDir := range cli.dataDirs() {
+		index, err := LoadRecipesIndex(cli.recipesIndexFileIn(dataDir))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range index {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			path := cli.recipeFile(dataDir, item.UID, item.Hash)
+			log := log.With().Str("recipe-uid", item.UID).Str("recipe-file", path).Logger()
+			info, err := os.Stat(path)
+			if errors.Is(err, fs.ErrNotExist) {
+				log.Warn().Msg("skipping indexed recipe without a local recipe file")
+				continue
+			} else if err != nil {
+				log.Err(err).Msg("failed to read recipe file")
+				return nil, fmt.Errorf("failed to read recipe %q: %w", item.UID, err)
+			}
+
+			existing, seen := byUID[item.UID]
+			if seen && !info.ModTime().After(existing.modTime) {
+				log.Debug().Msg("ignoring recipe file that is not newer than one from another data directory")
+				continue
+			}
+			recipe, err := readRecipeFile(path)
+			if err != nil {
+				log.Err(err).Msg("failed to read recipe file")
+				return nil, fmt.Errorf("failed to read recipe %q: %w", item.UID, err)
+			}
+			if !seen {
+				uids = append(uids, item.UID)
+			}
+			byUID[item.UID] = localRecipe{recipe: recipe, modTime: info.ModTime()}
+		}
+	}
+
+	recipes := make([]paprika.Recipe, 0, len(uids))
+	for _, uid := range uids {
+		recipes = append(recipes, byUID[uid].recipe)
+	}
+	return recipes, nil
+}
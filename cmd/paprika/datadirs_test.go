@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/TylerHendrickson/paprika"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadLocalRecipes(t *testing.T) {
+	older := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := older.Add(time.Hour)
+	setModTime := func(t *testing.T, dataDir, uid string, modTime time.Time) {
+		t.Helper()
+		require.NoError(t, os.Chtimes(pathToRecipeJSONFile(dataDir, uid), modTime, modTime))
+	}
+
+	primaryDir := newFixtureDir(t, []paprika.Recipe{
+		{UID: "aaaaa", Hash: "a1", Name: "Pancakes"},
+		{UID: "bbbbb", Hash: "b2", Name: "Waffles (new)"},
+		{UID: "ccccc", Hash: "c1", Name: "Crepes"},
+	}, nil)
+	extraDir := newFixtureDir(t, []paprika.Recipe{
+		{UID: "bbbbb", Hash: "b1", Name: "Waffles (old)"},
+		{UID: "ccccc", Hash: "c2", Name: "Crepes (new)"},
+		{UID: "ddddd", Hash: "d1", Name: "Toast"},
+	}, nil)
+	otherDir := newFixtureDir(t, []paprika.Recipe{
+		{UID: "aaaaa", Hash: "a2", Name: "Pancakes (same time)"},
+	}, nil)
+	for _, f := range []struct {
+		dataDir, uid string
+		modTime      time.Time
+	}{
+		{primaryDir, "aaaaa", older},
+		{primaryDir, "bbbbb", newer},
+		{primaryDir, "ccccc", older},
+		{extraDir, "bbbbb", older},
+		{extraDir, "ccccc", newer},
+		{extraDir, "ddddd", older},
+		{otherDir, "aaaaa", older},
+	} {
+		setModTime(t, f.dataDir, f.uid, f.modTime)
+	}
+
+	t.Run("primary data directory only", func(t *testing.T) {
+		recipes, err := loadLocalRecipes(context.Background(), &CLI{DataDir: primaryDir}, newTestLogger())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Pancakes", "Waffles (new)", "Crepes"}, recipeNames(recipes))
+	})
+
+	t.Run("merges overlapping and disjoint recipes", func(t *testing.T) {
+		cli := &CLI{DataDir: primaryDir, ExtraDataDirs: []string{extraDir, otherDir}}
+		recipes, err := loadLocalRecipes(context.Background(), cli, newTestLogger())
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Pancakes", "Waffles (new)", "Crepes (new)", "Toast"}, recipeNames(recipes))
+	})
+
+	t.Run("missing index in extra data directory", func(t *testing.T) {
+		cli := &CLI{DataDir: primaryDir, ExtraDataDirs: []string{t.TempDir()}}
+		_, err := loadLocalRecipes(context.Background(), cli, newTestLogger())
+		require.Error(t, err)
+	})
+}
+
+func recipeNames(recipes []paprika.Recipe) []string {
+	var names []string
+	for _, r := range recipes {
+		names = append(names, r.Name)
+	}
+	return names
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog"
+)
+
+// ExportCMD is the sub-command for exporting locally-stored recipes to other formats.
+// It does not make any requests to the Paprika API.
+type ExportCMD struct {
+	Paprika       string `help:"Path of a .paprikarecipes file to create, which can be imported into the Paprika app." type:"path" required:"" placeholder:"FILE"`
+	MaxNameLength int    `help:"Maximum length in bytes of exported filenames (not including extensions or the numeric suffixes of duplicate names), which are derived from recipe names. Longer names are truncated and suffixed with a hash of the full name. Set to zero for no limit." default:"200" env:"PAPRIKA_EXPORT_MAX_NAME_LENGTH" placeholder:"BYTES"`
+}
+
+func (cmd *ExportCMD) Validate() error {
+	if cmd.MaxNameLength < 0 || (cmd.MaxNameLength > 0 && cmd.MaxNameLength < minExportNameLength) {
+		return fmt.Errorf("--max-name-length must be zero or at least %d", minExportNameLength)
+	}
+	return nil
+}
+
+func (cmd *ExportCMD) Run(ctx context.Context, cli *CLI, log zerolog.Logger) error {
+	recipes, err := loadLocalRecipes(ctx, cli, log)
+	if err != nil {
+		return err
+	}
+
+	log = log.With().Str("export-file", cmd.Paprika).Logger()
+	if err := writeFileAtomic(cmd.Paprika, cli.TempDir, func(f *os.File) error {
+		return writePaprikaRecipesArchive(f, recipes, cmd.MaxNameLength)
+	}); err != nil {
+		log.Err(err).Msg("failed to write .paprikarecipes export file")
+		return err
+	}
+	log.Info().Int("exported-recipes-count", len(recipes)).Msg("exported recipes to .paprikarecipes file")
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/TylerHendrickson/paprika"
+	"github.com/alecthomas/kong"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExportCMDRunPaprika(t *testing.T) {
+	tempDir := t.TempDir()
+	recipes := []paprika.Recipe{
+		{UID: "aaaaa", Hash: "h1", Name: "Pancakes", Ingredients: "2 eggs"},
+		{UID: "bbbbb", Hash: "h2", Name: "Pancakes", Ingredients: "3 eggs"},
+		{UID: "ccccc", Hash: "h3", Name: "Mac/Cheese?"},
+	}
+	var index []paprika.RecipeItem
+	for _, r := range recipes {
+		require.NoError(t, saveAsJSON(r, pathToRecipeJSONFile(tempDir, r.UID)))
+		index = append(index, paprika.RecipeItem{UID: r.UID, Hash: r.Hash})
+	}
+	// Indexed recipe without a local file is skipped
+	index = append(index, paprika.RecipeItem{UID: "ddddd", Hash: "h4"})
+	require.NoError(t, saveAsJSON(index, pathToRecipesIndexFile(tempDir)))
+
+	exportPath := filepath.Join(t.TempDir(), "backup.paprikarecipes")
+	cmd := ExportCMD{Paprika: exportPath}
+	require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: tempDir}, newTestLogger()))
+
+	zr, err := zip.OpenReader(exportPath)
+	require.NoError(t, err)
+	defer zr.Close()
+
+	var names []string
+	var exported []paprika.Recipe
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+		rc, err := f.Open()
+		require.NoError(t, err)
+		gz, err := gzip.NewReader(rc)
+		require.NoError(t, err)
+		var r paprika.Recipe
+		require.NoError(t, json.NewDecoder(gz).Decode(&r))
+		require.NoError(t, rc.Close())
+		exported = append(exported, r)
+	}
+	assert.Equal(t, []string{"Pancakes.paprikarecipe", "Pancakes (2).paprikarecipe", "Mac_Cheese_.paprikarecipe"}, names)
+	assert.Equal(t, recipes, exported)
+}
+
+func TestSanitizeFilename(t *testing.T) {
+	assert.Equal(t, "Soup", sanitizeFilename("Soup", "uid", 0))
+	assert.Equal(t, "a_b_c", sanitizeFilename(`a/b\c`, "uid", 0))
+	assert.Equal(t, "uid", sanitizeFilename(" .. ", "uid", 0))
+	assert.Equal(t, "_etc_passwd", sanitizeFilename("../etc/passwd", "uid", 0))
+}
+
+func TestSanitizeFilenameMaxLength(t *testing.T) {
+	assert.Equal(t, "Soup", sanitizeFilename("Soup", "uid", 16), "short names should not be truncated")
+
+	long := strings.Repeat("ÄŸ🍲", 100) // 2+2+4 bytes per repetition
+	for _, maxLength := range []int{16, 17, 18, 19, 200} {
+		t.Run(fmt.Sprint(maxLength), func(t *testing.T) {
+			got := sanitizeFilename(long, "uid", maxLength)
+			assert.True(t, utf8.ValidString(got), "truncation should not split characters")
+			assert.LessOrEqual(t, len(got), maxLength)
+			assert.Greater(t, len(got), maxLength-4-1-filenameHashLength, "as much of the name as fits should be kept")
+			assert.True(t, strings.HasPrefix(long, got[:len(got)-1-filenameHashLength]))
+			assert.Regexp(t, `-[0-9a-f]{8}$`, got)
+		})
+	}
+
+	// Names with a common prefix are distinguished by their hash suffixes
+	a, b := sanitizeFilename(long+"a", "uid", 200), sanitizeFilename(long+"b", "uid", 200)
+	assert.NotEqual(t, a, b)
+	assert.Equal(t, a[:len(a)-filenameHashLength], b[:len(b)-filenameHashLength])
+}
+
+func TestExportCMDRunPaprikaMaxNameLength(t *testing.T) {
+	tempDir := t.TempDir()
+	long := strings.Repeat("Crème brûlée ", 30)
+	recipes := []paprika.Recipe{{UID: "aaaaa", Hash: "h1", Name: long}}
+	require.NoError(t, saveAsJSON(recipes[0], pathToRecipeJSONFile(tempDir, "aaaaa")))
+	require.NoError(t, saveAsJSON([]paprika.RecipeItem{{UID: "aaaaa", Hash: "h1"}}, pathToRecipesIndexFile(tempDir)))
+
+	var cli CLI
+	exportPath := filepath.Join(t.TempDir(), "backup.paprikarecipes")
+	parser, err := kong.New(&cli, kongVars(), kong.BindTo(context.Background(), (*context.Context)(nil)))
+	require.NoError(t, err)
+	_, err = parser.Parse([]string{"--data-dir", tempDir, "export", "--paprika", exportPath})
+	require.NoError(t, err)
+	assert.Equal(t, 200, cli.Export.MaxNameLength)
+	require.NoError(t, cli.Export.Run(context.Background(), &cli, newTestLogger()))
+
+	zr, err := zip.OpenReader(exportPath)
+	require.NoError(t, err)
+	defer zr.Close()
+	require.Len(t, zr.File, 1)
+	name := strings.TrimSuffix(zr.File[0].Name, paprikaRecipeEntryExt)
+	assert.LessOrEqual(t, len(name), 200)
+	assert.True(t, utf8.ValidString(name))
+	assert.True(t, strings.HasPrefix(long, name[:len(name)-1-filenameHashLength]))
+
+	_, err = parser.Parse([]string{"--data-dir", tempDir, "export", "--paprika", exportPath, "--max-name-length", "8"})
+	assert.ErrorContains(t, err, "--max-name-length must be zero or at least 16")
+}
+
+func TestUniqueEntryName(t *testing.T) {
+	seen := map[string]struct{}{}
+	var got []string
+	for _, name := range []string{"Soup", "soup", "Soup", "Stew"} {
+		got = append(got, uniqueEntryName(name, seen))
+	}
+	assert.Equal(t, []string{"Soup", "soup (2)", "Soup (3)", "Stew"}, got)
+}
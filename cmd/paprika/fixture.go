@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"iter"
+	"os"
+
+	"github.com/TylerHendrickson/paprika"
+)
+
+// RecipeFetcher fetches recipe data to be synced, e.g. from the Paprika API (see paprika.Client)
+// or from local fixture files (see FixtureClient).
+type RecipeFetcher interface {
+	Recipes(ctx context.Context) ([]paprika.RecipeItem, error)
+	RecipesSeq(ctx context.Context) iter.Seq2[paprika.RecipeItem, error]
+	RecipeRaw(ctx context.Context, uid string) (json.RawMessage, error)
+	Categories(ctx context.Context) ([]paprika.Category, error)
+}
+
+var (
+	_ RecipeFetcher = (*paprika.Client)(nil)
+	_ RecipeFetcher = (*FixtureClient)(nil)
+)
+
+// FixtureClient is a RecipeFetcher that reads recipe data from JSON files in a directory laid out like
+// the data directory (e.g. a previous backup), rather than making requests to the Paprika API.
+// It is intended for development and demonstrations.
+type FixtureClient struct {
+	Dir string
+}
+
+// Recipes returns the items of the recipes index file.
+func (c *FixtureClient) Recipes(ctx context.Context) ([]paprika.RecipeItem, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return LoadRecipesIndex(pathToRecipesIndexFile(c.Dir))
+}
+
+// RecipesSeq returns an iterator over the items of the recipes index file.
+func (c *FixtureClient) RecipesSeq(ctx context.Context) iter.Seq2[paprika.RecipeItem, error] {
+	return func(yield func(paprika.RecipeItem, error) bool) {
+		items, err := c.Recipes(ctx)
+		if err != nil {
+			yield(paprika.RecipeItem{}, err)
+			return
+		}
+		for _, item := range items {
+			if !yield(item, nil) {
+				return
+			}
+		}
+	}
+}
+
+// RecipeRaw returns the contents of the recipe file for the recipe identified by uid.
+func (c *FixtureClient) RecipeRaw(ctx context.Context, uid string) (json.RawMessage, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if err := validateUID(uid, false); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(pathToRecipeJSONFile(c.Dir, uid))
+}
+
+// Categories returns the categories in the categories index file.
+func (c *FixtureClient) Categories(ctx context.Context) ([]paprika.Category, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return LoadCategories(pathToCategoriesIndexFile(c.Dir))
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"iter"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TylerHendrickson/paprika"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockFetcher is an in-memory RecipeFetcher that records the calls made to it.
+type mockFetcher struct {
+	index      []paprika.RecipeItem
+	recipes    map[string]paprika.Recipe
+	categories []paprika.Category
+
+	mu    sync.Mutex
+	calls []string
+}
+
+func (f *mockFetcher) record(call string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, call)
+}
+
+func (f *mockFetcher) Recipes(ctx context.Context) ([]paprika.RecipeItem, error) {
+	f.record("Recipes")
+	return f.index, nil
+}
+
+func (f *mockFetcher) RecipesSeq(ctx context.Context) iter.Seq2[paprika.RecipeItem, error] {
+	f.record("RecipesSeq")
+	return func(yield func(paprika.RecipeItem, error) bool) {
+		for _, item := range f.index {
+			if !yield(item, nil) {
+				return
+			}
+		}
+	}
+}
+
+func (f *mockFetcher) RecipeRaw(ctx context.Context, uid string) (json.RawMessage, error) {
+	f.record("RecipeRaw " + uid)
+	r, ok := f.recipes[uid]
+	if !ok {
+		return nil, fmt.Errorf("recipe %q not found", uid)
+	}
+	return json.Marshal(r)
+}
+
+func (f *mockFetcher) Categories(ctx context.Context) ([]paprika.Category, error) {
+	f.record("Categories")
+	return f.categories, nil
+}
+
+func TestSyncRunCategoriesFirst(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		cmd  SyncCMD
+	}{
+		{"categoriesFirst", SyncCMD{CategoriesFirst: true}},
+		{"impliedByResolveCategories", SyncCMD{ResolveCategories: true}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			dataDir := t.TempDir()
+			fetcher := &orderedFetcher{
+				mockFetcher: &mockFetcher{
+					index:      []paprika.RecipeItem{{UID: "aaaaa", Hash: "h1"}, {UID: "bbbbb", Hash: "h1"}},
+					recipes:    map[string]paprika.Recipe{"aaaaa": {UID: "aaaaa", Hash: "h1"}, "bbbbb": {UID: "bbbbb", Hash: "h1"}},
+					categories: []paprika.Category{{UID: "c1", Name: "Breakfast"}},
+				},
+				categoriesIndexFile: pathToCategoriesIndexFile(dataDir),
+			}
+			cmd := tt.cmd
+			cmd.IncludeRecipes, cmd.IncludeCategories, cmd.DownloadConcurrency = true, true, 2
+			require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: dataDir}, fetcher, newTestLogger()))
+			assert.Equal(t, []bool{true, true}, fetcher.categoriesSavedBeforeRecipe)
+		})
+	}
+}
+
+// orderedFetcher is a mockFetcher that delays fetching categories, and records whether
+// the categories index file existed when each recipe was fetched.
+type orderedFetcher struct {
+	*mockFetcher
+	categoriesIndexFile         string
+	categoriesSavedBeforeRecipe []bool
+}
+
+func (f *orderedFetcher) Categories(ctx context.Context) ([]paprika.Category, error) {
+	time.Sleep(50 * time.Millisecond)
+	return f.mockFetcher.Categories(ctx)
+}
+
+func (f *orderedFetcher) RecipeRaw(ctx context.Context, uid string) (json.RawMessage, error) {
+	_, err := os.Stat(f.categoriesIndexFile)
+	f.mu.Lock()
+	f.categoriesSavedBeforeRecipe = append(f.categoriesSavedBeforeRecipe, err == nil)
+	f.mu.Unlock()
+	return f.mockFetcher.RecipeRaw(ctx, uid)
+}
+
+// newFixtureDir creates a fixture directory containing the given recipes and categories.
+func newFixtureDir(t *testing.T, recipes []paprika.Recipe, categories []paprika.Category) string {
+	t.Helper()
+	dir := t.TempDir()
+	var index []paprika.RecipeItem
+	for _, r := range recipes {
+		require.NoError(t, saveAsJSON(r, pathToRecipeJSONFile(dir, r.UID)))
+		index = append(index, paprika.RecipeItem{UID: r.UID, Hash: r.Hash})
+	}
+	require.NoError(t, saveAsJSON(index, pathToRecipesIndexFile(dir)))
+	require.NoError(t, saveAsJSON(categories, pathToCategoriesIndexFile(dir)))
+	return dir
+}
+
+func TestSyncRunFixtureClient(t *testing.T) {
+	recipes := []paprika.Recipe{
+		{UID: "aaaaa", Hash: "h1", Name: "Pancakes", Categories: []string{"c1"}},
+		{UID: "bbbbb", Hash: "h2", Name: "Waffles"},
+	}
+	categories := []paprika.Category{{UID: "c1", Name: "Breakfast"}}
+	fetcher := &FixtureClient{Dir: newFixtureDir(t, recipes, categories)}
+
+	for _, concurrentIndex := range []bool{false, true} {
+		dataDir := t.TempDir()
+		cmd := SyncCMD{IncludeRecipes: true, IncludeCategories: true, DownloadConcurrency: 2, ConcurrentIndexAndDownload: concurrentIndex}
+		require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: dataDir}, fetcher, newTestLogger()))
+
+		for _, r := range recipes {
+			saved, err := readRecipeFile(pathToRecipeJSONFile(dataDir, r.UID))
+			require.NoError(t, err)
+			assert.Equal(t, r, saved)
+		}
+		index, err := LoadRecipesIndex(pathToRecipesIndexFile(dataDir))
+		require.NoError(t, err)
+		assert.Equal(t, []paprika.RecipeItem{{UID: "aaaaa", Hash: "h1"}, {UID: "bbbbb", Hash: "h2"}}, index)
+		savedCategories, err := LoadCategories(pathToCategoriesIndexFile(dataDir))
+		require.NoError(t, err)
+		assert.Equal(t, categories, savedCategories)
+	}
+}
+
+func TestFixtureClientErrors(t *testing.T) {
+	fetcher := &FixtureClient{Dir: t.TempDir()}
+	ctx := context.Background()
+
+	_, err := fetcher.Recipes(ctx)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = fetcher.RecipeRaw(ctx, "missing")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+	_, err = fetcher.RecipeRaw(ctx, "../escape")
+	assert.Error(t, err)
+	for _, err := range fetcher.RecipesSeq(ctx) {
+		assert.ErrorIs(t, err, os.ErrNotExist)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = fetcher.Categories(canceled)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestMainFixtureDir(t *testing.T) {
+	restoreZerologFieldNamesCleanup(t)
+	fixtureDir := newFixtureDir(t, []paprika.Recipe{{UID: "aaaaa", Hash: "h1", Name: "Pancakes"}}, nil)
+	dataDir := t.TempDir()
+	devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+	require.NoError(t, err)
+	defer devNull.Close()
+
+	// No API credentials are required when reading fixtures
+	exitCode := -1
+	Main(context.Background(), devNull, devNull,
+		[]string{"--data-dir", dataDir, "--fixture-dir", fixtureDir, "sync"},
+		func(code int) { exitCode = code })
+	require.Equal(t, -1, exitCode, "should not exit with error")
+	assert.FileExists(t, pathToRecipeJSONFile(dataDir, "aaaaa"))
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// generationNameLayout is the time layout used to name generation directories.
+// It sorts lexicographically in chronological order and is safe for use in filenames on all platforms.
+const generationNameLayout = "20060102T150405.000000000Z"
+
+// snapshotGeneration snapshots the contents of dataDir into a new generation directory
+// named for the given timestamp, then prunes the oldest generations so that at most keep remain.
+// Files are hard-linked into the snapshot using link (i.e. os.Link) where supported,
+// falling back to regular copies otherwise (e.g. if the snapshot is on a different filesystem).
+// Files that are modified in place rather than replaced (see appendedInPlace) are always copied,
+// since changes to them would otherwise also change the snapshot.
+// Existing generations are never included in a new snapshot.
+func snapshotGeneration(ctx context.Context, dataDir string, now time.Time, keep int, link func(oldname, newname string) error, log zerolog.Logger) (string, error) {
+	generationsDir := pathToGenerationsDir(dataDir)
+	snapshotDir := filepath.Join(generationsDir, now.UTC().Format(generationNameLayout))
+	log = log.With().Str("generation-dir", snapshotDir).Logger()
+
+	if err := os.MkdirAll(generationsDir, os.ModePerm); err != nil {
+		return "", err
+	}
+	if err := os.Mkdir(snapshotDir, os.ModePerm); err != nil {
+		return "", err
+	}
+
+	var linked, copied int
+	err := filepath.WalkDir(dataDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if path == generationsDir {
+			return filepath.SkipDir
+		}
+
+		rel, err := filepath.Rel(dataDir, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(snapshotDir, rel)
+
+		if d.IsDir() {
+			return os.MkdirAll(target, os.ModePerm)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if !appendedInPlace(rel) {
+			if err := link(path, target); err == nil {
+				linked++
+				return nil
+			}
+		}
+		if err := copyFile(path, target); err != nil {
+			return fmt.Errorf("copy %q: %w", path, err)
+		}
+		copied++
+		return nil
+	})
+	if err != nil {
+		log.Err(err).Msg("failed to snapshot data directory")
+		if rmErr := os.RemoveAll(snapshotDir); rmErr != nil {
+			log.Err(rmErr).Msg("failed to remove incomplete generation directory")
+		}
+		return "", err
+	}
+	log.Info().Int("linked-files", linked).Int("copied-files", copied).
+		Msg("saved new data directory generation")
+
+	pruned, err := pruneGenerations(generationsDir, keep)
+	for _, p := range pruned {
+		log.Info().Str("pruned-generation-dir", p).Msg("removed expired data directory generation")
+	}
+	if err != nil {
+		log.Err(err).Msg("failed to prune expired data directory generations")
+		return snapshotDir, err
+	}
+	return snapshotDir, nil
+}
+
+// appendedInPlace reports whether the file at the given path relative to the data directory is modified in place
+// (like the journal file, which is appended to), rather than atomically replaced like other data files.
+func appendedInPlace(rel string) bool {
+	return rel == filenameJournal
+}
+
+// listGenerations returns the names of all generation directories under generationsDir,
+// ordered from oldest to newest.
+func listGenerations(generationsDir string) ([]string, error) {
+	entries, err := os.ReadDir(generationsDir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(generationNameLayout, e.Name()); err != nil {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// pruneGenerations removes the oldest generation directories under generationsDir
+// so that at most keep remain, and returns the paths of removed directories.
+func pruneGenerations(generationsDir string, keep int) ([]string, error) {
+	names, err := listGenerations(generationsDir)
+	if err != nil {
+		return nil, err
+	}
+	var pruned []string
+	for len(names) > keep {
+		p := filepath.Join(generationsDir, names[0])
+		if err := os.RemoveAll(p); err != nil {
+			return pruned, fmt.Errorf("remove %q: %w", p, err)
+		}
+		pruned = append(pruned, p)
+		names = names[1:]
+	}
+	return pruned, nil
+}
+
+// copyFile copies the regular file at src to a new file at dst.
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
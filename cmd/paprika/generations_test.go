@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/TylerHendrickson/paprika"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotGeneration(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	t.Run("snapshotsDataDirExcludingGenerations", func(t *testing.T) {
+		tempDir := t.TempDir()
+		require.NoError(t, saveAsJSON([]paprika.RecipeItem{{UID: "abcde", Hash: "h1"}}, pathToRecipesIndexFile(tempDir)))
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: "abcde", Hash: "h1"}, pathToRecipeJSONFile(tempDir, "abcde")))
+
+		first, err := snapshotGeneration(context.Background(), tempDir, now, 3, os.Link, newTestLogger())
+		require.NoError(t, err)
+		second, err := snapshotGeneration(context.Background(), tempDir, now.Add(time.Hour), 3, os.Link, newTestLogger())
+		require.NoError(t, err)
+
+		for _, dir := range []string{first, second} {
+			_, err := os.Stat(pathToRecipeJSONFile(dir, "abcde"))
+			require.NoError(t, err)
+			_, err = os.Stat(pathToRecipesIndexFile(dir))
+			require.NoError(t, err)
+		}
+		// Previous generations must not be nested in later snapshots
+		_, err = os.Stat(pathToGenerationsDir(second))
+		require.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("snapshotUnaffectedBySubsequentWrites", func(t *testing.T) {
+		tempDir := t.TempDir()
+		recipePath := pathToRecipeJSONFile(tempDir, "abcde")
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: "abcde", Hash: "old"}, recipePath))
+
+		snapshotDir, err := snapshotGeneration(context.Background(), tempDir, now, 1, os.Link, newTestLogger())
+		require.NoError(t, err)
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: "abcde", Hash: "new"}, recipePath))
+
+		data, err := os.ReadFile(pathToRecipeJSONFile(snapshotDir, "abcde"))
+		require.NoError(t, err)
+		assert.Contains(t, string(data), `"hash":"old"`)
+	})
+
+	t.Run("snapshotUnaffectedByJournalAppends", func(t *testing.T) {
+		tempDir := t.TempDir()
+		entry := journalEntry{Timestamp: now, Action: "create", UID: "abcde", NewHash: "h1"}
+		require.NoError(t, appendJournalEntry(pathToJournalFile(tempDir), entry))
+
+		snapshotDir, err := snapshotGeneration(context.Background(), tempDir, now, 1, os.Link, newTestLogger())
+		require.NoError(t, err)
+		before, err := os.ReadFile(pathToJournalFile(snapshotDir))
+		require.NoError(t, err)
+
+		entry.Action, entry.OldHash, entry.NewHash = "update", "h1", "h2"
+		require.NoError(t, appendJournalEntry(pathToJournalFile(tempDir), entry))
+		after, err := os.ReadFile(pathToJournalFile(snapshotDir))
+		require.NoError(t, err)
+		assert.Equal(t, string(before), string(after), "journal appends should not change snapshots")
+		assert.Equal(t, 1, strings.Count(string(after), "\n"))
+	})
+
+	t.Run("copiesWhenLinkFails", func(t *testing.T) {
+		tempDir := t.TempDir()
+		recipePath := pathToRecipeJSONFile(tempDir, "abcde")
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: "abcde", Hash: "old"}, recipePath))
+		require.NoError(t, saveAsJSON([]paprika.RecipeItem{{UID: "abcde", Hash: "old"}}, pathToRecipesIndexFile(tempDir)))
+
+		// Simulate a snapshot on a different filesystem than the data directory
+		crossDevice := func(oldname, newname string) error {
+			return &os.LinkError{Op: "link", Old: oldname, New: newname, Err: syscall.EXDEV}
+		}
+		var buf safeBuffer
+		snapshotDir, err := snapshotGeneration(context.Background(), tempDir, now, 1, crossDevice, zerolog.New(&buf))
+		require.NoError(t, err)
+		assert.Contains(t, buf.String(), `"linked-files":0,"copied-files":2`)
+
+		// Copies are unaffected by subsequent changes to the original file
+		require.NoError(t, os.WriteFile(recipePath, []byte(`{"uid":"abcde","hash":"new"}`), 0644))
+		recipe, err := readRecipeFile(pathToRecipeJSONFile(snapshotDir, "abcde"))
+		require.NoError(t, err)
+		assert.Equal(t, paprika.Recipe{UID: "abcde", Hash: "old"}, recipe)
+		index, err := LoadRecipesIndex(pathToRecipesIndexFile(snapshotDir))
+		require.NoError(t, err)
+		assert.Equal(t, []paprika.RecipeItem{{UID: "abcde", Hash: "old"}}, index)
+	})
+
+	t.Run("prunesToConfiguredGenerations", func(t *testing.T) {
+		tempDir := t.TempDir()
+		require.NoError(t, saveAsJSON([]paprika.RecipeItem{}, pathToRecipesIndexFile(tempDir)))
+
+		var snapshots []string
+		for i := range 5 {
+			dir, err := snapshotGeneration(context.Background(), tempDir, now.Add(time.Duration(i)*time.Minute), 2, os.Link, newTestLogger())
+			require.NoError(t, err)
+			snapshots = append(snapshots, filepath.Base(dir))
+		}
+
+		remaining, err := listGenerations(pathToGenerationsDir(tempDir))
+		require.NoError(t, err)
+		assert.Equal(t, snapshots[3:], remaining)
+	})
+}
+
+func TestCopyFile(t *testing.T) {
+	tempDir := t.TempDir()
+	src := filepath.Join(tempDir, "src.txt")
+	dst := filepath.Join(tempDir, "dst.txt")
+	require.NoError(t, os.WriteFile(src, []byte("data"), 0644))
+
+	require.NoError(t, copyFile(src, dst))
+	data, err := os.ReadFile(dst)
+	require.NoError(t, err)
+	assert.Equal(t, "data", string(data))
+
+	require.Error(t, copyFile(src, dst), "should not overwrite existing files")
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+
+	"github.com/TylerHendrickson/paprika"
+	"github.com/rs/zerolog"
+)
+
+// ImportCMD is the sub-command for importing recipes from a .paprikarecipes archive into the local data directory.
+// It does not make any requests to the Paprika API.
+type ImportCMD struct {
+	File                 string `arg:"" help:"Path of a .paprikarecipes file to import." type:"existingfile"`
+	NormalizeLineEndings bool   `help:"Convert CRLF and CR line endings to LF in the multi-line text fields (ingredients, directions, notes, and nutritional information) of imported recipes before saving them." env:"PAPRIKA_IMPORT_NORMALIZE_LINE_ENDINGS"`
+}
+
+func (cmd *ImportCMD) Run(ctx context.Context, cli *CLI, log zerolog.Logger) error {
+	log = log.With().Str("import-file", cmd.File).Logger()
+	recipes, err := readPaprikaRecipesArchive(cmd.File)
+	if err != nil {
+		log.Err(err).Msg("failed to read .paprikarecipes file")
+		return err
+	}
+
+	indexPath := cli.recipesIndexFile()
+	index, err := LoadRecipesIndex(indexPath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	indexPositions := make(map[string]int, len(index))
+	for i, item := range index {
+		indexPositions[item.UID] = i
+	}
+
+	var importedCount int
+	seen := make(map[string]struct{}, len(recipes))
+	for _, recipe := range recipes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		log := log.With().Str("recipe-uid", recipe.UID).Str("recipe-hash", recipe.Hash).Logger()
+		if err := validateUID(recipe.UID, true); err != nil {
+			log.Err(err).Msg("rejecting imported recipe")
+			return err
+		}
+		if _, dup := seen[recipe.UID]; dup {
+			log.Warn().Msg("archive contains duplicate recipe UID; overwriting previously-imported recipe")
+		}
+		seen[recipe.UID] = struct{}{}
+
+		if cli.ContentAddressed {
+			if err := validateRecipeHash(recipe.Hash); err != nil {
+				log.Err(err).Msg("rejecting imported recipe")
+				return err
+			}
+		}
+
+		recipePath := cli.recipeFile(cli.DataDir, recipe.UID, recipe.Hash)
+		log = log.With().Str("recipe-file", recipePath).Logger()
+		doUpdate, exists, _ := shouldSaveRecipe(recipePath, recipe.Hash, log)
+		if doUpdate {
+			if exists {
+				log.Warn().Msg("overwriting existing local recipe with imported recipe")
+			}
+			if cmd.NormalizeLineEndings {
+				recipe = paprika.NormalizeLineEndings(recipe)
+			}
+			if err := writeJSONFile(ctx, recipe, recipePath, cli.TempDir, cli.JSONTrailingNewline); err != nil {
+				log.Err(err).Msg("failed to save recipe file")
+				return err
+			}
+			log.Info().Msg("saved imported recipe file")
+			importedCount++
+		} else {
+			log.Debug().Msg("local recipe matches imported recipe")
+		}
+
+		item := paprika.RecipeItem{UID: recipe.UID, Hash: recipe.Hash}
+		if i, ok := indexPositions[recipe.UID]; ok {
+			index[i] = item
+		} else {
+			indexPositions[recipe.UID] = len(index)
+			index = append(index, item)
+		}
+	}
+
+	// Index imported recipes so that they are not treated as deleted from Paprika (and purged)
+	// before a subsequent sync replaces the index.
+	log = log.With().Str("path", indexPath).Logger()
+	if err := saveRecipesIndexFile(ctx, index, indexPath, cli.TempDir, cli.IndexFormat, cli.JSONTrailingNewline); err != nil {
+		log.Err(err).Msg("failed to update recipes index file")
+		return err
+	}
+	log.Info().Int("archived-recipes-count", len(recipes)).
+		Int("imported-recipes-count", importedCount).
+		Msg("imported recipes from .paprikarecipes file")
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TylerHendrickson/paprika"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImportCMDRun(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "import.paprikarecipes")
+	f, err := os.Create(archivePath)
+	require.NoError(t, err)
+	require.NoError(t, writePaprikaRecipesArchive(f, []paprika.Recipe{
+		{UID: "abcdef", Hash: "h1", Name: "Soup"},
+		{UID: "ghijkl", Hash: "h2", Name: "Stew"},
+		{UID: "abcdef", Hash: "h3", Name: "Better Soup"},
+	}, 0))
+	require.NoError(t, f.Close())
+
+	tempDir := t.TempDir()
+	// Existing local data is overwritten, and existing index entries are retained
+	require.NoError(t, saveAsJSON(paprika.Recipe{UID: "ghijkl", Hash: "old"}, pathToRecipeJSONFile(tempDir, "ghijkl")))
+	require.NoError(t, saveAsJSON([]paprika.RecipeItem{{UID: "mnopqr", Hash: "h9"}}, pathToRecipesIndexFile(tempDir)))
+
+	cmd := ImportCMD{File: archivePath}
+	require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: tempDir}, newTestLogger()))
+
+	abc, err := readRecipeFile(filepath.Join(tempDir, "recipes", "ab", "abc", "abcdef", filenameRecipeJSON))
+	require.NoError(t, err)
+	assert.Equal(t, paprika.Recipe{UID: "abcdef", Hash: "h3", Name: "Better Soup"}, abc)
+	ghi, err := readRecipeFile(filepath.Join(tempDir, "recipes", "gh", "ghi", "ghijkl", filenameRecipeJSON))
+	require.NoError(t, err)
+	assert.Equal(t, paprika.Recipe{UID: "ghijkl", Hash: "h2", Name: "Stew"}, ghi)
+
+	index, err := LoadRecipesIndex(pathToRecipesIndexFile(tempDir))
+	require.NoError(t, err)
+	assert.Equal(t, []paprika.RecipeItem{
+		{UID: "mnopqr", Hash: "h9"},
+		{UID: "abcdef", Hash: "h3"},
+		{UID: "ghijkl", Hash: "h2"},
+	}, index)
+}
+
+func TestImportCMDRunNormalizeLineEndings(t *testing.T) {
+	archivePath := filepath.Join(t.TempDir(), "import.paprikarecipes")
+	f, err := os.Create(archivePath)
+	require.NoError(t, err)
+	require.NoError(t, writePaprikaRecipesArchive(f, []paprika.Recipe{
+		{UID: "abcdef", Hash: "h1", Name: "Soup", Ingredients: "water\r\nsalt\r\n", Directions: "Boil.\r\n\r\nServe."},
+	}, 0))
+	require.NoError(t, f.Close())
+
+	for _, normalize := range []bool{false, true} {
+		tempDir := t.TempDir()
+		cmd := ImportCMD{File: archivePath, NormalizeLineEndings: normalize}
+		require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: tempDir}, newTestLogger()))
+
+		recipe, err := readRecipeFile(pathToRecipeJSONFile(tempDir, "abcdef"))
+		require.NoError(t, err)
+		if normalize {
+			assert.Equal(t, "water\nsalt\n", recipe.Ingredients)
+			assert.Equal(t, "Boil.\n\nServe.", recipe.Directions)
+		} else {
+			assert.Equal(t, "water\r\nsalt\r\n", recipe.Ingredients)
+			assert.Equal(t, "Boil.\r\n\r\nServe.", recipe.Directions)
+		}
+	}
+}
+
+func TestReadPaprikaRecipesArchiveInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bad.paprikarecipes")
+	require.NoError(t, os.WriteFile(path, []byte("not a zip"), 0644))
+	_, err := readPaprikaRecipesArchive(path)
+	require.Error(t, err)
+}
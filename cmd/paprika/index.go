@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/TylerHendrickson/paprika"
+)
+
+// Formats of the recipes index file supported by the --index-format flag.
+const (
+	// indexFormatJSON is a JSON array of recipe items.
+	indexFormatJSON = "json"
+	// indexFormatNDJSON is newline-delimited JSON, with one recipe item per line.
+	indexFormatNDJSON = "ndjson"
+)
+
+// LoadRecipesIndex reads the recipes index previously saved by a sync operation at path.
+// The index may be saved in either format (see --index-format), which is detected
+// by the first non-whitespace byte of the file.
+func LoadRecipesIndex(path string) ([]paprika.RecipeItem, error) {
+	const description = "recipes index"
+	f, err := openDataFile(path, description)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	index, err := decodeRecipesIndex(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("%s file %q is corrupt: %w", description, path, err)
+	}
+	return index, nil
+}
+
+// decodeRecipesIndex decodes a recipes index from r, as either a JSON array or newline-delimited JSON.
+// An index without any items in newline-delimited JSON is empty (or only whitespace).
+func decodeRecipesIndex(r *bufio.Reader) ([]paprika.RecipeItem, error) {
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			return []paprika.RecipeItem{}, nil
+		} else if err != nil {
+			return nil, err
+		}
+		if !isJSONSpace(b) {
+			r.UnreadByte()
+			if b == '[' {
+				var index []paprika.RecipeItem
+				err := json.NewDecoder(r).Decode(&index)
+				return index, err
+			}
+			break
+		}
+	}
+
+	index := []paprika.RecipeItem{}
+	dec := json.NewDecoder(r)
+	for {
+		var item paprika.RecipeItem
+		if err := dec.Decode(&item); err == io.EOF {
+			return index, nil
+		} else if err != nil {
+			return nil, err
+		}
+		index = append(index, item)
+	}
+}
+
+// isJSONSpace reports whether b is insignificant whitespace in JSON.
+func isJSONSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r'
+}
+
+// LoadCategories reads the categories index previously saved by a sync operation at path.
+func LoadCategories(path string) ([]paprika.Category, error) {
+	var categories []paprika.Category
+	if err := loadJSONFile(path, "categories index", &categories); err != nil {
+		return nil, err
+	}
+	return categories, nil
+}
+
+// loadJSONFile decodes the JSON file at path into v.
+// The returned error identifies the file by description and distinguishes missing files from corrupt ones.
+func loadJSONFile(path, description string, v any) error {
+	f, err := openDataFile(path, description)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := json.NewDecoder(f).Decode(v); err != nil {
+		return fmt.Errorf("%s file %q is corrupt: %w", description, path, err)
+	}
+	return nil
+}
+
+// openDataFile opens the file at path, which was saved by a sync operation.
+// The returned error identifies the file by description and distinguishes missing files from other failures.
+func openDataFile(path, description string) (*os.File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("%s file %q does not exist (has a sync been run?): %w", description, path, err)
+		}
+		return nil, fmt.Errorf("failed to open %s file: %w", description, err)
+	}
+	return f, nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TylerHendrickson/paprika"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadRecipesIndex(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		path := pathToRecipesIndexFile(t.TempDir())
+		expected := []paprika.RecipeItem{{UID: "abcde", Hash: "h1"}, {UID: "fghij", Hash: "h2"}}
+		require.NoError(t, saveAsJSON(expected, path))
+
+		index, err := LoadRecipesIndex(path)
+		require.NoError(t, err)
+		assert.Equal(t, expected, index)
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		path := pathToRecipesIndexFile(t.TempDir())
+		expected := []paprika.RecipeItem{{UID: "abcde", Hash: "h1"}, {UID: "fghij", Hash: "h2"}}
+		require.NoError(t, saveRecipesIndexFile(context.Background(), expected, path, "", indexFormatNDJSON, false))
+
+		index, err := LoadRecipesIndex(path)
+		require.NoError(t, err)
+		assert.Equal(t, expected, index)
+	})
+
+	t.Run("emptyNDJSON", func(t *testing.T) {
+		path := pathToRecipesIndexFile(t.TempDir())
+		require.NoError(t, saveRecipesIndexFile(context.Background(), nil, path, "", indexFormatNDJSON, true))
+
+		index, err := LoadRecipesIndex(path)
+		require.NoError(t, err)
+		assert.Empty(t, index)
+	})
+
+	t.Run("leadingWhitespace", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), filenameRecipesIndex)
+		require.NoError(t, os.WriteFile(path, []byte("\n  [{\"uid\":\"abcde\"}]"), 0644))
+
+		index, err := LoadRecipesIndex(path)
+		require.NoError(t, err)
+		assert.Equal(t, []paprika.RecipeItem{{UID: "abcde"}}, index)
+	})
+
+	t.Run("missingFile", func(t *testing.T) {
+		_, err := LoadRecipesIndex(pathToRecipesIndexFile(t.TempDir()))
+		require.ErrorIs(t, err, fs.ErrNotExist)
+		assert.ErrorContains(t, err, "recipes index file")
+	})
+
+	t.Run("corruptJSON", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), filenameRecipesIndex)
+		require.NoError(t, os.WriteFile(path, []byte(`[{"uid":"abcde"`), 0644))
+
+		_, err := LoadRecipesIndex(path)
+		require.ErrorContains(t, err, "recipes index file")
+		assert.ErrorContains(t, err, "is corrupt")
+	})
+	t.Run("corruptNDJSON", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), filenameRecipesIndex)
+		require.NoError(t, os.WriteFile(path, []byte("{\"uid\":\"abcde\"}\n{\"uid\":"), 0644))
+
+		_, err := LoadRecipesIndex(path)
+		require.ErrorContains(t, err, "recipes index file")
+		assert.ErrorContains(t, err, "is corrupt")
+	})
+}
+
+func TestLoadCategories(t *testing.T) {
+	t.Run("success", func(t *testing.T) {
+		path := pathToCategoriesIndexFile(t.TempDir())
+		expected := []paprika.Category{{UID: "cat1", Name: "Breakfast"}}
+		require.NoError(t, saveAsJSON(expected, path))
+
+		categories, err := LoadCategories(path)
+		require.NoError(t, err)
+		assert.Equal(t, expected, categories)
+	})
+
+	t.Run("missingFile", func(t *testing.T) {
+		_, err := LoadCategories(pathToCategoriesIndexFile(t.TempDir()))
+		require.ErrorIs(t, err, fs.ErrNotExist)
+		assert.ErrorContains(t, err, "categories index file")
+	})
+
+	t.Run("corruptJSON", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), filenameCategoriesIndex)
+		require.NoError(t, os.WriteFile(path, []byte(`not json`), 0644))
+
+		_, err := LoadCategories(path)
+		require.ErrorContains(t, err, "categories index file")
+		assert.ErrorContains(t, err, "is corrupt")
+	})
+}
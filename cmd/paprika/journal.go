@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// journalEntry is a single record in the recipe change journal.
+type journalEntry struct {
+	Timestamp time.Time `json:"timestamp"`
+	Action    string    `json:"action"`
+	UID       string    `json:"uid"`
+	OldHash   string    `json:"old_hash,omitempty"`
+	NewHash   string    `json:"new_hash"`
+}
+
+// journalMu serializes journal writes, which may occur concurrently from multiple sync workers.
+var journalMu sync.Mutex
+
+// appendJournalEntry appends entry as a single line of JSON to the journal file at path,
+// creating the file if it does not already exist. Since the file is appended to in place,
+// it is copied (rather than hard-linked) into data directory generations.
+func appendJournalEntry(path string, entry journalEntry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	journalMu.Lock()
+	defer journalMu.Unlock()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// scanJournal decodes each line of the journal read from r, passing each entry to fn in order.
+// Reading stops at the first error returned by fn.
+func scanJournal(r io.Reader, fn func(journalEntry) error) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("failed to decode journal entry on line %d: %w", line, err)
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
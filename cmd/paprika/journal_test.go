@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TylerHendrickson/paprika"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readJournalEntries(t *testing.T, path string) []journalEntry {
+	t.Helper()
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var entries []journalEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry journalEntry
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &entry))
+		entries = append(entries, entry)
+	}
+	require.NoError(t, scanner.Err())
+	return entries
+}
+
+func TestAppendJournalEntryConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), filenameJournal)
+
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Go(func() {
+			assert.NoError(t, appendJournalEntry(path, journalEntry{Action: "create", UID: "abc", NewHash: "h"}))
+		})
+	}
+	wg.Wait()
+
+	assert.Len(t, readJournalEntries(t, path), 50)
+}
+
+func TestUpsertRecipeJournal(t *testing.T) {
+	tempDir := t.TempDir()
+	cli := &CLI{DataDir: tempDir}
+	uid := "jrnl1"
+
+	hash := "h1"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"result":{"uid":"jrnl1","hash":"` + hash + `"}}`))
+	}))
+	defer server.Close()
+
+	client := newMockClient(t, server)
+	cmd := SyncCMD{Journal: true}
+	before := time.Now()
+
+	saved, err := cmd.UpsertRecipe(context.Background(), cli, client, paprika.RecipeItem{UID: uid, Hash: "h1"}, newTestLogger())
+	require.NoError(t, err)
+	require.True(t, saved)
+
+	// Unchanged recipe is not journaled
+	saved, err = cmd.UpsertRecipe(context.Background(), cli, client, paprika.RecipeItem{UID: uid, Hash: "h1"}, newTestLogger())
+	require.NoError(t, err)
+	require.False(t, saved)
+
+	hash = "h2"
+	saved, err = cmd.UpsertRecipe(context.Background(), cli, client, paprika.RecipeItem{UID: uid, Hash: "h2"}, newTestLogger())
+	require.NoError(t, err)
+	require.True(t, saved)
+
+	entries := readJournalEntries(t, pathToJournalFile(tempDir))
+	require.Len(t, entries, 2)
+	for _, e := range entries {
+		assert.False(t, e.Timestamp.Before(before.Truncate(time.Second)))
+	}
+	assert.Equal(t, journalEntry{Timestamp: entries[0].Timestamp, Action: "create", UID: uid, NewHash: "h1"}, entries[0])
+	assert.Equal(t, journalEntry{Timestamp: entries[1].Timestamp, Action: "update", UID: uid, OldHash: "h1", NewHash: "h2"}, entries[1])
+}
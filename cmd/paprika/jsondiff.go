@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+)
+
+// changedJSONFields compares two JSON objects and returns the sorted names of top-level fields
+// that were added, removed, or whose values differ. Values are compared after removing insignificant whitespace.
+func changedJSONFields(a, b []byte) ([]string, error) {
+	var objA, objB map[string]json.RawMessage
+	if err := json.Unmarshal(a, &objA); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &objB); err != nil {
+		return nil, err
+	}
+
+	var changed []string
+	for name, valA := range objA {
+		valB, ok := objB[name]
+		if !ok {
+			changed = append(changed, name)
+			continue
+		}
+		equal, err := jsonValuesEqual(valA, valB)
+		if err != nil {
+			return nil, err
+		}
+		if !equal {
+			changed = append(changed, name)
+		}
+	}
+	for name := range objB {
+		if _, ok := objA[name]; !ok {
+			changed = append(changed, name)
+		}
+	}
+	slices.Sort(changed)
+	return changed, nil
+}
+
+// jsonValuesEqual reports whether two JSON values are identical, ignoring insignificant whitespace.
+func jsonValuesEqual(a, b json.RawMessage) (bool, error) {
+	var compactA, compactB bytes.Buffer
+	if err := json.Compact(&compactA, a); err != nil {
+		return false, err
+	}
+	if err := json.Compact(&compactB, b); err != nil {
+		return false, err
+	}
+	return bytes.Equal(compactA.Bytes(), compactB.Bytes()), nil
+}
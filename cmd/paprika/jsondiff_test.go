@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangedJSONFields(t *testing.T) {
+	changed, err := changedJSONFields(
+		[]byte(`{"uid":"a","name":"Soup","ingredients":"1 egg","notes":"old","tags":[1, 2]}`),
+		[]byte(`{"uid":"a","name":"Soup","ingredients":"2 eggs","rating":5,"tags":[1,2]}`),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ingredients", "notes", "rating"}, changed)
+
+	changed, err = changedJSONFields([]byte(`{"a":1}`), []byte(` { "a" : 1 } `))
+	require.NoError(t, err)
+	assert.Empty(t, changed)
+
+	_, err = changedJSONFields([]byte(`[]`), []byte(`{}`))
+	require.Error(t, err)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/rs/zerolog"
+)
+
+// Log key styles supported by the --log-key-style flag.
+const (
+	logKeyStyleDash  = "dash"
+	logKeyStyleSnake = "snake"
+)
+
+// logKeyWriter rewrites the top-level keys of each JSON log event written by zerolog before passing it to the
+// underlying writer. Keys of fields that zerolog itself adds (e.g. the timestamp, level, and message) are unchanged.
+type logKeyWriter struct {
+	out    io.Writer
+	style  string
+	prefix string
+}
+
+// newLogKeyWriter returns a writer that applies the given key style and prefix to events written to out,
+// or out itself if no key changes are configured.
+func newLogKeyWriter(out io.Writer, style, prefix string) io.Writer {
+	if (style == "" || style == logKeyStyleDash) && prefix == "" {
+		return out
+	}
+	return &logKeyWriter{out: out, style: style, prefix: prefix}
+}
+
+// key returns the rewritten form of a custom field key.
+func (w *logKeyWriter) key(k string) string {
+	if w.style == logKeyStyleSnake {
+		k = strings.ReplaceAll(k, "-", "_")
+	}
+	return w.prefix + k
+}
+
+// Write rewrites the keys of the JSON event p. Events that cannot be parsed are written unchanged.
+func (w *logKeyWriter) Write(p []byte) (int, error) {
+	rewritten, err := w.rewrite(p)
+	if err != nil {
+		return w.out.Write(p)
+	}
+	if _, err := w.out.Write(rewritten); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (w *logKeyWriter) rewrite(p []byte) ([]byte, error) {
+	reserved := []string{
+		zerolog.TimestampFieldName,
+		zerolog.LevelFieldName,
+		zerolog.MessageFieldName,
+		zerolog.ErrorFieldName,
+		zerolog.CallerFieldName,
+	}
+	dec := json.NewDecoder(bytes.NewReader(p))
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+	b.Grow(len(p) + 64)
+	b.WriteByte('{')
+	for i := 0; dec.More(); i++ {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, _ := tok.(string)
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return nil, err
+		}
+		if !slices.Contains(reserved, key) {
+			key = w.key(key)
+		}
+		encodedKey, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.Write(encodedKey)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	b.WriteString("}\n")
+	return b.Bytes(), nil
+}
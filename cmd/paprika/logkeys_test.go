@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogKeyWriter(t *testing.T) {
+	for _, tt := range []struct {
+		name, style, prefix string
+		want                string
+	}{
+		{"dash", logKeyStyleDash, "", `{"level":"info","recipe-uid":"abc","error":"boom","message":"hi"}` + "\n"},
+		{"snake", logKeyStyleSnake, "", `{"level":"info","recipe_uid":"abc","error":"boom","message":"hi"}` + "\n"},
+		{"prefixedSnake", logKeyStyleSnake, "paprika_", `{"level":"info","paprika_recipe_uid":"abc","error":"boom","message":"hi"}` + "\n"},
+		{"prefixedDash", logKeyStyleDash, "paprika.", `{"level":"info","paprika.recipe-uid":"abc","error":"boom","message":"hi"}` + "\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf safeBuffer
+			log := zerolog.New(newLogKeyWriter(&buf, tt.style, tt.prefix))
+			log.Info().Str("recipe-uid", "abc").Err(errors.New("boom")).Msg("hi")
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+
+	t.Run("nestedValuesUnchanged", func(t *testing.T) {
+		var buf safeBuffer
+		log := zerolog.New(newLogKeyWriter(&buf, logKeyStyleSnake, ""))
+		log.Info().Interface("job-info", map[string]int{"job-id": 1}).Send()
+		assert.Equal(t, `{"level":"info","job_info":{"job-id":1}}`+"\n", buf.String())
+	})
+
+	t.Run("unparseablePassedThrough", func(t *testing.T) {
+		var buf safeBuffer
+		w := newLogKeyWriter(&buf, logKeyStyleSnake, "")
+		n, err := w.Write([]byte("not json\n"))
+		require.NoError(t, err)
+		assert.Equal(t, 9, n)
+		assert.Equal(t, "not json\n", buf.String())
+	})
+}
+
+func TestNewLoggerKeyStyle(t *testing.T) {
+	restoreZerologFieldNamesCleanup(t)
+	stderr, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	require.NoError(t, err)
+	defer stderr.Close()
+
+	cli := validTestCLI()
+	cli.stderr = stderr
+	cli.LoggingOpts.Level = zerolog.InfoLevel
+	cli.LoggingOpts.Format.JSON = true
+	cli.LoggingOpts.KeyStyle = logKeyStyleSnake
+	cli.LoggingOpts.KeyPrefix = "paprika_"
+	log := cli.newLogger()
+	log.Info().Str("recipe-uid", "abc").Msg("saved recipe file")
+
+	data, err := os.ReadFile(stderr.Name())
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"paprika_recipe_uid":"abc"`)
+	assert.Contains(t, string(data), `"message":"saved recipe file"`)
+	assert.NotContains(t, string(data), "recipe-uid")
+}
@@ -5,8 +5,11 @@ import (
 	"errors"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/TylerHendrickson/paprika"
 	"github.com/alecthomas/kong"
 	"github.com/rs/zerolog"
 )
@@ -27,28 +30,18 @@ func Main(ctx context.Context, stdout, stderr *os.File, args []string, exit func
 	var cli CLI
 	cli.stdout = stdout
 	cli.stderr = stderr
-	kctx := Parse(
-		&cli, args,
+	options := []kong.Option{
 		kong.Description("Unofficial command-line utility for the Paprika recipe manager 🌶️"),
 		kong.ShortUsageOnError(),
 		kong.BindTo(ctx, (*context.Context)(nil)),
-		kong.Vars{
-			"version":                   versionStringShort(),
-			"defaultLogLevelName":       zerolog.WarnLevel.String(),
-			"logTimestampDefaultName":   "RFC3339",
-			"logTimestampDefaultLayout": time.RFC3339,
-			"logLevelEnum": enumTag(
-				zerolog.TraceLevel,
-				zerolog.DebugLevel,
-				zerolog.InfoLevel,
-				zerolog.WarnLevel,
-				zerolog.ErrorLevel,
-				zerolog.FatalLevel,
-				zerolog.PanicLevel,
-			),
-		},
+		kongVars(),
 		kong.Exit(exit),
-	)
+	}
+	// Environment variables are resolved while parsing, so --no-env must be detected beforehand.
+	if noEnvRequested(args) {
+		options = append(options, ignoreEnvars())
+	}
+	kctx := Parse(&cli, args, options...)
 
 	if err := kctx.Run(); err != nil {
 		var re reportedErr
@@ -59,6 +52,74 @@ func Main(ctx context.Context, stdout, stderr *os.File, args []string, exit func
 	}
 }
 
+// kongVars returns the variables interpolated into CLI struct tags.
+func kongVars() kong.Vars {
+	return kong.Vars{
+		"version":                   versionStringShort(),
+		"defaultLogLevelName":       zerolog.WarnLevel.String(),
+		"logTimestampDefaultName":   "RFC3339",
+		"logTimestampDefaultLayout": time.RFC3339,
+		"errorBodyLimit":            strconv.Itoa(paprika.DefaultErrorBodyLimit),
+		"userAgent":                 defaultUserAgent(),
+		"generationsDir":            dirnameGenerations,
+		"recipesIndexFile":          filenameRecipesIndex,
+		"categoriesIndexFile":       filenameCategoriesIndex,
+		"journalFile":               filenameJournal,
+		"recipeVersionsDir":         dirnameRecipeVersions,
+		"recipeCategoriesFile":      filenameRecipeCategories,
+		"presetHelp":                presetHelp(),
+		"syncDataTypes":             strings.Join([]string{syncDataRecipes, syncDataCategories}, ","),
+		"logLevelEnum": enumTag(
+			zerolog.TraceLevel,
+			zerolog.DebugLevel,
+			zerolog.InfoLevel,
+			zerolog.WarnLevel,
+			zerolog.ErrorLevel,
+			zerolog.FatalLevel,
+			zerolog.PanicLevel,
+		),
+	}
+}
+
+// noEnvRequested reports whether args enable the --no-env flag.
+func noEnvRequested(args []string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if arg == "--no-env" {
+			return true
+		}
+		if value, ok := strings.CutPrefix(arg, "--no-env="); ok {
+			enabled, err := strconv.ParseBool(value)
+			return err == nil && enabled
+		}
+	}
+	return false
+}
+
+// ignoreEnvars returns a kong option that removes the environment variables of every flag and positional argument,
+// so that they are not resolved when parsing.
+func ignoreEnvars() kong.Option {
+	return kong.PostBuild(func(k *kong.Kong) error {
+		var visit func(node *kong.Node)
+		visit = func(node *kong.Node) {
+			for _, flag := range node.Flags {
+				flag.Envs = nil
+				flag.Value.Tag.Envs = nil
+			}
+			for _, arg := range node.Positional {
+				arg.Tag.Envs = nil
+			}
+			for _, child := range node.Children {
+				visit(child)
+			}
+		}
+		visit(k.Model.Node)
+		return nil
+	})
+}
+
 // Parse mirrors kong.Parse(), but parses osArgs instead of os.Args[1:]
 func Parse(cli any, osArgs []string, options ...kong.Option) *kong.Context {
 	parser, err := kong.New(cli, options...)
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/TylerHendrickson/paprika"
+)
+
+const (
+	// paprikaRecipeEntryExt is the filename extension of each recipe entry in a .paprikarecipes archive.
+	paprikaRecipeEntryExt = ".paprikarecipe"
+	// filenameHashLength is the number of hexadecimal digits of the hash suffix of truncated filenames.
+	filenameHashLength = 8
+	// minExportNameLength is the minimum supported maximum length of exported filenames,
+	// which leaves room for some of the name in addition to the hash suffix of truncated filenames.
+	minExportNameLength = 16
+)
+
+// writePaprikaRecipesArchive writes recipes to w in the .paprikarecipes format understood by the Paprika app:
+// a zip archive containing one gzip-compressed recipe JSON entry per recipe.
+// Entries are named after recipes, limited to maxNameLength bytes (see sanitizeFilename).
+func writePaprikaRecipesArchive(w io.Writer, recipes []paprika.Recipe, maxNameLength int) error {
+	zw := zip.NewWriter(w)
+	names := make(map[string]struct{}, len(recipes))
+	for _, r := range recipes {
+		name := uniqueEntryName(sanitizeFilename(r.Name, r.UID, maxNameLength), names) + paprikaRecipeEntryExt
+		entry, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		if err != nil {
+			return err
+		}
+		gz := gzip.NewWriter(entry)
+		if err := json.NewEncoder(gz).Encode(r); err != nil {
+			return fmt.Errorf("encode recipe %q: %w", r.UID, err)
+		}
+		if err := gz.Close(); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// readPaprikaRecipesArchive reads all recipes from the .paprikarecipes archive at path.
+// Archive entries that are not recipes are ignored.
+func readPaprikaRecipesArchive(path string) ([]paprika.Recipe, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var recipes []paprika.Recipe
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || !strings.EqualFold(filepath.Ext(f.Name), paprikaRecipeEntryExt) {
+			continue
+		}
+		r, err := readPaprikaRecipeEntry(f)
+		if err != nil {
+			return nil, fmt.Errorf("read archive entry %q: %w", f.Name, err)
+		}
+		recipes = append(recipes, r)
+	}
+	return recipes, nil
+}
+
+// readPaprikaRecipeEntry decodes a single gzip-compressed recipe JSON entry of a .paprikarecipes archive.
+func readPaprikaRecipeEntry(f *zip.File) (paprika.Recipe, error) {
+	var r paprika.Recipe
+	rc, err := f.Open()
+	if err != nil {
+		return r, err
+	}
+	defer rc.Close()
+	gz, err := gzip.NewReader(rc)
+	if err != nil {
+		return r, err
+	}
+	defer gz.Close()
+	return r, json.NewDecoder(gz).Decode(&r)
+}
+
+// sanitizeFilename returns name with characters that are unsafe in filenames on common platforms replaced.
+// If nothing usable remains, fallback is used instead. If maxLength is positive and the result is longer than
+// maxLength bytes, it is truncated on a character boundary and suffixed with a hash of the untruncated name,
+// so that long names sharing a prefix remain distinct, to be at most maxLength bytes.
+// maxLength should be at least minExportNameLength.
+func sanitizeFilename(name, fallback string, maxLength int) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, name)
+	name = strings.Trim(name, " .")
+	if name == "" {
+		name = fallback
+	}
+	if maxLength <= 0 || len(name) <= maxLength {
+		return name
+	}
+	sum := sha256.Sum256([]byte(name))
+	suffix := "-" + hex.EncodeToString(sum[:])[:filenameHashLength]
+	end := 0
+	for i, r := range name {
+		if i+utf8.RuneLen(r) > maxLength-len(suffix) {
+			break
+		}
+		end = i + utf8.RuneLen(r)
+	}
+	return strings.TrimRight(name[:end], " .") + suffix
+}
+
+// uniqueEntryName returns name, or name with a numeric suffix if it is already present in seen,
+// and records the returned name in seen. Comparison is case-insensitive.
+func uniqueEntryName(name string, seen map[string]struct{}) string {
+	candidate := name
+	for i := 2; ; i++ {
+		key := strings.ToLower(candidate)
+		if _, exists := seen[key]; !exists {
+			seen[key] = struct{}{}
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s (%d)", name, i)
+	}
+}
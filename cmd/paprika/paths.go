@@ -1,14 +1,45 @@
 package main
 
-import "path/filepath"
+import (
+	"cmp"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
 
 const (
 	filenameRecipeJSON         string = "recipe.json"
 	filenameRecipeDeleteMarker string = ".delete-marker"
+	filenameRecipeCategories   string = "categories.json"
 	filenameRecipesIndex       string = "recipes-index.json"
 	filenameCategoriesIndex    string = "categories-index.json"
+	filenameJournal            string = "journal.ndjson"
+	filenameSyncState          string = "sync-state.json"
+	filenameLastPrune          string = ".last-prune"
+	dirnameRecipeVersions      string = "versions"
+	dirnameGenerations         string = ".generations"
 )
 
+// minUIDLength is the minimum length of a recipe UID, as required by the sharded recipe directory layout.
+const minUIDLength = 3
+
+// safeUIDPattern matches recipe UIDs that are safe for use as file path components.
+var safeUIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// validateUID checks that uid can be used to construct recipe data paths.
+// When strict is set, uid must also consist solely of alphanumeric characters, hyphens, and underscores,
+// which guarantees that paths constructed from it remain within the data directory.
+func validateUID(uid string, strict bool) error {
+	if len(uid) < minUIDLength {
+		return fmt.Errorf("recipe UID %q is shorter than %d characters", uid, minUIDLength)
+	}
+	if strict && !safeUIDPattern.MatchString(uid) {
+		return fmt.Errorf("recipe UID %q contains characters that are not allowed in file paths", uid)
+	}
+	return nil
+}
+
 func pathToRecipeDir(basePath, uid string) string {
 	return filepath.Join(pathToRecipesDir(basePath), uid[:2], uid[:3], uid)
 }
@@ -17,10 +48,63 @@ func pathToRecipeJSONFile(basePath, uid string) string {
 	return filepath.Join(pathToRecipeDir(basePath, uid), filenameRecipeJSON)
 }
 
+// pathToRecipeHashFile returns the path of the content-addressed recipe file (see --content-addressed)
+// for the version of the recipe identified by uid with the given hash.
+func pathToRecipeHashFile(basePath, uid, hash string) string {
+	return filepath.Join(pathToRecipeDir(basePath, uid), hash+".json")
+}
+
+// isRecipeFileName reports whether name is the name of a recipe file within a recipe directory,
+// either recipe.json or a content-addressed recipe file. Files named by a hash are only recognized as
+// content-addressed recipe files if contentAddressed is set (see --content-addressed), or if the hash is indexedHash
+// (the hash of the recipe in the recipes index, if known), so that other JSON files are not mistaken for recipes.
+func isRecipeFileName(name string, contentAddressed bool, indexedHash string) bool {
+	if name == filenameRecipeJSON {
+		return true
+	}
+	hash, ok := strings.CutSuffix(name, ".json")
+	if !ok || name == filenameRecipeCategories || validateRecipeHash(hash) != nil {
+		return false
+	}
+	return contentAddressed || (indexedHash != "" && hash == indexedHash)
+}
+
+// validateRecipeHash checks that hash can be used to construct content-addressed recipe file paths.
+func validateRecipeHash(hash string) error {
+	if !safeUIDPattern.MatchString(hash) {
+		return fmt.Errorf("recipe hash %q contains characters that are not allowed in file paths", hash)
+	}
+	return nil
+}
+
 func pathToRecipeDeleteMarkerFile(basePath, uid string) string {
 	return filepath.Join(pathToRecipeDir(basePath, uid), filenameRecipeDeleteMarker)
 }
 
+func pathToRecipeCategoriesFile(basePath, uid string) string {
+	return filepath.Join(pathToRecipeDir(basePath, uid), filenameRecipeCategories)
+}
+
+func pathToRecipeVersionsDir(basePath, uid string) string {
+	return filepath.Join(pathToRecipeDir(basePath, uid), dirnameRecipeVersions)
+}
+
+func pathToRecipeVersionFile(basePath, uid, hash string) string {
+	return filepath.Join(pathToRecipeVersionsDir(basePath, uid), hash+".json")
+}
+
+// isRecipeVersionsDir reports whether dir (a directory under recipesDataRoot) is the directory of prior versions
+// of a recipe (see pathToRecipeVersionsDir), whose files are part of the recipe rather than recipes themselves.
+func isRecipeVersionsDir(recipesDataRoot, dir string) bool {
+	rel, err := filepath.Rel(recipesDataRoot, dir)
+	if err != nil {
+		return false
+	}
+	// i.e. <uid[:2]>/<uid[:3]>/<uid>/versions
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	return len(parts) == 4 && parts[3] == dirnameRecipeVersions
+}
+
 func pathToRecipesDir(basePath string) string {
 	return filepath.Join(basePath, "recipes")
 }
@@ -32,3 +116,45 @@ func pathToRecipesIndexFile(basePath string) string {
 func pathToCategoriesIndexFile(basePath string) string {
 	return filepath.Join(basePath, filenameCategoriesIndex)
 }
+
+func pathToGenerationsDir(basePath string) string {
+	return filepath.Join(basePath, dirnameGenerations)
+}
+
+func pathToJournalFile(basePath string) string {
+	return filepath.Join(basePath, filenameJournal)
+}
+
+func pathToSyncStateFile(basePath string) string {
+	return filepath.Join(basePath, filenameSyncState)
+}
+
+func pathToLastPruneFile(basePath string) string {
+	return filepath.Join(basePath, filenameLastPrune)
+}
+
+// recipesIndexFile returns the path of the recipes index file, according to the CLI configuration state.
+func (cli *CLI) recipesIndexFile() string {
+	return cli.recipesIndexFileIn(cli.DataDir)
+}
+
+// recipesIndexFileIn returns the path of the recipes index file within the data directory dataDir,
+// according to the CLI configuration state.
+func (cli *CLI) recipesIndexFileIn(dataDir string) string {
+	return filepath.Join(dataDir, cmp.Or(cli.RecipesIndexName, filenameRecipesIndex))
+}
+
+// recipeFile returns the path of the file storing the version of the recipe identified by uid with the given hash
+// within the data directory dataDir, according to the CLI configuration state. Content-addressed recipe files
+// (see --content-addressed) are only used when hash is known.
+func (cli *CLI) recipeFile(dataDir, uid, hash string) string {
+	if cli.ContentAddressed && hash != "" {
+		return pathToRecipeHashFile(dataDir, uid, hash)
+	}
+	return pathToRecipeJSONFile(dataDir, uid)
+}
+
+// categoriesIndexFile returns the path of the categories index file, according to the CLI configuration state.
+func (cli *CLI) categoriesIndexFile() string {
+	return filepath.Join(cli.DataDir, cmp.Or(cli.CategoriesIndexName, filenameCategoriesIndex))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/alecthomas/kong"
+)
+
+// Names of the presets supported by the --preset flag.
+const (
+	presetConservative = "conservative"
+	presetBalanced     = "balanced"
+	presetAggressive   = "aggressive"
+)
+
+// preset is a named set of defaults for download and request throttling settings.
+type preset struct {
+	DownloadConcurrency NumWorkers    // --download-concurrency
+	RecipeMaxAttempts   uint          // --recipe-max-attempts
+	RateLimit           float64       // --rate-limit
+	RequestDelay        time.Duration // --request-delay
+}
+
+// presets are the supported presets, by name:
+//   - conservative: 2 download workers, 3 attempts per recipe, at most 2 requests per second,
+//     and a 250ms delay before each request, for minimal load on the Paprika API.
+//   - balanced: 10 download workers, 2 attempts per recipe, at most 10 requests per second, and no delay.
+//   - aggressive: 32 download workers, 1 attempt per recipe, and no rate limit or delay,
+//     for the fastest syncs of large libraries.
+var presets = map[string]preset{
+	presetConservative: {DownloadConcurrency: 2, RecipeMaxAttempts: 3, RateLimit: 2, RequestDelay: 250 * time.Millisecond},
+	presetBalanced:     {DownloadConcurrency: 10, RecipeMaxAttempts: 2, RateLimit: 10},
+	presetAggressive:   {DownloadConcurrency: 32, RecipeMaxAttempts: 1},
+}
+
+// presetNames returns the names of the supported presets, from most to least conservative.
+func presetNames() []string {
+	return []string{presetConservative, presetBalanced, presetAggressive}
+}
+
+// presetHelp describes the settings of each preset, for the --preset flag's help text.
+func presetHelp() string {
+	var parts []string
+	for _, name := range presetNames() {
+		p := presets[name]
+		limit := fmt.Sprintf("%g requests per second", p.RateLimit)
+		if p.RateLimit == 0 {
+			limit = "no rate limit"
+		}
+		delay := fmt.Sprintf("%s request delay", p.RequestDelay)
+		if p.RequestDelay == 0 {
+			delay = "no request delay"
+		}
+		parts = append(parts, fmt.Sprintf("%s = %d workers, %d attempts per recipe, %s, %s",
+			name, p.DownloadConcurrency, p.RecipeMaxAttempts, limit, delay))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// applyPreset applies the settings of the configured preset (if any) to the CLI and the sync command,
+// except for settings whose flags were explicitly given on the command line or by environment variables.
+func (cli *CLI) applyPreset(kctx *kong.Context) {
+	if cli.Preset == "" {
+		return
+	}
+	p := presets[cli.Preset]
+
+	// Flags are identified by the address of the field that they set, since flag names
+	// may be shared by multiple commands.
+	explicit := make(map[any]bool)
+	for _, trace := range kctx.Path {
+		if trace.Flag != nil {
+			explicit[trace.Flag.Target.Addr().Interface()] = true
+		}
+	}
+	// Environment variables are checked for the flags of every command (not only the selected command),
+	// so that the configuration printed by the config command reflects them.
+	var visit func(node *kong.Node)
+	visit = func(node *kong.Node) {
+		for _, flag := range node.Flags {
+			for _, env := range flag.Envs {
+				if _, ok := os.LookupEnv(env); ok {
+					explicit[flag.Target.Addr().Interface()] = true
+				}
+			}
+		}
+		for _, child := range node.Children {
+			visit(child)
+		}
+	}
+	visit(kctx.Model.Node)
+
+	if !explicit[&cli.Sync.DownloadConcurrency] {
+		cli.Sync.DownloadConcurrency = p.DownloadConcurrency
+	}
+	if !explicit[&cli.Sync.RecipeMaxAttempts] {
+		cli.Sync.RecipeMaxAttempts = p.RecipeMaxAttempts
+	}
+	if !explicit[&cli.RateLimit] {
+		cli.RateLimit = p.RateLimit
+	}
+	if !explicit[&cli.RequestDelay] {
+		cli.RequestDelay = p.RequestDelay
+	}
+}
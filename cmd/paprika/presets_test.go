@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPresets(t *testing.T) {
+	type settings struct {
+		RateLimit    float64
+		RequestDelay time.Duration
+		Sync         struct {
+			DownloadConcurrency int
+			RecipeMaxAttempts   uint
+			MaxPurgePercent     uint
+		}
+		Purge struct {
+			MaxPurgePercent uint
+		}
+	}
+	resolve := func(t *testing.T, args ...string) settings {
+		t.Helper()
+		restoreZerologFieldNamesCleanup(t)
+		stdout, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+		require.NoError(t, err)
+		defer stdout.Close()
+		devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		require.NoError(t, err)
+		defer devNull.Close()
+
+		exitCode := -1
+		Main(context.Background(), stdout, devNull, append([]string{"--data-dir", t.TempDir()}, args...),
+			func(code int) { exitCode = code })
+		require.Equal(t, -1, exitCode, "should not exit with error")
+		data, err := os.ReadFile(stdout.Name())
+		require.NoError(t, err)
+		var s settings
+		require.NoError(t, json.Unmarshal(data, &s))
+		return s
+	}
+
+	t.Run("noPreset", func(t *testing.T) {
+		s := resolve(t, "config")
+		assert.Equal(t, 10, s.Sync.DownloadConcurrency)
+		assert.EqualValues(t, 1, s.Sync.RecipeMaxAttempts)
+		assert.Zero(t, s.RateLimit)
+		assert.Zero(t, s.RequestDelay)
+		assert.EqualValues(t, 50, s.Sync.MaxPurgePercent)
+	})
+
+	for _, name := range presetNames() {
+		t.Run(name, func(t *testing.T) {
+			p := presets[name]
+			s := resolve(t, "--preset", name, "config")
+			assert.EqualValues(t, p.DownloadConcurrency, s.Sync.DownloadConcurrency)
+			assert.Equal(t, p.RecipeMaxAttempts, s.Sync.RecipeMaxAttempts)
+			assert.Equal(t, p.RateLimit, s.RateLimit)
+			assert.Equal(t, p.RequestDelay, s.RequestDelay)
+			// Presets never relax the purge safety limit
+			assert.EqualValues(t, 50, s.Sync.MaxPurgePercent)
+			assert.EqualValues(t, 50, s.Purge.MaxPurgePercent)
+		})
+	}
+
+	t.Run("fromEnvironment", func(t *testing.T) {
+		t.Setenv("PAPRIKA_PRESET", presetConservative)
+		s := resolve(t, "config")
+		assert.Equal(t, 2, s.Sync.DownloadConcurrency)
+	})
+
+	t.Run("explicitFlagsOverride", func(t *testing.T) {
+		restoreZerologFieldNamesCleanup(t)
+		var cli CLI
+		parser, err := kong.New(&cli, kongVars(), kong.BindTo(context.Background(), (*context.Context)(nil)))
+		require.NoError(t, err)
+		_, err = parser.Parse([]string{"--data-dir", t.TempDir(), "--preset", presetConservative, "--rate-limit", "0",
+			"sync", "--download-concurrency", "5"})
+		require.NoError(t, err)
+		assert.EqualValues(t, 5, cli.Sync.DownloadConcurrency)
+		assert.Zero(t, cli.RateLimit)
+		assert.EqualValues(t, 3, cli.Sync.RecipeMaxAttempts, "settings without explicit flags use the preset")
+		assert.Equal(t, 250*time.Millisecond, cli.RequestDelay, "settings without explicit flags use the preset")
+	})
+
+	t.Run("explicitEnvironmentOverrides", func(t *testing.T) {
+		t.Setenv("PAPRIKA_SYNC_WORKERS", "5")
+		t.Setenv("PAPRIKA_REQUEST_DELAY", "1s")
+		s := resolve(t, "--preset", presetConservative, "config")
+		assert.Equal(t, 5, s.Sync.DownloadConcurrency)
+		assert.EqualValues(t, 3, s.Sync.RecipeMaxAttempts)
+		assert.EqualValues(t, 2, s.RateLimit)
+		assert.Equal(t, time.Second, s.RequestDelay)
+	})
+}
+
+func TestCLIValidatePreset(t *testing.T) {
+	cli := validTestCLI()
+	cli.Preset = presetBalanced
+	require.NoError(t, cli.Validate())
+	cli.Preset = "reckless"
+	require.EqualError(t, cli.Validate(), "--preset must be one of conservative, balanced, aggressive")
+}
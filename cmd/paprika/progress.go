@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// progressReportEvery is the number of completed recipe items between progress reports.
+	progressReportEvery = 100
+	// progressReportInterval is the maximum time between progress reports while recipe items are completing.
+	progressReportInterval = 10 * time.Second
+	// progressWindowSize is the number of recent per-item durations used to estimate time remaining.
+	progressWindowSize = 50
+)
+
+// progressReport summarizes sync progress at a point in time.
+type progressReport struct {
+	Completed, Total int
+	// Remaining is the estimated time until all items are completed.
+	Remaining time.Duration
+}
+
+// progressEstimator estimates the time remaining to complete a known number of items processed concurrently,
+// using a rolling average of recent per-item durations. It is safe for concurrent use.
+type progressEstimator struct {
+	concurrency int
+
+	mu         sync.Mutex
+	total      int
+	completed  int
+	window     []time.Duration
+	next       int
+	lastReport time.Time
+}
+
+// newProgressEstimator returns a progressEstimator for items processed by the given number of concurrent workers,
+// averaging over at most windowSize recent durations.
+func newProgressEstimator(concurrency, windowSize int, start time.Time) *progressEstimator {
+	return &progressEstimator{
+		concurrency: max(concurrency, 1),
+		window:      make([]time.Duration, 0, max(windowSize, 1)),
+		lastReport:  start,
+	}
+}
+
+// setTotal sets the total number of items to be completed.
+func (p *progressEstimator) setTotal(total int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.total = total
+}
+
+// observe records that an item was completed in d, and reports whether progress should be reported as of now,
+// which is the case after every progressReportEvery completed items or once progressReportInterval has elapsed
+// since the previous report.
+func (p *progressEstimator) observe(d time.Duration, now time.Time) (progressReport, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.completed++
+	if len(p.window) < cap(p.window) {
+		p.window = append(p.window, d)
+	} else {
+		p.window[p.next] = d
+		p.next = (p.next + 1) % len(p.window)
+	}
+
+	if p.completed%progressReportEvery != 0 && now.Sub(p.lastReport) < progressReportInterval {
+		return progressReport{}, false
+	}
+	p.lastReport = now
+	return p.reportLocked(), true
+}
+
+// report returns the current progress.
+func (p *progressEstimator) report() progressReport {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.reportLocked()
+}
+
+func (p *progressEstimator) reportLocked() progressReport {
+	r := progressReport{Completed: p.completed, Total: p.total}
+	remainingItems := p.total - p.completed
+	if remainingItems <= 0 || len(p.window) == 0 {
+		return r
+	}
+	var sum time.Duration
+	for _, d := range p.window {
+		sum += d
+	}
+	avg := sum / time.Duration(len(p.window))
+	r.Remaining = avg * time.Duration(remainingItems) / time.Duration(p.concurrency)
+	return r
+}
+
+// errSyncStalled is the cause of the cancellation of a sync that stops making progress (see progressWatchdog).
+var errSyncStalled = errors.New("sync stalled")
+
+// progressWatchdog cancels a context with errSyncStalled when no progress is reported for longer than
+// its maximum idle time. A nil *progressWatchdog is valid and never fires.
+type progressWatchdog struct {
+	maxIdle time.Duration
+	ctx     context.Context
+	cancel  context.CancelCauseFunc
+
+	mu           sync.Mutex
+	lastProgress time.Time
+	stopped      chan struct{}
+	done         chan struct{}
+}
+
+// startProgressWatchdog returns a copy of ctx that is canceled with errSyncStalled when no progress
+// is reported to the returned watchdog for longer than maxIdle. The watchdog must be stopped to release its resources.
+func startProgressWatchdog(ctx context.Context, maxIdle time.Duration) (context.Context, *progressWatchdog) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	w := &progressWatchdog{
+		maxIdle:      maxIdle,
+		ctx:          ctx,
+		cancel:       cancel,
+		lastProgress: time.Now(),
+		stopped:      make(chan struct{}),
+		done:         make(chan struct{}),
+	}
+	go w.watch()
+	return ctx, w
+}
+
+// watch cancels the watchdog's context once the maximum idle time elapses without progress,
+// until the watchdog is stopped or its context is otherwise canceled.
+func (w *progressWatchdog) watch() {
+	defer close(w.done)
+	timer := time.NewTimer(w.maxIdle)
+	defer timer.Stop()
+	for {
+		select {
+		case <-w.stopped:
+			return
+		case <-w.ctx.Done():
+			return
+		case <-timer.C:
+			w.mu.Lock()
+			idle := time.Since(w.lastProgress)
+			w.mu.Unlock()
+			if idle >= w.maxIdle {
+				w.cancel(errSyncStalled)
+				return
+			}
+			timer.Reset(w.maxIdle - idle)
+		}
+	}
+}
+
+// progress records that progress was made.
+func (w *progressWatchdog) progress() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.lastProgress = time.Now()
+}
+
+// stop stops the watchdog without canceling its context, and waits for it to exit.
+// It reports whether the watchdog had already canceled its context because progress stalled.
+func (w *progressWatchdog) stop() (stalled bool) {
+	if w == nil {
+		return false
+	}
+	select {
+	case <-w.stopped:
+	default:
+		close(w.stopped)
+	}
+	<-w.done
+	stalled = errors.Is(context.Cause(w.ctx), errSyncStalled)
+	// Release the context's resources; this has no effect if the context was already canceled.
+	w.cancel(context.Canceled)
+	return stalled
+}
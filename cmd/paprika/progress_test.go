@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressEstimator(t *testing.T) {
+	start := time.Now()
+
+	t.Run("estimatesFromRollingAverage", func(t *testing.T) {
+		p := newProgressEstimator(2, 4, start)
+		p.setTotal(20)
+		for range 4 {
+			p.observe(time.Second, start)
+		}
+		// 16 remaining items at 1s each across 2 workers
+		assert.Equal(t, progressReport{Completed: 4, Total: 20, Remaining: 8 * time.Second}, p.report())
+
+		// Older durations fall out of the window
+		for range 4 {
+			p.observe(3*time.Second, start)
+		}
+		assert.Equal(t, progressReport{Completed: 8, Total: 20, Remaining: 18 * time.Second}, p.report())
+	})
+
+	t.Run("noEstimateWhenComplete", func(t *testing.T) {
+		p := newProgressEstimator(1, 4, start)
+		p.setTotal(1)
+		p.observe(time.Second, start)
+		assert.Equal(t, progressReport{Completed: 1, Total: 1}, p.report())
+	})
+
+	t.Run("reportsPeriodically", func(t *testing.T) {
+		p := newProgressEstimator(1, 4, start)
+		p.setTotal(1000)
+		var reports int
+		for i := range progressReportEvery * 2 {
+			if _, ok := p.observe(time.Millisecond, start); ok {
+				reports++
+				assert.Zero(t, (i+1)%progressReportEvery)
+			}
+		}
+		assert.Equal(t, 2, reports)
+
+		r, ok := p.observe(time.Millisecond, start.Add(progressReportInterval))
+		assert.True(t, ok, "should report once the report interval has elapsed")
+		assert.Equal(t, progressReportEvery*2+1, r.Completed)
+	})
+
+	t.Run("concurrentObservations", func(t *testing.T) {
+		p := newProgressEstimator(4, progressWindowSize, start)
+		p.setTotal(400)
+		var wg sync.WaitGroup
+		for range 4 {
+			wg.Go(func() {
+				for range 50 {
+					p.observe(100*time.Millisecond, start)
+				}
+			})
+		}
+		wg.Wait()
+		// 200 remaining items at 100ms each across 4 workers
+		assert.Equal(t, progressReport{Completed: 200, Total: 400, Remaining: 5 * time.Second}, p.report())
+	})
+}
+
+func TestProgressWatchdog(t *testing.T) {
+	t.Run("fires", func(t *testing.T) {
+		ctx, w := startProgressWatchdog(context.Background(), 20*time.Millisecond)
+		select {
+		case <-ctx.Done():
+		case <-time.After(5 * time.Second):
+			t.Fatal("watchdog did not fire")
+		}
+		assert.ErrorIs(t, context.Cause(ctx), errSyncStalled)
+		assert.True(t, w.stop())
+	})
+
+	t.Run("progress", func(t *testing.T) {
+		ctx, w := startProgressWatchdog(context.Background(), 100*time.Millisecond)
+		for range 5 {
+			time.Sleep(40 * time.Millisecond)
+			w.progress()
+		}
+		require.NoError(t, ctx.Err())
+		assert.False(t, w.stop())
+		assert.ErrorIs(t, context.Cause(ctx), context.Canceled, "context should be released when stopped")
+		assert.NotErrorIs(t, context.Cause(ctx), errSyncStalled)
+	})
+
+	t.Run("nil", func(t *testing.T) {
+		var w *progressWatchdog
+		w.progress()
+		assert.False(t, w.stop())
+	})
+}
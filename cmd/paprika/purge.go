@@ -0,0 +1,644 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"fortio.org/duration"
+	"github.com/rs/zerolog"
+)
+
+// PurgeAfter is a time.Duration that represents the grace period for purging unindexed recipe data.
+type PurgeAfter time.Duration
+
+// UnmarshalText parses CLI argument duration input bytes.
+// It supports days (d) and weeks (w) units, in addition to units supported by time.ParseDuration().
+func (d *PurgeAfter) UnmarshalText(b []byte) error {
+	parsed, err := duration.Parse(string(b))
+	if err != nil {
+		return err
+	}
+	if parsed < 0 {
+		return fmt.Errorf("duration cannot be negative")
+	}
+	*d = PurgeAfter(parsed)
+	return nil
+}
+
+func (d *PurgeAfter) String() string {
+	if d == nil {
+		return "<never>"
+	}
+	dur := *d
+	if dur == 0 {
+		return "<immediate>"
+	}
+	return time.Duration(dur).String()
+}
+
+// MarshalText formats the grace period as a duration string that is accepted by UnmarshalText.
+func (d PurgeAfter) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// purgePolicy controls how purgeUnreferencedRecipes handles local data for unindexed recipes.
+type purgePolicy struct {
+	// PurgeAfter is the grace period after which marked recipe data is purged.
+	// Zero or less means unindexed recipe data is purged immediately.
+	PurgeAfter time.Duration
+	// MarkOnly causes deletion markers to be written for unindexed recipes without ever purging recipe data.
+	MarkOnly bool
+	// DryRun causes all actions to be logged without modifying any local data.
+	DryRun bool
+	// AllowEmptyIndex permits purging when the recipes index is empty but local recipe data exists.
+	// Otherwise, an empty index is presumed to be erroneous and no local data is marked or purged.
+	AllowEmptyIndex bool
+	// MaxPurgePercent is the maximum percentage of local recipes that may be purged in a single run.
+	// If more would be purged, nothing is purged unless Force is set. Zero disables the limit.
+	MaxPurgePercent uint
+	// Force permits purging more than MaxPurgePercent of local recipes.
+	Force bool
+	// PruneInterval is the maximum time between prunes of empty directories under the recipes data root
+	// when nothing is purged. Zero or less means empty directories are only pruned after something is purged.
+	PruneInterval time.Duration
+	// ContentAddressed causes any file named by a hash to be recognized as a recipe file (see isRecipeFileName),
+	// rather than only recipe.json and the file named by the indexed hash of the recipe.
+	ContentAddressed bool
+}
+
+// PurgeCMD is the sub-command for purging local data for recipes that no longer exist in Paprika,
+// according to the most recently saved recipes index. It does not make any requests to the Paprika API.
+type PurgeCMD struct {
+	PurgeAfter PurgeAfter `help:"Grace period for retaining local data for a recipe that does not exist present in the recipes index. Set to zero for immediate purge." required:"" env:"PAPRIKA_PURGE_AFTER" placeholder:"DURATION"`
+	DryRun     bool       `help:"Log the actions that would be taken without modifying any local data." env:"PAPRIKA_PURGE_DRY_RUN"`
+
+	AllowEmptyIndexPurge bool `help:"Allow purging when the recipes index is empty but local recipe data exists. By default, an empty index is presumed to be erroneous and nothing is purged." env:"PAPRIKA_PURGE_ALLOW_EMPTY_INDEX_PURGE"`
+	MaxPurgePercent      uint `help:"Maximum percentage of local recipes that may be purged in a single run. If more would be purged, the purge is aborted unless --force-purge is set. Set to zero to disable the limit." default:"50" env:"PAPRIKA_PURGE_MAX_PURGE_PERCENT" placeholder:"PERCENT"`
+	ForcePurge           bool `help:"Purge even if more than --max-purge-percent of local recipes would be purged." env:"PAPRIKA_PURGE_FORCE_PURGE"`
+
+	PruneInterval time.Duration `help:"Prune empty directories under the recipes data root when this much time has passed since they were last pruned, even if nothing was purged. By default, empty directories are only pruned after something is purged." env:"PAPRIKA_PURGE_PRUNE_INTERVAL" placeholder:"DURATION"`
+
+	// Source of timestamps for the purge cutoff and purge markers (defaults to the system clock)
+	clock Clock
+}
+
+func (cmd *PurgeCMD) Validate() error {
+	if cmd.MaxPurgePercent > 100 {
+		return fmt.Errorf("--max-purge-percent must not exceed 100")
+	}
+	return nil
+}
+
+// now returns the current time according to the command's clock.
+func (cmd *PurgeCMD) now() time.Time {
+	if cmd.clock == nil {
+		return realClock{}.Now()
+	}
+	return cmd.clock.Now()
+}
+
+func (cmd *PurgeCMD) Run(ctx context.Context, cli *CLI, log zerolog.Logger) error {
+	policy := purgePolicy{
+		PurgeAfter:       time.Duration(cmd.PurgeAfter),
+		DryRun:           cmd.DryRun,
+		AllowEmptyIndex:  cmd.AllowEmptyIndexPurge,
+		MaxPurgePercent:  cmd.MaxPurgePercent,
+		Force:            cmd.ForcePurge,
+		PruneInterval:    cmd.PruneInterval,
+		ContentAddressed: cli.ContentAddressed,
+	}
+	log = log.With().Bool("dry-run", cmd.DryRun).Logger()
+	log.Debug().Str("grace-period", cmd.PurgeAfter.String()).
+		Msg("purging unindexed recipes according to configured grace period")
+	now := cmd.now()
+	if err := purgeAndPrune(ctx, cli.DataDir, cli.recipesIndexFile(), cli.TempDir, now, policy, log); err != nil {
+		return fmt.Errorf("purge completed with errors")
+	}
+	cli.purgeMirror(ctx, now, policy, log)
+	log.Info().Msg("purge completed successfully")
+	return nil
+}
+
+// purgeAndPrune purges local data for unindexed recipes according to policy
+// and then prunes empty directories under the recipes data root, if any files were removed by the purge
+// or policy.PruneInterval has elapsed since empty directories were last pruned (see shouldPrune).
+// The last prune timestamp file is staged in tempDir if set (see writeFileAtomic).
+// Errors are logged before being returned.
+func purgeAndPrune(ctx context.Context, dataDir, indexPath, tempDir string, now time.Time, policy purgePolicy, log zerolog.Logger) error {
+	removed, err := purgeUnreferencedRecipes(ctx, dataDir, indexPath, now, policy, log)
+	if err != nil {
+		log.Err(err).Msg("error purging unindexed recipes")
+		return err
+	}
+
+	pruneRoot := pathToRecipesDir(dataDir)
+	log = log.With().Str("recipes-data-root", pruneRoot).Logger()
+	if policy.DryRun {
+		log.Debug().Msg("skipping pruning empty directories under recipes data root in dry-run mode")
+		return nil
+	}
+	lastPrunePath := pathToLastPruneFile(dataDir)
+	if !shouldPrune(lastPrunePath, removed, now, policy.PruneInterval, log) {
+		return nil
+	}
+	log.Debug().Int("purged-recipes-count", removed).
+		Msg("pruning empty directories under recipes data root")
+	if err := PruneFilelessSubtrees(ctx, pruneRoot); err != nil {
+		log.Err(err).Msg("error pruning empty directories under recipes data root")
+		return err
+	}
+	if err := writeFileAtomic(lastPrunePath, tempDir, func(f *os.File) error {
+		_, err := f.WriteString(now.UTC().Format(time.RFC3339Nano) + "\n")
+		return err
+	}); err != nil {
+		log.Warn().Err(err).Str("path", lastPrunePath).Msg("failed to save last prune timestamp file")
+	}
+	return nil
+}
+
+// shouldPrune reports whether empty directories under the recipes data root should be pruned,
+// which is the case when removed (the number of recipe directories from which files were purged) is nonzero,
+// or when interval is positive and has elapsed since the time recorded in the last prune timestamp file at path.
+// A missing or unreadable timestamp file is treated as if the interval has elapsed.
+func shouldPrune(path string, removed int, now time.Time, interval time.Duration, log zerolog.Logger) bool {
+	if removed > 0 {
+		return true
+	}
+	if interval <= 0 {
+		log.Debug().Msg("skipping pruning empty directories under recipes data root because nothing was purged")
+		return false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			log.Warn().Err(err).Str("path", path).Msg("failed to read last prune timestamp file")
+		}
+		return true
+	}
+	lastPrune, err := time.Parse(time.RFC3339Nano, string(bytes.TrimSpace(data)))
+	if err != nil {
+		log.Warn().Err(err).Str("path", path).Msg("failed to parse last prune timestamp file")
+		return true
+	}
+	if due := lastPrune.Add(interval); now.Before(due) {
+		log.Debug().Time("last-prune", lastPrune).
+			Time("next-prune-due", due).
+			Msg("skipping pruning empty directories under recipes data root because nothing was purged and the prune interval has not elapsed")
+		return false
+	}
+	return true
+}
+
+// purgeMirror purges local data for unindexed recipes from the mirror directory (see --mirror-dir), if any,
+// according to policy and the recipes index of the data directory. Errors are logged as warnings,
+// since the mirror is secondary to the data directory.
+func (cli *CLI) purgeMirror(ctx context.Context, now time.Time, policy purgePolicy, log zerolog.Logger) {
+	if cli.MirrorDir == "" {
+		return
+	}
+	log = log.With().Str("mirror-dir", cli.MirrorDir).Logger()
+	log.Debug().Msg("purging unindexed recipes from mirror directory")
+	if err := purgeAndPrune(ctx, cli.MirrorDir, cli.recipesIndexFile(), cli.TempDir, now, policy, log); err != nil {
+		log.Warn().Err(err).Msg("failed to purge unindexed recipes from mirror directory")
+	}
+}
+
+// purgeUnreferencedRecipes loads the recipes index at indexPath and removes on-disk data for recipes not present in the index
+// (indicating that the recipe has been deleted from Paprika) according to a configured grace period.
+// This ensures safe, delayed cleanup of deleted recipes while preventing accidental data loss from temporary index
+// inconsistencies and allowing for manual recovery of recipe data that was mistakenly deleted from Paprika.
+//
+// For recipes that are present in the index, any existing deletion marker file is considered stale and is removed.
+//
+// For recipes that are not present in the index, the function uses a timestamp-based deletion marker
+// to allow for delayed purging according to the following rules:
+//
+//   - If policy.DryRun is set, the actions that would be taken are logged but no files are created or deleted.
+//   - If policy.MarkOnly is set, deletion markers are managed as described below, but no recipe data is ever deleted.
+//   - If policy.PurgeAfter <= 0, unindexed recipes are deleted immediately without using a marker.
+//   - If a deletion marker exists, its timestamp indicates when the recipe was first observed as unindexed.
+//     The recipe data is deleted if this timestamp is older than now minus policy.PurgeAfter.
+//   - If no deletion marker exists, one is created with the current timestamp,
+//     which preserves the recipe data until a subsequent run.
+//
+// If the index is empty but local recipe data exists, nothing is marked or purged unless policy.AllowEmptyIndex is set,
+// since an empty index is more likely to result from an API or authentication problem than from deleting every recipe.
+// Similarly, if more than policy.MaxPurgePercent of local recipes would be purged, an error is returned before anything
+// is marked or purged, unless policy.Force is set. Before anything is marked or purged, the numbers of indexed, local,
+// unindexed, and purgeable recipes are logged, along with whether (and why) the purge is blocked by these safeguards.
+//
+// The function respects context cancellation and aborts early if the context is canceled.
+// If any filesystem or decoding error is encountered, further cleanup is aborted and the error is returned.
+// The number of recipe directories from which recipe data or stale deletion markers were removed is returned,
+// including when an error is returned.
+func purgeUnreferencedRecipes(ctx context.Context, dataDir, indexPath string, now time.Time, policy purgePolicy, log zerolog.Logger) (removed int, err error) {
+	cutoff := now.Add(-policy.PurgeAfter)
+	log = log.With().
+		Time("purge-cutoff", cutoff).
+		Time("check-timestamp", now).
+		Logger()
+
+	index, err := LoadRecipesIndex(indexPath)
+	if err != nil {
+		return 0, err
+	}
+	indexedHashes := make(map[string]string, len(index))
+	for _, item := range index {
+		indexedHashes[item.UID] = item.Hash
+	}
+
+	recipesDataRoot := pathToRecipesDir(dataDir)
+	preflight, err := preflightPurge(recipesDataRoot, indexedHashes, cutoff, policy)
+	if err != nil {
+		return 0, err
+	}
+	emptyIndex := len(index) == 0 && preflight.Local > 0 && !policy.AllowEmptyIndex
+	exceedsMax := policy.MaxPurgePercent > 0 && preflight.Purgeable*100 > int(policy.MaxPurgePercent)*preflight.Local
+	blockedReason := ""
+	switch {
+	case emptyIndex:
+		blockedReason = "recipes index is empty"
+	case exceedsMax && !policy.Force:
+		blockedReason = "purge would exceed the maximum percentage of local recipes"
+	}
+	log.Info().Int("indexed-recipes-count", len(index)).
+		Int("local-recipes-count", preflight.Local).
+		Int("unindexed-recipes-count", preflight.Unindexed).
+		Int("purgeable-recipes-count", preflight.Purgeable).
+		Float64("purge-percent", preflight.percent()).
+		Uint("max-purge-percent", policy.MaxPurgePercent).
+		Bool("blocked", blockedReason != "").
+		Str("blocked-reason", blockedReason).
+		Msg("purge pre-flight check")
+
+	if emptyIndex {
+		log.Warn().Str("recipes-index", indexPath).
+			Msg("recipes index is empty but local recipe data exists; skipping purge of unindexed recipes to prevent data loss (use --allow-empty-index-purge to override)")
+		return 0, nil
+	}
+	if exceedsMax {
+		if !policy.Force {
+			return 0, fmt.Errorf("purge would delete %d of %d local recipes, exceeding the maximum of %d%% (use --force-purge to override)",
+				preflight.Purgeable, preflight.Local, policy.MaxPurgePercent)
+		}
+		log.Warn().Int("local-recipes-count", preflight.Local).Int("purgeable-recipes-count", preflight.Purgeable).
+			Uint("max-purge-percent", policy.MaxPurgePercent).
+			Msg("purging more than the maximum percentage of local recipes because purge is forced")
+	}
+	err = filepath.WalkDir(recipesDataRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if isRecipeVersionsDir(recipesDataRoot, path) {
+			// Prior versions are purged along with the rest of their recipe directory
+			return filepath.SkipDir
+		}
+
+		// Make a single decision for each recipe directory, i.e. one containing a recipe file, prior versions,
+		// and/or a deletion marker, whose contents need not be walked.
+		hasRecipe, hasVersions, hasMarker, err := recipeDirContents(path, policy.ContentAddressed, indexedHashes[d.Name()])
+		if err != nil {
+			return err
+		}
+		if !hasRecipe && !hasVersions && !hasMarker {
+			return nil
+		}
+		dirRemoved, err := purgeRecipeDir(path, hasRecipe, hasVersions, hasMarker, indexedHashes, now, cutoff, policy, log)
+		if dirRemoved {
+			removed++
+		}
+		if err != nil {
+			return err
+		}
+		return filepath.SkipDir
+	})
+	return removed, err
+}
+
+// purgeRecipeDir applies the purge policy (as described for purgeUnreferencedRecipes) to the recipe directory dir,
+// which contains a recipe file (if hasRecipe), prior versions of the recipe (if hasVersions),
+// and/or a deletion marker file (if hasMarker).
+func purgeRecipeDir(dir string, hasRecipe, hasVersions, hasMarker bool, indexedHashes map[string]string, now, cutoff time.Time, policy purgePolicy, log zerolog.Logger) (removed bool, err error) {
+	uid := filepath.Base(dir)
+	markerPath := filepath.Join(dir, filenameRecipeDeleteMarker)
+	log = log.With().
+		Str("recipe-directory", dir).
+		Str("recipe-uid", uid).
+		Bool("has-recipe-file", hasRecipe).
+		Bool("has-recipe-versions", hasVersions).
+		Bool("has-deletion-marker", hasMarker).
+		Logger()
+
+	// Check if recipe is present in index
+	if _, exists := indexedHashes[uid]; exists {
+		if !hasMarker {
+			return false, nil
+		}
+		if policy.DryRun {
+			log.Info().Msg("would delete stale deletion marker file for indexed recipe")
+			return false, nil
+		}
+		if err := os.Remove(markerPath); err != nil {
+			log.Err(err).Msg("failed to delete stale deletion marker file for indexed recipe")
+			return false, err
+		}
+		log.Debug().Msg("deleted stale deletion marker file for indexed recipe")
+		return true, nil
+	}
+
+	// Directory pertains to an unindexed recipe, likely because it was deleted from Paprika.
+	// Do one of the following:
+	// - Purge now if immediate purge is requested or a timestamp marker exists and is expired.
+	// - If no timestamp marker exists, create one.
+	// - If a timestamp marker already exists but has not expired, do nothing.
+	switch {
+	case policy.MarkOnly && hasMarker:
+		log.Debug().Msg("retaining marked local data for unindexed recipe in mark-only mode")
+		return false, nil
+	case policy.MarkOnly:
+		// Mark (below) but never purge
+	case policy.PurgeAfter <= 0:
+		// Skip checking for timestamp marker and purge immediately
+		return purgeRecipeDirData(dir, policy, log.With().Str("purge-reason", "immediate purge requested").Logger())
+	case hasMarker:
+		// Note: Recipe has not been seen in index since marker was set.
+		marker, err := readDeleteMarker(markerPath)
+		if err != nil {
+			log.Err(err).Msg("failed to read deletion marker file")
+			return false, err
+		}
+		log = log.With().
+			Time("recipe-unindexed-since", marker.UnindexedSince).
+			Str("marker-reason", marker.Reason).
+			Logger()
+		if marker.UnindexedSince.After(cutoff) {
+			log.Debug().Msg("ignoring unindexed local recipe data because marker is more recent than cutoff")
+			return false, nil
+		}
+		return purgeRecipeDirData(dir, policy, log.With().Str("purge-reason", "recipe not seen in index since cutoff").Logger())
+	}
+
+	// Create marker file since one does not already exist
+	if policy.DryRun {
+		log.Info().Msg("would write new deletion marker file for unindexed recipe")
+		return false, nil
+	}
+	marker := deleteMarker{UnindexedSince: now, Reason: deleteMarkerReasonUnindexed}
+	if err := writeDeleteMarker(markerPath, marker); err != nil {
+		if os.IsExist(err) {
+			// Marker was created since the directory was inspected
+			return false, nil
+		}
+		log.Err(err).Msg("failed to write deletion marker file for unindexed recipe")
+		return false, err
+	}
+	log.Info().Msg("wrote new deletion marker file for unindexed recipe")
+	return false, nil
+}
+
+// purgeRecipeDirData deletes the recipe directory dir and all of its contents, unless policy.DryRun is set,
+// and reports whether it was deleted. Failure to delete the directory is logged, but does not abort the purge.
+func purgeRecipeDirData(dir string, policy purgePolicy, log zerolog.Logger) (bool, error) {
+	if policy.DryRun {
+		log.Info().Msg("would delete local data for unindexed recipe")
+		return false, nil
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.Err(err).Msg("failed to delete local data directory for unindexed recipe")
+		return false, nil
+	}
+	log.Info().Msg("deleted local data for unindexed recipe")
+	return true, nil
+}
+
+// recipeDirContents reports whether dir directly contains a recipe file (see isRecipeFileName, to which
+// contentAddressed and indexedHash are passed), a directory of prior versions of the recipe (see --keep-versions),
+// and a deletion marker file.
+func recipeDirContents(dir string, contentAddressed bool, indexedHash string) (hasRecipe, hasVersions, hasMarker bool, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false, false, false, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			if entry.Name() == dirnameRecipeVersions {
+				hasVersions = true
+			}
+			continue
+		}
+		switch name := entry.Name(); {
+		case name == filenameRecipeDeleteMarker:
+			hasMarker = true
+		case isRecipeFileName(name, contentAddressed, indexedHash):
+			hasRecipe = true
+		}
+	}
+	return hasRecipe, hasVersions, hasMarker, nil
+}
+
+// purgePreflight summarizes the local recipes that a purge would affect (see preflightPurge).
+type purgePreflight struct {
+	// Local is the number of local recipes, i.e. recipe directories containing a recipe file or prior versions.
+	Local int
+	// Unindexed is the number of local recipes that are not present in the recipes index.
+	Unindexed int
+	// Purgeable is the number of unindexed local recipes that would be purged.
+	Purgeable int
+}
+
+// percent returns the percentage of local recipes that would be purged.
+func (p purgePreflight) percent() float64 {
+	if p.Local == 0 {
+		return 0
+	}
+	return float64(p.Purgeable) * 100 / float64(p.Local)
+}
+
+// preflightPurge counts the local recipes under recipesDataRoot, how many of those are not present in indexedHashes
+// (the hashes of indexed recipes, by UID),
+// and how many of the unindexed recipes are eligible to be purged according to policy, without modifying anything.
+// Nothing is eligible to be purged if policy.MarkOnly is set. A missing recipes data root has no local recipes.
+func preflightPurge(recipesDataRoot string, indexedHashes map[string]string, cutoff time.Time, policy purgePolicy) (p purgePreflight, err error) {
+	err = filepath.WalkDir(recipesDataRoot, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == recipesDataRoot {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if isRecipeVersionsDir(recipesDataRoot, path) {
+			return filepath.SkipDir
+		}
+		hasRecipe, hasVersions, hasMarker, err := recipeDirContents(path, policy.ContentAddressed, indexedHashes[d.Name()])
+		if err != nil {
+			return err
+		}
+		if !hasRecipe && !hasVersions {
+			return nil
+		}
+		p.Local++
+
+		if _, indexed := indexedHashes[filepath.Base(path)]; indexed {
+			return filepath.SkipDir
+		}
+		p.Unindexed++
+		switch {
+		case policy.MarkOnly:
+		case policy.PurgeAfter <= 0:
+			p.Purgeable++
+		case hasMarker:
+			marker, err := readDeleteMarker(filepath.Join(path, filenameRecipeDeleteMarker))
+			if err != nil {
+				return err
+			}
+			if !marker.UnindexedSince.After(cutoff) {
+				p.Purgeable++
+			}
+		}
+		return filepath.SkipDir
+	})
+	return p, err
+}
+
+// deleteMarker is the content of a deletion marker file, which records when (and why)
+// local data for a recipe was first found to be unindexed.
+type deleteMarker struct {
+	UnindexedSince time.Time `json:"unindexed_since"`
+	Reason         string    `json:"reason,omitempty"`
+}
+
+// deleteMarkerReasonUnindexed is the reason recorded in deletion markers written for recipes missing from the index.
+const deleteMarkerReasonUnindexed = "recipe not found in recipes index"
+
+// readDeleteMarker reads and decodes the deletion marker file at path.
+// Markers written by earlier versions, which contain only an RFC 3339 timestamp, are also supported.
+func readDeleteMarker(path string) (deleteMarker, error) {
+	var m deleteMarker
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return m, err
+	}
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("{")) {
+		err = json.Unmarshal(data, &m)
+		return m, err
+	}
+	m.UnindexedSince, err = time.Parse(time.RFC3339Nano, string(data))
+	return m, err
+}
+
+// writeDeleteMarker creates a deletion marker file at path, removing it again if it cannot be fully written.
+// It returns an error satisfying os.IsExist if the marker already exists.
+func writeDeleteMarker(path string, m deleteMarker) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+	if err != nil {
+		return err
+	}
+	if err := json.NewEncoder(f).Encode(m); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+// PruneFilelessSubtrees removes subdirectories under the given root directory tree
+// that themselves consist of only directories, recursively.
+// root itself is never removed.
+// Calls to os.RemoveAll() are optimized to occur at the top-most possible level,
+// in order to minimize filesystem writes.
+func PruneFilelessSubtrees(ctx context.Context, root string) error {
+	// Recursive directory traverse-and-prune function
+	var pruneDir func(dir string) (fileless bool, err error)
+	pruneDir = func(dir string) (bool, error) {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return false, fmt.Errorf("read dir %q: %w", dir, err)
+		}
+
+		hasOnlyDirs := true
+		var emptyChildren []string
+		for _, e := range entries {
+			if err := ctx.Err(); err != nil {
+				return false, err
+			}
+			if !e.IsDir() {
+				hasOnlyDirs = false
+				continue
+			}
+
+			childPath := filepath.Join(dir, e.Name())
+
+			childHasOnlyDirs, err := pruneDir(childPath)
+			if err != nil {
+				return false, err
+			}
+
+			if childHasOnlyDirs {
+				emptyChildren = append(emptyChildren, childPath)
+			} else {
+				hasOnlyDirs = false
+			}
+		}
+
+		if !hasOnlyDirs {
+			// This dir cannot be entirely removed, so remove any fileless children now.
+			for _, p := range emptyChildren {
+				if err := ctx.Err(); err != nil {
+					return false, err
+				}
+				if err := os.RemoveAll(p); err != nil {
+					return false, fmt.Errorf("remove %q: %w", p, err)
+				}
+			}
+		}
+
+		return hasOnlyDirs, nil
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return fmt.Errorf("read root %q: %w", root, err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		childPath := filepath.Join(root, e.Name())
+
+		childHasOnlyDirs, err := pruneDir(childPath)
+		if err != nil {
+			return err
+		}
+
+		if childHasOnlyDirs {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := os.RemoveAll(childPath); err != nil {
+				return fmt.Errorf("remove %q: %w", childPath, err)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,725 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TylerHendrickson/paprika"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPurgeAfterUnmarshalText(t *testing.T) {
+	var p PurgeAfter
+	require.NoError(t, p.UnmarshalText([]byte("2h30m")))
+	assert.Equal(t, PurgeAfter(150*time.Minute), p)
+
+	err := p.UnmarshalText([]byte("-5m"))
+	require.EqualError(t, err, "duration cannot be negative")
+}
+
+func TestPurgeAfterString(t *testing.T) {
+	assert.Equal(t, "<never>", (*PurgeAfter)(nil).String())
+
+	var zero PurgeAfter
+	assert.Equal(t, "<immediate>", zero.String())
+
+	p := PurgeAfter(3 * time.Hour)
+	assert.Equal(t, "3h0m0s", p.String())
+}
+
+func TestPurgeUnreferencedRecipes(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	t.Run("purgesExpiredUnindexedRecipe", func(t *testing.T) {
+		tempDir := t.TempDir()
+		require.NoError(t, saveAsJSON([]paprika.RecipeItem{{UID: "keep1", Hash: "h1"}}, pathToRecipesIndexFile(tempDir)))
+
+		uid := "old11"
+		recipeDir := pathToRecipeDir(tempDir, uid)
+		require.NoError(t, os.MkdirAll(recipeDir, 0755))
+		require.NoError(t, os.WriteFile(pathToRecipeJSONFile(tempDir, uid), []byte(`{"uid":"old11","hash":"old"}`), 0644))
+		require.NoError(t, os.WriteFile(pathToRecipeDeleteMarkerFile(tempDir, uid), []byte(now.Add(-48*time.Hour).Format(time.RFC3339Nano)), 0644))
+
+		_, err := purgeUnreferencedRecipes(context.Background(), tempDir, pathToRecipesIndexFile(tempDir), now, purgePolicy{PurgeAfter: 24 * time.Hour}, newTestLogger())
+		require.NoError(t, err)
+
+		_, err = os.Stat(recipeDir)
+		require.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("createsMarkerForNewUnindexedRecipe", func(t *testing.T) {
+		tempDir := t.TempDir()
+		require.NoError(t, saveAsJSON([]paprika.RecipeItem{{UID: "other1", Hash: "h1"}}, pathToRecipesIndexFile(tempDir)))
+
+		uid := "new22"
+		recipeDir := pathToRecipeDir(tempDir, uid)
+		require.NoError(t, os.MkdirAll(recipeDir, 0755))
+		require.NoError(t, os.WriteFile(pathToRecipeJSONFile(tempDir, uid), []byte(`{"uid":"new22","hash":"h"}`), 0644))
+
+		_, err := purgeUnreferencedRecipes(context.Background(), tempDir, pathToRecipesIndexFile(tempDir), now, purgePolicy{PurgeAfter: time.Hour}, newTestLogger())
+		require.NoError(t, err)
+
+		markerPath := pathToRecipeDeleteMarkerFile(tempDir, uid)
+		data, err := os.ReadFile(markerPath)
+		require.NoError(t, err)
+
+		var marker deleteMarker
+		require.NoError(t, json.Unmarshal(data, &marker))
+		assert.True(t, now.Equal(marker.UnindexedSince))
+		assert.Equal(t, deleteMarkerReasonUnindexed, marker.Reason)
+	})
+
+	t.Run("retainsUnexpiredMarker", func(t *testing.T) {
+		tempDir := t.TempDir()
+		require.NoError(t, saveAsJSON([]paprika.RecipeItem{{UID: "other1", Hash: "h1"}}, pathToRecipesIndexFile(tempDir)))
+
+		uid := "recent3"
+		recipeDir := pathToRecipeDir(tempDir, uid)
+		require.NoError(t, os.MkdirAll(recipeDir, 0755))
+		marker := now.Add(-10 * time.Minute).Format(time.RFC3339Nano)
+		require.NoError(t, os.WriteFile(pathToRecipeDeleteMarkerFile(tempDir, uid), []byte(marker), 0644))
+
+		_, err := purgeUnreferencedRecipes(context.Background(), tempDir, pathToRecipesIndexFile(tempDir), now, purgePolicy{PurgeAfter: time.Hour}, newTestLogger())
+		require.NoError(t, err)
+
+		_, err = os.Stat(recipeDir)
+		require.NoError(t, err)
+	})
+
+	t.Run("removesStaleMarkerForIndexedRecipe", func(t *testing.T) {
+		tempDir := t.TempDir()
+		require.NoError(t, saveAsJSON([]paprika.RecipeItem{{UID: "keepm", Hash: "h1"}}, pathToRecipesIndexFile(tempDir)))
+
+		recipeDir := pathToRecipeDir(tempDir, "keepm")
+		require.NoError(t, os.MkdirAll(recipeDir, 0755))
+		require.NoError(t, os.WriteFile(pathToRecipeDeleteMarkerFile(tempDir, "keepm"), []byte(now.Add(-time.Hour).Format(time.RFC3339Nano)), 0644))
+
+		_, err := purgeUnreferencedRecipes(context.Background(), tempDir, pathToRecipesIndexFile(tempDir), now, purgePolicy{PurgeAfter: time.Hour}, newTestLogger())
+		require.NoError(t, err)
+
+		_, err = os.Stat(pathToRecipeDeleteMarkerFile(tempDir, "keepm"))
+		require.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("immediatePurgeWithoutMarker", func(t *testing.T) {
+		tempDir := t.TempDir()
+		require.NoError(t, saveAsJSON([]paprika.RecipeItem{{UID: "other1", Hash: "h1"}}, pathToRecipesIndexFile(tempDir)))
+
+		uid := "now44"
+		recipeDir := pathToRecipeDir(tempDir, uid)
+		require.NoError(t, os.MkdirAll(recipeDir, 0755))
+		require.NoError(t, os.WriteFile(pathToRecipeJSONFile(tempDir, uid), []byte(`{"uid":"now44"}`), 0644))
+
+		_, err := purgeUnreferencedRecipes(context.Background(), tempDir, pathToRecipesIndexFile(tempDir), now, purgePolicy{}, newTestLogger())
+		require.NoError(t, err)
+
+		_, err = os.Stat(recipeDir)
+		require.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("markOnlyNeverPurges", func(t *testing.T) {
+		tempDir := t.TempDir()
+		require.NoError(t, saveAsJSON([]paprika.RecipeItem{{UID: "other1", Hash: "h1"}}, pathToRecipesIndexFile(tempDir)))
+
+		markedUID, newUID := "mark1", "mark2"
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: markedUID}, pathToRecipeJSONFile(tempDir, markedUID)))
+		require.NoError(t, os.WriteFile(pathToRecipeDeleteMarkerFile(tempDir, markedUID), []byte(now.Add(-48*time.Hour).Format(time.RFC3339Nano)), 0644))
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: newUID}, pathToRecipeJSONFile(tempDir, newUID)))
+
+		_, err := purgeUnreferencedRecipes(context.Background(), tempDir, pathToRecipesIndexFile(tempDir), now, purgePolicy{MarkOnly: true}, newTestLogger())
+		require.NoError(t, err)
+
+		for _, uid := range []string{markedUID, newUID} {
+			_, err = os.Stat(pathToRecipeJSONFile(tempDir, uid))
+			require.NoError(t, err, "recipe data should be retained")
+			_, err = os.Stat(pathToRecipeDeleteMarkerFile(tempDir, uid))
+			require.NoError(t, err, "deletion marker should exist")
+		}
+	})
+
+	t.Run("markOnlyClearsMarkerForReindexedRecipe", func(t *testing.T) {
+		tempDir := t.TempDir()
+		require.NoError(t, saveAsJSON([]paprika.RecipeItem{{UID: "back1", Hash: "h1"}}, pathToRecipesIndexFile(tempDir)))
+
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: "back1"}, pathToRecipeJSONFile(tempDir, "back1")))
+		require.NoError(t, os.WriteFile(pathToRecipeDeleteMarkerFile(tempDir, "back1"), []byte(now.Format(time.RFC3339Nano)), 0644))
+
+		_, err := purgeUnreferencedRecipes(context.Background(), tempDir, pathToRecipesIndexFile(tempDir), now, purgePolicy{MarkOnly: true}, newTestLogger())
+		require.NoError(t, err)
+
+		_, err = os.Stat(pathToRecipeDeleteMarkerFile(tempDir, "back1"))
+		require.True(t, os.IsNotExist(err))
+		_, err = os.Stat(pathToRecipeJSONFile(tempDir, "back1"))
+		require.NoError(t, err)
+	})
+
+	t.Run("emptyIndex", func(t *testing.T) {
+		for _, allow := range []bool{false, true} {
+			t.Run(fmt.Sprintf("allowEmptyIndex=%t", allow), func(t *testing.T) {
+				tempDir := t.TempDir()
+				require.NoError(t, saveAsJSON([]paprika.RecipeItem{}, pathToRecipesIndexFile(tempDir)))
+				require.NoError(t, saveAsJSON(paprika.Recipe{UID: "local1"}, pathToRecipeJSONFile(tempDir, "local1")))
+
+				_, err := purgeUnreferencedRecipes(context.Background(), tempDir, pathToRecipesIndexFile(tempDir), now, purgePolicy{AllowEmptyIndex: allow}, newTestLogger())
+				require.NoError(t, err)
+
+				_, err = os.Stat(pathToRecipeDir(tempDir, "local1"))
+				assert.Equal(t, allow, os.IsNotExist(err), "local data should only be purged when an empty index is allowed")
+			})
+		}
+	})
+
+	t.Run("maxPurgePercent", func(t *testing.T) {
+		tempDir := t.TempDir()
+		require.NoError(t, saveAsJSON([]paprika.RecipeItem{{UID: "keep1", Hash: "h1"}}, pathToRecipesIndexFile(tempDir)))
+		for _, uid := range []string{"keep1", "gone1"} {
+			require.NoError(t, saveAsJSON(paprika.Recipe{UID: uid}, pathToRecipeJSONFile(tempDir, uid)))
+		}
+		// An unexpired marker means the recipe is not yet eligible for purge
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: "recent"}, pathToRecipeJSONFile(tempDir, "recent")))
+		require.NoError(t, writeDeleteMarker(pathToRecipeDeleteMarkerFile(tempDir, "recent"), deleteMarker{UnindexedSince: now}))
+		require.NoError(t, writeDeleteMarker(pathToRecipeDeleteMarkerFile(tempDir, "gone1"), deleteMarker{UnindexedSince: now.Add(-2 * time.Hour)}))
+
+		policy := purgePolicy{PurgeAfter: time.Hour, MaxPurgePercent: 20}
+		_, err := purgeUnreferencedRecipes(context.Background(), tempDir, pathToRecipesIndexFile(tempDir), now, policy, newTestLogger())
+		require.EqualError(t, err, "purge would delete 1 of 3 local recipes, exceeding the maximum of 20% (use --force-purge to override)")
+		assert.DirExists(t, pathToRecipeDir(tempDir, "gone1"))
+
+		policy.MaxPurgePercent = 34
+		removed, err := purgeUnreferencedRecipes(context.Background(), tempDir, pathToRecipesIndexFile(tempDir), now, policy, newTestLogger())
+		require.NoError(t, err)
+		assert.Equal(t, 1, removed)
+		assert.NoDirExists(t, pathToRecipeDir(tempDir, "gone1"))
+		assert.DirExists(t, pathToRecipeDir(tempDir, "recent"))
+	})
+
+	t.Run("emptyIndexWithoutLocalRecipes", func(t *testing.T) {
+		tempDir := t.TempDir()
+		require.NoError(t, saveAsJSON([]paprika.RecipeItem{}, pathToRecipesIndexFile(tempDir)))
+		require.NoError(t, os.MkdirAll(pathToRecipeDir(tempDir, "marked"), 0755))
+		require.NoError(t, os.WriteFile(pathToRecipeDeleteMarkerFile(tempDir, "marked"), []byte(now.Format(time.RFC3339Nano)), 0644))
+		removed, err := purgeUnreferencedRecipes(context.Background(), tempDir, pathToRecipesIndexFile(tempDir), now, purgePolicy{}, newTestLogger())
+		require.NoError(t, err)
+		assert.Equal(t, 1, removed)
+
+		// Marker-only directories do not count as local recipe data
+		_, err = os.Stat(pathToRecipeDir(tempDir, "marked"))
+		assert.True(t, os.IsNotExist(err))
+	})
+}
+
+func TestPurgeUnreferencedRecipesPreflight(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	newDataDir := func(t *testing.T, index []paprika.RecipeItem) string {
+		tempDir := t.TempDir()
+		require.NoError(t, saveAsJSON(index, pathToRecipesIndexFile(tempDir)))
+		for _, uid := range []string{"keep1", "keep2", "gone1", "recent"} {
+			require.NoError(t, saveAsJSON(paprika.Recipe{UID: uid}, pathToRecipeJSONFile(tempDir, uid)))
+		}
+		require.NoError(t, writeDeleteMarker(pathToRecipeDeleteMarkerFile(tempDir, "gone1"), deleteMarker{UnindexedSince: now.Add(-2 * time.Hour)}))
+		require.NoError(t, writeDeleteMarker(pathToRecipeDeleteMarkerFile(tempDir, "recent"), deleteMarker{UnindexedSince: now}))
+		return tempDir
+	}
+	index := []paprika.RecipeItem{{UID: "keep1", Hash: "h1"}, {UID: "keep2", Hash: "h2"}}
+
+	for _, tc := range []struct {
+		name           string
+		index          []paprika.RecipeItem
+		policy         purgePolicy
+		expectErr      string
+		expectedLog    string
+		expectedReason string
+	}{
+		{
+			"allowed",
+			index,
+			purgePolicy{PurgeAfter: time.Hour, MaxPurgePercent: 25},
+			"",
+			`"indexed-recipes-count":2,"local-recipes-count":4,"unindexed-recipes-count":2,"purgeable-recipes-count":1,"purge-percent":25,"max-purge-percent":25,"blocked":false`,
+			`"blocked-reason":""`,
+		},
+		{
+			"exceedsMaxPurgePercent",
+			index,
+			purgePolicy{PurgeAfter: time.Hour, MaxPurgePercent: 20},
+			"purge would delete 1 of 4 local recipes, exceeding the maximum of 20% (use --force-purge to override)",
+			`"indexed-recipes-count":2,"local-recipes-count":4,"unindexed-recipes-count":2,"purgeable-recipes-count":1,"purge-percent":25,"max-purge-percent":20,"blocked":true`,
+			`"blocked-reason":"purge would exceed the maximum percentage of local recipes"`,
+		},
+		{
+			"forced",
+			index,
+			purgePolicy{PurgeAfter: time.Hour, MaxPurgePercent: 20, Force: true},
+			"",
+			`"purge-percent":25,"max-purge-percent":20,"blocked":false`,
+			`"blocked-reason":""`,
+		},
+		{
+			"immediate",
+			index,
+			purgePolicy{MaxPurgePercent: 50},
+			"",
+			`"unindexed-recipes-count":2,"purgeable-recipes-count":2,"purge-percent":50,"max-purge-percent":50,"blocked":false`,
+			`"blocked-reason":""`,
+		},
+		{
+			"markOnly",
+			index,
+			purgePolicy{MarkOnly: true, MaxPurgePercent: 20},
+			"",
+			`"unindexed-recipes-count":2,"purgeable-recipes-count":0,"purge-percent":0,"max-purge-percent":20,"blocked":false`,
+			`"blocked-reason":""`,
+		},
+		{
+			"emptyIndex",
+			[]paprika.RecipeItem{},
+			purgePolicy{PurgeAfter: time.Hour},
+			"",
+			`"indexed-recipes-count":0,"local-recipes-count":4,"unindexed-recipes-count":4,"purgeable-recipes-count":1,"purge-percent":25,"max-purge-percent":0,"blocked":true`,
+			`"blocked-reason":"recipes index is empty"`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tempDir := newDataDir(t, tc.index)
+			var buf safeBuffer
+			_, err := purgeUnreferencedRecipes(context.Background(), tempDir, pathToRecipesIndexFile(tempDir), now, tc.policy, zerolog.New(&buf))
+			if tc.expectErr != "" {
+				require.EqualError(t, err, tc.expectErr)
+			} else {
+				require.NoError(t, err)
+			}
+			logs := buf.String()
+			assert.Contains(t, logs, tc.expectedLog)
+			assert.Contains(t, logs, tc.expectedReason+`,"message":"purge pre-flight check"`)
+			if tc.expectErr != "" || tc.expectedReason != `"blocked-reason":""` {
+				assert.DirExists(t, pathToRecipeDir(tempDir, "gone1"), "nothing should be purged when the purge is blocked")
+			}
+		})
+	}
+}
+
+func TestReadDeleteMarker(t *testing.T) {
+	expected := time.Date(2023, 5, 6, 7, 8, 9, 123456789, time.UTC)
+
+	t.Run("json", func(t *testing.T) {
+		target := filepath.Join(t.TempDir(), "marker")
+		require.NoError(t, writeDeleteMarker(target, deleteMarker{UnindexedSince: expected, Reason: "testing"}))
+
+		got, err := readDeleteMarker(target)
+		require.NoError(t, err)
+		assert.True(t, expected.Equal(got.UnindexedSince))
+		assert.Equal(t, "testing", got.Reason)
+
+		assert.True(t, os.IsExist(writeDeleteMarker(target, deleteMarker{})), "existing marker should not be overwritten")
+	})
+
+	t.Run("legacyTimestamp", func(t *testing.T) {
+		for _, tt := range []struct {
+			content string
+			want    time.Time
+		}{
+			{expected.Format(time.RFC3339Nano), expected},
+			{expected.Format(time.RFC3339Nano) + "\n", expected},
+			{expected.Format(time.RFC3339), expected.Truncate(time.Second)},
+			{" \t" + expected.Format(time.RFC3339Nano) + " \r\n\n", expected},
+			{"2023-05-06T07:08:09.5Z", time.Date(2023, 5, 6, 7, 8, 9, 5e8, time.UTC)},
+		} {
+			target := filepath.Join(t.TempDir(), "marker")
+			require.NoError(t, os.WriteFile(target, []byte(tt.content), 0644))
+
+			got, err := readDeleteMarker(target)
+			require.NoError(t, err)
+			assert.True(t, tt.want.Equal(got.UnindexedSince), "unexpected timestamp for marker %q", tt.content)
+			assert.Empty(t, got.Reason)
+		}
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		target := filepath.Join(t.TempDir(), "marker")
+		require.NoError(t, os.WriteFile(target, []byte("{not json"), 0644))
+		_, err := readDeleteMarker(target)
+		require.Error(t, err)
+	})
+}
+
+func TestPruneFilelessSubtrees(t *testing.T) {
+	tempDir := t.TempDir()
+	keepDir := filepath.Join(tempDir, "keep", "child")
+	removeDir := filepath.Join(tempDir, "remove", "empty", "nested")
+
+	require.NoError(t, os.MkdirAll(keepDir, 0755))
+	require.NoError(t, os.MkdirAll(removeDir, 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(keepDir, "file.txt"), []byte("data"), 0644))
+
+	err := PruneFilelessSubtrees(context.Background(), tempDir)
+	require.NoError(t, err)
+
+	_, err = os.Stat(keepDir)
+	require.NoError(t, err)
+
+	_, err = os.Stat(filepath.Join(tempDir, "remove"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestPurgeAndPrunePruneInterval(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	setup := func(t *testing.T) (dataDir, emptyDir string) {
+		dataDir = t.TempDir()
+		require.NoError(t, saveAsJSON([]paprika.RecipeItem{{UID: "keep1", Hash: "h1"}}, pathToRecipesIndexFile(dataDir)))
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: "keep1"}, pathToRecipeJSONFile(dataDir, "keep1")))
+		emptyDir = pathToRecipeDir(dataDir, "empty")
+		require.NoError(t, os.MkdirAll(emptyDir, 0755))
+		return dataDir, emptyDir
+	}
+	purgeAndPruneAt := func(t *testing.T, dataDir string, now time.Time, policy purgePolicy) {
+		require.NoError(t, purgeAndPrune(context.Background(), dataDir, pathToRecipesIndexFile(dataDir), "", now, policy, newTestLogger()))
+	}
+
+	t.Run("skippedWhenNothingPurged", func(t *testing.T) {
+		dataDir, emptyDir := setup(t)
+		purgeAndPruneAt(t, dataDir, now, purgePolicy{PurgeAfter: time.Hour})
+		assert.DirExists(t, emptyDir)
+		assert.NoFileExists(t, pathToLastPruneFile(dataDir))
+	})
+
+	t.Run("prunedAfterPurge", func(t *testing.T) {
+		dataDir, emptyDir := setup(t)
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: "gone1"}, pathToRecipeJSONFile(dataDir, "gone1")))
+		purgeAndPruneAt(t, dataDir, now, purgePolicy{})
+		assert.NoDirExists(t, emptyDir)
+		assert.NoDirExists(t, pathToRecipeDir(dataDir, "gone1"))
+		assert.FileExists(t, pathToRecipeJSONFile(dataDir, "keep1"))
+		assert.FileExists(t, pathToLastPruneFile(dataDir))
+	})
+
+	t.Run("prunedWhenIntervalElapsed", func(t *testing.T) {
+		dataDir, emptyDir := setup(t)
+		policy := purgePolicy{PurgeAfter: time.Hour, PruneInterval: 24 * time.Hour}
+
+		// Pruning is due when there is no record of a previous prune
+		purgeAndPruneAt(t, dataDir, now, policy)
+		assert.NoDirExists(t, emptyDir)
+		data, err := os.ReadFile(pathToLastPruneFile(dataDir))
+		require.NoError(t, err)
+		assert.Equal(t, now.Format(time.RFC3339Nano)+"\n", string(data))
+
+		require.NoError(t, os.MkdirAll(emptyDir, 0755))
+		purgeAndPruneAt(t, dataDir, now.Add(23*time.Hour), policy)
+		assert.DirExists(t, emptyDir, "prune interval has not elapsed")
+
+		purgeAndPruneAt(t, dataDir, now.Add(24*time.Hour), policy)
+		assert.NoDirExists(t, emptyDir)
+	})
+
+	t.Run("dryRun", func(t *testing.T) {
+		dataDir, emptyDir := setup(t)
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: "gone1"}, pathToRecipeJSONFile(dataDir, "gone1")))
+		purgeAndPruneAt(t, dataDir, now, purgePolicy{DryRun: true, PruneInterval: time.Hour})
+		assert.DirExists(t, emptyDir)
+		assert.NoFileExists(t, pathToLastPruneFile(dataDir))
+	})
+}
+
+func TestPurgeUnreferencedRecipesDryRun(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	tempDir := t.TempDir()
+	require.NoError(t, saveAsJSON([]paprika.RecipeItem{{UID: "keep1", Hash: "h1"}}, pathToRecipesIndexFile(tempDir)))
+
+	// Indexed recipe with stale marker
+	require.NoError(t, saveAsJSON(paprika.Recipe{UID: "keep1"}, pathToRecipeJSONFile(tempDir, "keep1")))
+	require.NoError(t, os.WriteFile(pathToRecipeDeleteMarkerFile(tempDir, "keep1"), []byte(now.Format(time.RFC3339Nano)), 0644))
+	// Unindexed recipe with expired marker
+	require.NoError(t, saveAsJSON(paprika.Recipe{UID: "old11"}, pathToRecipeJSONFile(tempDir, "old11")))
+	require.NoError(t, os.WriteFile(pathToRecipeDeleteMarkerFile(tempDir, "old11"), []byte(now.Add(-48*time.Hour).Format(time.RFC3339Nano)), 0644))
+	// Unindexed recipe without marker
+	require.NoError(t, saveAsJSON(paprika.Recipe{UID: "new22"}, pathToRecipeJSONFile(tempDir, "new22")))
+
+	_, err := purgeUnreferencedRecipes(context.Background(), tempDir, pathToRecipesIndexFile(tempDir), now, purgePolicy{PurgeAfter: time.Hour, DryRun: true}, newTestLogger())
+	require.NoError(t, err)
+
+	for _, path := range []string{
+		pathToRecipeDeleteMarkerFile(tempDir, "keep1"),
+		pathToRecipeJSONFile(tempDir, "old11"),
+		pathToRecipeDeleteMarkerFile(tempDir, "old11"),
+		pathToRecipeJSONFile(tempDir, "new22"),
+	} {
+		_, err := os.Stat(path)
+		require.NoError(t, err, "%s should be unchanged in dry-run mode", path)
+	}
+	_, err = os.Stat(pathToRecipeDeleteMarkerFile(tempDir, "new22"))
+	require.True(t, os.IsNotExist(err), "no marker should be written in dry-run mode")
+}
+
+func TestPurgeUnreferencedRecipesDecidesOncePerDirectory(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	expired := now.Add(-48 * time.Hour)
+	unexpired := now.Add(-10 * time.Minute)
+
+	for _, tt := range []struct {
+		name        string
+		indexed     bool
+		markerFirst bool
+		markedAt    time.Time
+		policy      purgePolicy
+		wantRecipe  bool
+		wantMarker  bool
+		wantMessage string
+	}{
+		{"expired marker then recipe", false, true, expired, purgePolicy{PurgeAfter: time.Hour},
+			false, false, "deleted local data for unindexed recipe"},
+		{"recipe then expired marker", false, false, expired, purgePolicy{PurgeAfter: time.Hour},
+			false, false, "deleted local data for unindexed recipe"},
+		{"unexpired marker then recipe", false, true, unexpired, purgePolicy{PurgeAfter: time.Hour},
+			true, true, "ignoring unindexed local recipe data because marker is more recent than cutoff"},
+		{"recipe then unexpired marker", false, false, unexpired, purgePolicy{PurgeAfter: time.Hour},
+			true, true, "ignoring unindexed local recipe data because marker is more recent than cutoff"},
+		{"immediate purge", false, true, unexpired, purgePolicy{},
+			false, false, "deleted local data for unindexed recipe"},
+		{"mark only", false, false, expired, purgePolicy{MarkOnly: true},
+			true, true, "retaining marked local data for unindexed recipe in mark-only mode"},
+		{"dry run", false, true, expired, purgePolicy{PurgeAfter: time.Hour, DryRun: true},
+			true, true, "would delete local data for unindexed recipe"},
+		{"indexed marker then recipe", true, true, expired, purgePolicy{PurgeAfter: time.Hour},
+			true, false, "deleted stale deletion marker file for indexed recipe"},
+		{"indexed recipe then marker", true, false, expired, purgePolicy{PurgeAfter: time.Hour},
+			true, false, "deleted stale deletion marker file for indexed recipe"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tempDir := t.TempDir()
+			const uid = "both1"
+			index := []paprika.RecipeItem{{UID: "other1", Hash: "h1"}}
+			if tt.indexed {
+				index = append(index, paprika.RecipeItem{UID: uid, Hash: "h2"})
+			}
+			require.NoError(t, saveAsJSON(index, pathToRecipesIndexFile(tempDir)))
+			require.NoError(t, saveAsJSON(paprika.Recipe{UID: "other1"}, pathToRecipeJSONFile(tempDir, "other1")))
+
+			writeRecipe := func() {
+				require.NoError(t, saveAsJSON(paprika.Recipe{UID: uid}, pathToRecipeJSONFile(tempDir, uid)))
+			}
+			writeMarker := func() {
+				require.NoError(t, os.MkdirAll(pathToRecipeDir(tempDir, uid), 0755))
+				require.NoError(t, writeDeleteMarker(pathToRecipeDeleteMarkerFile(tempDir, uid),
+					deleteMarker{UnindexedSince: tt.markedAt, Reason: deleteMarkerReasonUnindexed}))
+			}
+			if tt.markerFirst {
+				writeMarker()
+				writeRecipe()
+			} else {
+				writeRecipe()
+				writeMarker()
+			}
+
+			var buf safeBuffer
+			_, err := purgeUnreferencedRecipes(context.Background(), tempDir, pathToRecipesIndexFile(tempDir), now, tt.policy, zerolog.New(&buf))
+			require.NoError(t, err)
+
+			_, err = os.Stat(pathToRecipeJSONFile(tempDir, uid))
+			assert.Equal(t, tt.wantRecipe, err == nil, "unexpected recipe file presence")
+			_, err = os.Stat(pathToRecipeDeleteMarkerFile(tempDir, uid))
+			assert.Equal(t, tt.wantMarker, err == nil, "unexpected deletion marker presence")
+
+			var decisions []string
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				var entry struct {
+					UID     string `json:"recipe-uid"`
+					Message string `json:"message"`
+				}
+				require.NoError(t, json.Unmarshal([]byte(line), &entry))
+				if entry.UID == uid {
+					decisions = append(decisions, entry.Message)
+				}
+			}
+			assert.Equal(t, []string{tt.wantMessage}, decisions, "expected a single decision for the recipe directory")
+		})
+	}
+}
+
+func TestPurgeUnreferencedRecipesContentAddressed(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	tempDir := t.TempDir()
+	require.NoError(t, saveAsJSON([]paprika.RecipeItem{{UID: "keep1", Hash: "h2"}}, pathToRecipesIndexFile(tempDir)))
+	for _, f := range []struct{ uid, hash string }{{"keep1", "h1"}, {"keep1", "h2"}, {"old11", "h1"}, {"new22", "h1"}} {
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: f.uid, Hash: f.hash}, pathToRecipeHashFile(tempDir, f.uid, f.hash)))
+	}
+	require.NoError(t, writeDeleteMarker(pathToRecipeDeleteMarkerFile(tempDir, "old11"),
+		deleteMarker{UnindexedSince: now.Add(-48 * time.Hour), Reason: deleteMarkerReasonUnindexed}))
+
+	policy := purgePolicy{PurgeAfter: time.Hour, ContentAddressed: true}
+	preflight, err := preflightPurge(pathToRecipesDir(tempDir), map[string]string{"keep1": "h2"}, now.Add(-time.Hour), policy)
+	require.NoError(t, err)
+	assert.Equal(t, purgePreflight{Local: 3, Unindexed: 2, Purgeable: 1}, preflight, "each recipe directory should be counted once")
+
+	_, err = purgeUnreferencedRecipes(context.Background(), tempDir, pathToRecipesIndexFile(tempDir), now, policy, newTestLogger())
+	require.NoError(t, err)
+	assert.FileExists(t, pathToRecipeHashFile(tempDir, "keep1", "h1"))
+	assert.FileExists(t, pathToRecipeHashFile(tempDir, "keep1", "h2"))
+	assert.NoDirExists(t, pathToRecipeDir(tempDir, "old11"))
+	assert.FileExists(t, pathToRecipeDeleteMarkerFile(tempDir, "new22"))
+}
+
+func TestPurgeUnreferencedRecipesIgnoresUnrelatedJSON(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	tempDir := t.TempDir()
+	require.NoError(t, saveAsJSON([]paprika.RecipeItem{{UID: "keep1", Hash: "h1"}}, pathToRecipesIndexFile(tempDir)))
+	require.NoError(t, saveAsJSON(paprika.Recipe{UID: "keep1", Hash: "h1"}, pathToRecipeHashFile(tempDir, "keep1", "h1")))
+	// Neither recipe files (without --content-addressed) nor the recipes of their directories
+	notes := filepath.Join(pathToRecipeDir(tempDir, "other"), "notes.json")
+	require.NoError(t, saveAsJSON(map[string]string{"k": "v"}, notes))
+	require.NoError(t, saveAsJSON(map[string]string{"k": "v"}, filepath.Join(pathToRecipesDir(tempDir), "extra.json")))
+
+	preflight, err := preflightPurge(pathToRecipesDir(tempDir), map[string]string{"keep1": "h1"}, now, purgePolicy{})
+	require.NoError(t, err)
+	assert.Equal(t, purgePreflight{Local: 1}, preflight)
+
+	_, err = purgeUnreferencedRecipes(context.Background(), tempDir, pathToRecipesIndexFile(tempDir), now, purgePolicy{}, newTestLogger())
+	require.NoError(t, err)
+	assert.FileExists(t, pathToRecipeHashFile(tempDir, "keep1", "h1"))
+	assert.FileExists(t, notes)
+	assert.NoFileExists(t, pathToRecipeDeleteMarkerFile(tempDir, "other"))
+}
+
+func TestIsRecipeFileName(t *testing.T) {
+	for name, want := range map[string]bool{
+		filenameRecipeJSON:         true,
+		"0123abcdef.json":          true,
+		filenameRecipeCategories:   false,
+		filenameRecipeDeleteMarker: false,
+		".recipe.json.123.tmp":     false,
+		"a b.json":                 false,
+	} {
+		assert.Equal(t, want, isRecipeFileName(name, true, ""), name)
+	}
+
+	t.Run("notContentAddressed", func(t *testing.T) {
+		assert.True(t, isRecipeFileName(filenameRecipeJSON, false, ""))
+		assert.True(t, isRecipeFileName("0123abcdef.json", false, "0123abcdef"), "file named by the indexed hash")
+		assert.False(t, isRecipeFileName("0123abcdef.json", false, "fedcba3210"))
+		assert.False(t, isRecipeFileName("0123abcdef.json", false, ""))
+		assert.False(t, isRecipeFileName(filenameRecipeCategories, false, "categories"))
+	})
+}
+
+func TestValidateMaxPurgePercent(t *testing.T) {
+	require.NoError(t, (&PurgeCMD{MaxPurgePercent: 100}).Validate())
+	require.EqualError(t, (&PurgeCMD{MaxPurgePercent: 101}).Validate(), "--max-purge-percent must not exceed 100")
+	require.NoError(t, (&SyncCMD{MaxPurgePercent: 100}).Validate())
+	require.EqualError(t, (&SyncCMD{MaxPurgePercent: 101}).Validate(), "--max-purge-percent must not exceed 100")
+}
+
+func TestPurgeCMDRun(t *testing.T) {
+	newDataDir := func(t *testing.T) string {
+		tempDir := t.TempDir()
+		require.NoError(t, saveAsJSON([]paprika.RecipeItem{{UID: "keep1", Hash: "h1"}}, pathToRecipesIndexFile(tempDir)))
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: "keep1"}, pathToRecipeJSONFile(tempDir, "keep1")))
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: "gone1"}, pathToRecipeJSONFile(tempDir, "gone1")))
+		return tempDir
+	}
+
+	t.Run("purgesAndPrunes", func(t *testing.T) {
+		tempDir := newDataDir(t)
+		cmd := PurgeCMD{PurgeAfter: 0}
+		require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: tempDir}, newTestLogger()))
+
+		_, err := os.Stat(pathToRecipeJSONFile(tempDir, "keep1"))
+		require.NoError(t, err)
+		_, err = os.Stat(filepath.Join(pathToRecipesDir(tempDir), "go"))
+		require.True(t, os.IsNotExist(err), "purged recipe directory tree should be pruned")
+	})
+
+	t.Run("dryRun", func(t *testing.T) {
+		tempDir := newDataDir(t)
+		cmd := PurgeCMD{PurgeAfter: 0, DryRun: true}
+		require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: tempDir}, newTestLogger()))
+
+		_, err := os.Stat(pathToRecipeJSONFile(tempDir, "gone1"))
+		require.NoError(t, err)
+	})
+
+	t.Run("withClock", func(t *testing.T) {
+		tempDir := newDataDir(t)
+		start := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+		clock := &fakeClock{now: start}
+		cmd := PurgeCMD{PurgeAfter: PurgeAfter(time.Hour), clock: clock}
+		runPurge := func() {
+			t.Helper()
+			require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: tempDir}, newTestLogger()))
+		}
+
+		runPurge()
+		marker, err := readDeleteMarker(pathToRecipeDeleteMarkerFile(tempDir, "gone1"))
+		require.NoError(t, err)
+		assert.True(t, start.Equal(marker.UnindexedSince), "marker should record the injected clock time")
+
+		clock.now = start.Add(time.Hour - time.Second)
+		runPurge()
+		assert.FileExists(t, pathToRecipeJSONFile(tempDir, "gone1"))
+
+		clock.now = start.Add(time.Hour)
+		runPurge()
+		assert.NoDirExists(t, pathToRecipeDir(tempDir, "gone1"))
+	})
+
+	t.Run("missingIndex", func(t *testing.T) {
+		cmd := PurgeCMD{PurgeAfter: 0}
+		err := cmd.Run(context.Background(), &CLI{DataDir: t.TempDir()}, newTestLogger())
+		require.EqualError(t, err, "purge completed with errors")
+	})
+
+	t.Run("withoutCredentials", func(t *testing.T) {
+		tempDir := newDataDir(t)
+		t.Setenv("PAPRIKA_USER", "")
+		t.Setenv("PAPRIKA_PASSWORD", "")
+		devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		require.NoError(t, err)
+		defer devNull.Close()
+
+		exitCode := -1
+		Main(context.Background(), devNull, devNull,
+			[]string{"--data-dir", tempDir, "purge", "--purge-after", "0"},
+			func(code int) { exitCode = code })
+		assert.Equal(t, -1, exitCode, "should not exit with error")
+
+		_, err = os.Stat(pathToRecipeJSONFile(tempDir, "gone1"))
+		require.True(t, os.IsNotExist(err))
+	})
+}
+
+func TestPurgeCustomIndexName(t *testing.T) {
+	tempDir := t.TempDir()
+	cli := &CLI{DataDir: tempDir, RecipesIndexName: filepath.Join("indexes", "recipes.json")}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"result":[{"uid":"keep1","hash":"h1"}]}`))
+	}))
+	defer server.Close()
+	client := newMockClient(t, server)
+
+	cmd := SyncCMD{}
+	_, err := cmd.SaveRecipesIndex(context.Background(), cli, client, newTestLogger())
+	require.NoError(t, err)
+	_, err = os.Stat(filepath.Join(tempDir, "indexes", "recipes.json"))
+	require.NoError(t, err)
+	_, err = os.Stat(pathToRecipesIndexFile(tempDir))
+	require.True(t, os.IsNotExist(err), "default index file should not be written")
+
+	require.NoError(t, saveAsJSON(paprika.Recipe{UID: "keep1"}, pathToRecipeJSONFile(tempDir, "keep1")))
+	require.NoError(t, saveAsJSON(paprika.Recipe{UID: "gone1"}, pathToRecipeJSONFile(tempDir, "gone1")))
+	purge := PurgeCMD{PurgeAfter: 0}
+	require.NoError(t, purge.Run(context.Background(), cli, newTestLogger()))
+
+	_, err = os.Stat(pathToRecipeJSONFile(tempDir, "keep1"))
+	require.NoError(t, err)
+	_, err = os.Stat(pathToRecipeJSONFile(tempDir, "gone1"))
+	require.True(t, os.IsNotExist(err))
+}
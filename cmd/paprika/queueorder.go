@@ -0,0 +1,81 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/TylerHendrickson/paprika"
+	"github.com/rs/zerolog"
+)
+
+// Orders in which indexed recipe items may be queued for download, as selected by the --queue-order flag.
+const (
+	// queueOrderIndex queues items in the order of the recipes index.
+	queueOrderIndex = "index"
+	// queueOrderUID queues items by UID.
+	queueOrderUID = "uid"
+	// queueOrderName queues items by the names of their local recipe files.
+	queueOrderName = "name"
+)
+
+// queueOrder returns the recipe items to sync in the order in which they should be queued for download
+// (see --queue-order). Items are returned in their original order for the index order; otherwise,
+// a sorted copy is returned, so that the order of items is deterministic.
+//
+// The recipes index does not include recipe names, so items are ordered by the names of their local recipe files
+// for the name order. Items with the same name (or without a local recipe file, which are ordered last) are ordered by UID.
+func (cmd *SyncCMD) queueOrder(cli *CLI, items []paprika.RecipeItem, log zerolog.Logger) []paprika.RecipeItem {
+	switch cmd.QueueOrder {
+	case queueOrderUID:
+		items = slices.Clone(items)
+		slices.SortStableFunc(items, func(a, b paprika.RecipeItem) int {
+			return strings.Compare(a.UID, b.UID)
+		})
+	case queueOrderName:
+		names := make(map[string]string, len(items))
+		for _, item := range items {
+			names[item.UID] = localRecipeName(cli.recipeFile(cli.DataDir, item.UID, item.Hash))
+		}
+		items = slices.Clone(items)
+		slices.SortStableFunc(items, func(a, b paprika.RecipeItem) int {
+			nameA, nameB := names[a.UID], names[b.UID]
+			if (nameA == "") != (nameB == "") {
+				// Items without names are ordered last
+				if nameA == "" {
+					return 1
+				}
+				return -1
+			}
+			return cmp.Or(
+				strings.Compare(strings.ToLower(nameA), strings.ToLower(nameB)),
+				strings.Compare(nameA, nameB),
+				strings.Compare(a.UID, b.UID),
+			)
+		})
+	default:
+		return items
+	}
+	log.Debug().Str("queue-order", cmd.QueueOrder).
+		Int("total-items", len(items)).
+		Msg("sorted recipe items to sync")
+	return items
+}
+
+// localRecipeName returns the name of the recipe saved in the recipe file at path,
+// or an empty string if the file does not exist or cannot be decoded.
+func localRecipeName(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	var recipe struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(data, &recipe); err != nil {
+		return ""
+	}
+	return recipe.Name
+}
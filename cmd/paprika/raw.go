@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+
+	"github.com/TylerHendrickson/paprika"
+	"github.com/rs/zerolog"
+)
+
+// RawCMD is the sub-command for exploring the Paprika API by requesting arbitrary endpoints.
+type RawCMD struct {
+	Path string `arg:"" help:"Path of the API endpoint to request, relative to the Paprika API base URL (e.g. \"bookmarks\")."`
+}
+
+func (cmd *RawCMD) Run(ctx context.Context, cli *CLI, pc *paprika.Client, log zerolog.Logger) error {
+	req, err := pc.EndpointRequest(ctx, cmd.Path)
+	if err != nil {
+		return err
+	}
+	log = log.With().Str("url", req.URL.Redacted()).Logger()
+	log.Debug().Msg("requesting Paprika API endpoint")
+
+	var result json.RawMessage
+	if err := pc.DoRequest(req, &result); err != nil {
+		logAPIErrorBody(log, err)
+		return err
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, result, "", "  "); err != nil {
+		return err
+	}
+	out.WriteByte('\n')
+	_, err = out.WriteTo(cli.stdout)
+	return err
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRawCMDRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/meals" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"result":[{"uid":"m1","name":"Dinner"}]}`))
+	}))
+	defer server.Close()
+	client := newMockClient(t, server)
+
+	run := func(t *testing.T, path string) (string, error) {
+		out, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+		require.NoError(t, err)
+		defer out.Close()
+		cmd := RawCMD{Path: path}
+		runErr := cmd.Run(context.Background(), &CLI{stdout: out}, client, newTestLogger())
+		data, err := os.ReadFile(out.Name())
+		require.NoError(t, err)
+		return string(data), runErr
+	}
+
+	t.Run("printsUnwrappedResult", func(t *testing.T) {
+		out, err := run(t, "meals")
+		require.NoError(t, err)
+		assert.Equal(t, "[\n  {\n    \"uid\": \"m1\",\n    \"name\": \"Dinner\"\n  }\n]\n", out)
+	})
+
+	t.Run("unknownPath", func(t *testing.T) {
+		out, err := run(t, "nope")
+		require.ErrorContains(t, err, "unexpected status code: 404")
+		assert.Empty(t, out)
+	})
+}
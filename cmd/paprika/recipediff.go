@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/TylerHendrickson/paprika"
+	"github.com/mattn/go-isatty"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/rs/zerolog"
+)
+
+const (
+	ansiRed   = "\x1b[31m"
+	ansiGreen = "\x1b[32m"
+	ansiCyan  = "\x1b[36m"
+	ansiReset = "\x1b[0m"
+)
+
+// RecipeDiffCMD is the sub-command for comparing stored versions of a recipe.
+// It does not make any requests to the Paprika API.
+type RecipeDiffCMD struct {
+	UID  string `arg:"" help:"UID of the recipe to compare."`
+	From string `help:"Hash of the older recipe version to compare. [default: (the most recent prior version)] " placeholder:"HASH"`
+	To   string `help:"Hash of the newer recipe version to compare. [default: (the current version)] " placeholder:"HASH"`
+}
+
+func (cmd *RecipeDiffCMD) Run(ctx context.Context, cli *CLI, log zerolog.Logger) error {
+	if err := validateUID(cmd.UID, true); err != nil {
+		return err
+	}
+	if cli.ContentAddressed {
+		return fmt.Errorf("recipe-diff does not support --content-addressed recipe files")
+	}
+	log = log.With().Str("recipe-uid", cmd.UID).Logger()
+
+	current, err := readRecipeFile(pathToRecipeJSONFile(cli.DataDir, cmd.UID))
+	if err != nil {
+		return fmt.Errorf("failed to read current recipe: %w", err)
+	}
+	fromHash, toHash := cmd.From, cmd.To
+	if toHash == "" {
+		toHash = current.Hash
+	}
+	if fromHash == "" {
+		versions, err := listRecipeVersions(cli.DataDir, cmd.UID)
+		if err != nil {
+			return fmt.Errorf("failed to list recipe versions: %w", err)
+		}
+		for i := len(versions) - 1; i >= 0; i-- {
+			if versions[i].Hash != toHash {
+				fromHash = versions[i].Hash
+				break
+			}
+		}
+		if fromHash == "" {
+			return fmt.Errorf("no prior versions of recipe %q are stored", cmd.UID)
+		}
+	}
+	log.Debug().Str("from-hash", fromHash).Str("to-hash", toHash).Msg("comparing recipe versions")
+
+	from, err := readRecipeVersion(cli.DataDir, cmd.UID, fromHash, current)
+	if err != nil {
+		return err
+	}
+	to, err := readRecipeVersion(cli.DataDir, cmd.UID, toHash, current)
+	if err != nil {
+		return err
+	}
+
+	diff, err := diffRecipes(from, to)
+	if err != nil {
+		return err
+	}
+	colorize := isatty.IsTerminal(cli.stdout.Fd()) && !cli.LoggingOpts.NoColor
+	return writeDiff(cli.stdout, diff, colorize)
+}
+
+// readRecipeVersion reads the version of the recipe identified by uid with the given hash,
+// which may be either the current version or a stored prior version.
+func readRecipeVersion(dataDir, uid, hash string, current paprika.Recipe) (paprika.Recipe, error) {
+	if hash == current.Hash {
+		return current, nil
+	}
+	r, err := readRecipeFile(pathToRecipeVersionFile(dataDir, uid, hash))
+	if err != nil {
+		return r, fmt.Errorf("failed to read recipe version %q: %w", hash, err)
+	}
+	return r, nil
+}
+
+// readRecipeFile reads and decodes the recipe JSON file at path.
+func readRecipeFile(path string) (paprika.Recipe, error) {
+	var r paprika.Recipe
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return r, err
+	}
+	return r, json.Unmarshal(data, &r)
+}
+
+// recipeDiffText renders the human-readable fields of r as text suitable for line-based comparison.
+func recipeDiffText(r paprika.Recipe) string {
+	var b strings.Builder
+	for _, field := range []struct{ label, value string }{
+		{"Name", r.Name},
+		{"Ingredients", r.Ingredients},
+		{"Directions", r.Directions},
+		{"Notes", r.Notes},
+	} {
+		fmt.Fprintf(&b, "%s:\n", field.label)
+		if v := strings.TrimRight(field.value, "\n"); v != "" {
+			b.WriteString(v)
+			b.WriteString("\n")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// diffRecipes returns a unified diff of the human-readable fields of two recipe versions.
+// Both versions are normalized first, so the returned diff is empty when those fields differ only in formatting.
+func diffRecipes(from, to paprika.Recipe) (string, error) {
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        difflib.SplitLines(recipeDiffText(paprika.NormalizeRecipe(from))),
+		B:        difflib.SplitLines(recipeDiffText(paprika.NormalizeRecipe(to))),
+		FromFile: from.Hash,
+		ToFile:   to.Hash,
+		Context:  3,
+	})
+}
+
+// writeDiff writes a unified diff to w, optionally highlighting additions, removals, and hunk headers.
+func writeDiff(w io.Writer, diff string, colorize bool) error {
+	if !colorize {
+		_, err := io.WriteString(w, diff)
+		return err
+	}
+	for line := range strings.Lines(diff) {
+		color := ""
+		switch {
+		case strings.HasPrefix(line, "+++"), strings.HasPrefix(line, "---"):
+		case strings.HasPrefix(line, "+"):
+			color = ansiGreen
+		case strings.HasPrefix(line, "-"):
+			color = ansiRed
+		case strings.HasPrefix(line, "@@"):
+			color = ansiCyan
+		}
+		if color != "" {
+			line = color + strings.TrimSuffix(line, "\n") + ansiReset + "\n"
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TylerHendrickson/paprika"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecipeDiffCMDRun(t *testing.T) {
+	const uid = "diff01"
+	newDataDir := func(t *testing.T) string {
+		tempDir := t.TempDir()
+		base := paprika.Recipe{UID: uid, Name: "Pancakes", Directions: "Mix.\nCook."}
+		v1, v2, v3 := base, base, base
+		v1.Hash, v1.Ingredients = "h1", "1 cup flour\n1 egg"
+		v2.Hash, v2.Ingredients = "h2", "1 cup flour\n2 eggs"
+		v3.Hash, v3.Ingredients = "h3", "1 cup flour\n2 eggs\npinch of salt"
+
+		require.NoError(t, os.MkdirAll(pathToRecipeVersionsDir(tempDir, uid), os.ModePerm))
+		start := time.Now().Add(-time.Hour)
+		for i, v := range []paprika.Recipe{v1, v2} {
+			path := pathToRecipeVersionFile(tempDir, uid, v.Hash)
+			require.NoError(t, saveAsJSON(v, path))
+			ts := start.Add(time.Duration(i) * time.Minute)
+			require.NoError(t, os.Chtimes(path, ts, ts))
+		}
+		require.NoError(t, saveAsJSON(v3, pathToRecipeJSONFile(tempDir, uid)))
+		return tempDir
+	}
+	runDiff := func(t *testing.T, cmd RecipeDiffCMD, dataDir string) (string, error) {
+		out, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+		require.NoError(t, err)
+		defer out.Close()
+		runErr := cmd.Run(context.Background(), &CLI{DataDir: dataDir, stdout: out}, newTestLogger())
+		data, err := os.ReadFile(out.Name())
+		require.NoError(t, err)
+		return string(data), runErr
+	}
+
+	t.Run("defaultsToMostRecentVersions", func(t *testing.T) {
+		out, err := runDiff(t, RecipeDiffCMD{UID: uid}, newDataDir(t))
+		require.NoError(t, err)
+		assert.Contains(t, out, "--- h2\n+++ h3\n")
+		assert.Contains(t, out, "+pinch of salt\n")
+		assert.NotContains(t, out, "\n-")
+	})
+
+	t.Run("specifiedHashes", func(t *testing.T) {
+		out, err := runDiff(t, RecipeDiffCMD{UID: uid, From: "h1", To: "h2"}, newDataDir(t))
+		require.NoError(t, err)
+		assert.Contains(t, out, "--- h1\n+++ h2\n")
+		assert.Contains(t, out, "-1 egg\n")
+		assert.Contains(t, out, "+2 eggs\n")
+		assert.NotContains(t, out, "pinch of salt")
+	})
+
+	t.Run("unknownHash", func(t *testing.T) {
+		_, err := runDiff(t, RecipeDiffCMD{UID: uid, From: "nope"}, newDataDir(t))
+		require.ErrorContains(t, err, `failed to read recipe version "nope"`)
+	})
+
+	t.Run("noPriorVersions", func(t *testing.T) {
+		tempDir := t.TempDir()
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: uid, Hash: "h1"}, pathToRecipeJSONFile(tempDir, uid)))
+		_, err := runDiff(t, RecipeDiffCMD{UID: uid}, tempDir)
+		require.EqualError(t, err, `no prior versions of recipe "diff01" are stored`)
+	})
+}
+
+func TestWriteDiffColorize(t *testing.T) {
+	diff, err := diffRecipes(
+		paprika.Recipe{Hash: "a", Ingredients: "1 egg"},
+		paprika.Recipe{Hash: "b", Ingredients: "2 eggs"},
+	)
+	require.NoError(t, err)
+
+	var b safeBuffer
+	require.NoError(t, writeDiff(&b, diff, true))
+	assert.Contains(t, b.String(), ansiRed+"-1 egg"+ansiReset+"\n")
+	assert.Contains(t, b.String(), ansiGreen+"+2 eggs"+ansiReset+"\n")
+	assert.Contains(t, b.String(), "--- a\n")
+}
+
+func TestDiffRecipesIgnoresFormatting(t *testing.T) {
+	diff, err := diffRecipes(
+		paprika.Recipe{Hash: "a", Name: "Pancakes", Directions: "Mix.\nCook."},
+		paprika.Recipe{Hash: "b", Name: "Pancakes ", Directions: "Mix.  \r\nCook.\n\n"},
+	)
+	require.NoError(t, err)
+	assert.Empty(t, diff)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/TylerHendrickson/paprika"
+	"github.com/rs/zerolog"
+)
+
+// ReindexCMD is the sub-command for rebuilding the recipes index from the recipe files in the data directory,
+// e.g. when the index file is lost or corrupt. It does not make any requests to the Paprika API.
+type ReindexCMD struct {
+	DryRun bool `help:"Log the recipes that would be indexed without saving the recipes index." env:"PAPRIKA_REINDEX_DRY_RUN"`
+}
+
+func (cmd *ReindexCMD) Run(ctx context.Context, cli *CLI, log zerolog.Logger) error {
+	path := cli.recipesIndexFile()
+	log = log.With().Bool("dry-run", cmd.DryRun).Str("path", path).Logger()
+	index, err := rebuildRecipesIndex(ctx, cli, log)
+	if err != nil {
+		log.Err(err).Msg("failed to rebuild recipes index from recipe files")
+		return err
+	}
+	if cmd.DryRun {
+		log.Info().Int("indexed-recipes-count", len(index)).Msg("would save rebuilt recipes index file")
+		return nil
+	}
+	if err := saveRecipesIndexFile(ctx, index, path, cli.TempDir, cli.IndexFormat, cli.JSONTrailingNewline); err != nil {
+		log.Err(err).Msg("failed to save rebuilt recipes index file")
+		return err
+	}
+	log.Info().Int("indexed-recipes-count", len(index)).Msg("saved rebuilt recipes index file")
+	return nil
+}
+
+// rebuildRecipesIndex builds a recipes index from the UIDs and hashes of the recipe files in the data directory,
+// in the order of their paths. When a recipe directory contains multiple recipe files (see --content-addressed),
+// the most recently modified one is indexed.
+//
+// Recipes are skipped with a warning if they have a missing or invalid UID, if they are not stored in the directory
+// for their UID, or if their UID was already indexed (i.e. the first recipe file with a UID is indexed). Recipes that are marked for deletion are skipped,
+// since they were absent from the recipes index when they were marked.
+func rebuildRecipesIndex(ctx context.Context, cli *CLI, log zerolog.Logger) ([]paprika.RecipeItem, error) {
+	index := []paprika.RecipeItem{}
+	indexed := make(map[string]string)
+	recipesDataRoot := pathToRecipesDir(cli.DataDir)
+	err := filepath.WalkDir(recipesDataRoot, func(dir string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if dir == recipesDataRoot && errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if isRecipeVersionsDir(recipesDataRoot, dir) {
+			// Prior versions of a recipe are never indexed
+			return filepath.SkipDir
+		}
+		path, hasMarker, err := latestRecipeFile(dir, cli.ContentAddressed)
+		if err != nil {
+			return err
+		}
+		if path == "" {
+			return nil
+		}
+
+		log := log.With().Str("recipe-file", path).Logger()
+		if hasMarker {
+			log.Info().Msg("not indexing recipe marked for deletion")
+			return filepath.SkipDir
+		}
+		recipe, err := readRecipeFile(path)
+		if err != nil {
+			log.Warn().Err(err).Msg("not indexing unreadable recipe file")
+			return filepath.SkipDir
+		}
+		log = log.With().Str("recipe-uid", recipe.UID).Str("recipe-hash", recipe.Hash).Logger()
+		switch {
+		case recipe.UID == "":
+			log.Warn().Msg("not indexing recipe file with missing UID")
+		case validateUID(recipe.UID, true) != nil:
+			log.Warn().Err(validateUID(recipe.UID, true)).Msg("not indexing recipe file with invalid UID")
+		case indexed[recipe.UID] != "":
+			log.Warn().Str("indexed-recipe-file", indexed[recipe.UID]).
+				Msg("not indexing recipe file with duplicate UID")
+		case filepath.Clean(dir) != filepath.Clean(pathToRecipeDir(cli.DataDir, recipe.UID)):
+			log.Warn().Msg("not indexing recipe file stored outside the directory for its UID")
+		default:
+			if recipe.Hash == "" {
+				log.Warn().Msg("indexing recipe file with missing hash")
+			}
+			indexed[recipe.UID] = path
+			index = append(index, paprika.RecipeItem{UID: recipe.UID, Hash: recipe.Hash})
+			log.Debug().Msg("indexed recipe file")
+		}
+		return filepath.SkipDir
+	})
+	return index, err
+}
+
+// latestRecipeFile returns the path of the most recently modified recipe file directly within dir
+// (or an empty path if there are none), and whether dir contains a deletion marker file.
+// Content-addressed recipe files are only recognized if contentAddressed is set (see isRecipeFileName),
+// since there is no index from which to learn the hash of the recipe.
+func latestRecipeFile(dir string, contentAddressed bool) (path string, hasMarker bool, err error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", false, err
+	}
+	var latest time.Time
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		if name == filenameRecipeDeleteMarker {
+			hasMarker = true
+			continue
+		}
+		if !isRecipeFileName(name, contentAddressed, "") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return "", false, err
+		}
+		if path == "" || info.ModTime().After(latest) {
+			path, latest = filepath.Join(dir, name), info.ModTime()
+		}
+	}
+	return path, hasMarker, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/TylerHendrickson/paprika"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReindexCMDRun(t *testing.T) {
+	newDataDir := func(t *testing.T) string {
+		dataDir := t.TempDir()
+		for _, recipe := range []paprika.Recipe{
+			{UID: "ccccc", Hash: "h3"},
+			{UID: "aaaaa", Hash: "h1"},
+			{UID: "bbbbb", Hash: "h2"},
+			{UID: "nohash"},
+		} {
+			require.NoError(t, saveAsJSON(recipe, pathToRecipeJSONFile(dataDir, recipe.UID)))
+		}
+		// Missing UID
+		require.NoError(t, saveAsJSON(paprika.Recipe{Hash: "h4"}, pathToRecipeJSONFile(dataDir, "ddddd")))
+		// Duplicates an indexed UID
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: "aaaaa", Hash: "h5"}, pathToRecipeJSONFile(dataDir, "eeeee")))
+		// UID does not match its directory
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: "zzzzz", Hash: "h7"}, pathToRecipeJSONFile(dataDir, "iiiii")))
+		// Unreadable
+		require.NoError(t, os.MkdirAll(pathToRecipeDir(dataDir, "fffff"), 0o755))
+		require.NoError(t, os.WriteFile(pathToRecipeJSONFile(dataDir, "fffff"), []byte("not json"), 0o644))
+		// Marked for deletion
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: "ggggg", Hash: "h6"}, pathToRecipeJSONFile(dataDir, "ggggg")))
+		marker := deleteMarker{UnindexedSince: time.Now().Add(-time.Hour), Reason: deleteMarkerReasonUnindexed}
+		require.NoError(t, writeDeleteMarker(pathToRecipeDeleteMarkerFile(dataDir, "ggggg"), marker))
+		// Content-addressed recipe files, of which the most recently modified is indexed
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: "hhhhh", Hash: "new"}, pathToRecipeHashFile(dataDir, "hhhhh", "new")))
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: "hhhhh", Hash: "old"}, pathToRecipeHashFile(dataDir, "hhhhh", "old")))
+		past := time.Now().Add(-time.Hour)
+		require.NoError(t, os.Chtimes(pathToRecipeHashFile(dataDir, "hhhhh", "old"), past, past))
+		return dataDir
+	}
+	expected := []paprika.RecipeItem{
+		{UID: "aaaaa", Hash: "h1"},
+		{UID: "bbbbb", Hash: "h2"},
+		{UID: "ccccc", Hash: "h3"},
+		{UID: "hhhhh", Hash: "new"},
+		{UID: "nohash"},
+	}
+
+	t.Run("rebuilds", func(t *testing.T) {
+		dataDir := newDataDir(t)
+		require.NoError(t, os.WriteFile(pathToRecipesIndexFile(dataDir), []byte("corrupt"), 0o644))
+		var buf safeBuffer
+		cmd := ReindexCMD{}
+		require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: dataDir, ContentAddressed: true}, zerolog.New(&buf)))
+
+		index, err := LoadRecipesIndex(pathToRecipesIndexFile(dataDir))
+		require.NoError(t, err)
+		assert.Equal(t, expected, index)
+		logs := buf.String()
+		assert.Contains(t, logs, `"indexed-recipes-count":5,"message":"saved rebuilt recipes index file"`)
+		assert.Contains(t, logs, "not indexing recipe file with missing UID")
+		assert.Contains(t, logs, `"indexed-recipe-file":"`+pathToRecipeJSONFile(dataDir, "aaaaa")+`","message":"not indexing recipe file with duplicate UID"`)
+		assert.Contains(t, logs, "not indexing recipe file stored outside the directory for its UID")
+		assert.Contains(t, logs, "not indexing unreadable recipe file")
+		assert.Contains(t, logs, "not indexing recipe marked for deletion")
+		assert.Contains(t, logs, `"recipe-uid":"nohash","recipe-hash":"","message":"indexing recipe file with missing hash"`)
+	})
+
+	t.Run("dryRun", func(t *testing.T) {
+		dataDir := newDataDir(t)
+		var buf safeBuffer
+		cmd := ReindexCMD{DryRun: true}
+		require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: dataDir, ContentAddressed: true}, zerolog.New(&buf)))
+		assert.NoFileExists(t, pathToRecipesIndexFile(dataDir))
+		assert.Contains(t, buf.String(), `"indexed-recipes-count":5,"message":"would save rebuilt recipes index file"`)
+	})
+
+	t.Run("notContentAddressed", func(t *testing.T) {
+		dataDir := newDataDir(t)
+		cmd := ReindexCMD{}
+		require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: dataDir}, newTestLogger()))
+
+		index, err := LoadRecipesIndex(pathToRecipesIndexFile(dataDir))
+		require.NoError(t, err)
+		assert.NotContains(t, index, paprika.RecipeItem{UID: "hhhhh", Hash: "new"}, "files named by hashes are not recipe files")
+		assert.Len(t, index, len(expected)-1)
+	})
+
+	t.Run("noRecipesDir", func(t *testing.T) {
+		dataDir := t.TempDir()
+		cmd := ReindexCMD{}
+		require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: dataDir}, newTestLogger()))
+		index, err := LoadRecipesIndex(pathToRecipesIndexFile(dataDir))
+		require.NoError(t, err)
+		assert.Empty(t, index)
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		dataDir := newDataDir(t)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		cmd := ReindexCMD{}
+		require.ErrorIs(t, cmd.Run(ctx, &CLI{DataDir: dataDir}, newTestLogger()), context.Canceled)
+		assert.NoFileExists(t, pathToRecipesIndexFile(dataDir))
+	})
+}
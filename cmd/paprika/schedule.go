@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/rand"
+	mathrand "math/rand/v2"
+	"time"
+)
+
+// jitteredInterval computes delays between scheduled runs,
+// randomly offsetting each delay from a base interval by up to a maximum jitter in either direction.
+type jitteredInterval struct {
+	interval time.Duration
+	jitter   time.Duration
+	rng      *mathrand.Rand
+}
+
+// newJitteredInterval returns a jitteredInterval whose random source is seeded once from crypto/rand.
+func newJitteredInterval(interval, jitter time.Duration) *jitteredInterval {
+	var seed [32]byte
+	_, _ = rand.Read(seed[:]) // Never returns an error
+	return &jitteredInterval{
+		interval: interval,
+		jitter:   jitter,
+		rng:      mathrand.New(mathrand.NewChaCha8(seed)),
+	}
+}
+
+// next returns the next delay, which is within [interval-jitter, interval+jitter] and never negative.
+func (j *jitteredInterval) next() time.Duration {
+	if j.jitter <= 0 {
+		return j.interval
+	}
+	offset := time.Duration(j.rng.Int64N(2*int64(j.jitter)+1)) - j.jitter
+	return max(j.interval+offset, 0)
+}
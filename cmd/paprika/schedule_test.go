@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJitteredInterval(t *testing.T) {
+	t.Run("withoutJitter", func(t *testing.T) {
+		j := newJitteredInterval(time.Minute, 0)
+		for range 10 {
+			assert.Equal(t, time.Minute, j.next())
+		}
+	})
+
+	t.Run("withJitter", func(t *testing.T) {
+		interval, jitter := time.Minute, 10*time.Second
+		j := newJitteredInterval(interval, jitter)
+		seen := map[time.Duration]struct{}{}
+		for range 100 {
+			d := j.next()
+			assert.GreaterOrEqual(t, d, interval-jitter)
+			assert.LessOrEqual(t, d, interval+jitter)
+			seen[d] = struct{}{}
+		}
+		assert.Greater(t, len(seen), 1, "jittered delays should vary")
+	})
+
+	t.Run("neverNegative", func(t *testing.T) {
+		j := newJitteredInterval(time.Second, time.Minute)
+		for range 100 {
+			assert.GreaterOrEqual(t, j.next(), time.Duration(0))
+		}
+	})
+}
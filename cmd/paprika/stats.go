@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+
+	"github.com/TylerHendrickson/paprika"
+	"github.com/rs/zerolog"
+)
+
+// StatsCMD is the sub-command for reporting statistics about locally-stored recipes.
+// It does not make any requests to the Paprika API.
+type StatsCMD struct {
+	JSON bool `help:"Print statistics as JSON."`
+}
+
+// recipeStats are statistics about locally-stored recipes.
+type recipeStats struct {
+	Recipes int `json:"recipes"`
+	// Categories are the number of recipes in each category of the categories index, from most to fewest recipes.
+	Categories []categoryCount `json:"categories"`
+	// Uncategorized is the number of recipes that are not in any category.
+	Uncategorized int `json:"uncategorized"`
+}
+
+// categoryCount is the number of recipes in a category.
+type categoryCount struct {
+	UID     string `json:"uid"`
+	Name    string `json:"name"`
+	Recipes int    `json:"recipes"`
+}
+
+func (cmd *StatsCMD) Run(ctx context.Context, cli *CLI, log zerolog.Logger) error {
+	recipes, err := loadLocalRecipes(ctx, cli, log)
+	if err != nil {
+		return err
+	}
+	categories, err := LoadCategories(cli.categoriesIndexFile())
+	if err != nil {
+		return err
+	}
+
+	stats := computeRecipeStats(recipes, categories, log)
+	w := bufio.NewWriter(cli.stdout)
+	if cmd.JSON {
+		if err := json.NewEncoder(w).Encode(stats); err != nil {
+			return err
+		}
+	} else if err := writeRecipeStatsText(w, stats); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// computeRecipeStats counts the recipes in each of categories. Counts are sorted in descending order,
+// with ties sorted by category name. Recipe categories that are not in categories are logged and otherwise ignored.
+func computeRecipeStats(recipes []paprika.Recipe, categories []paprika.Category, log zerolog.Logger) recipeStats {
+	stats := recipeStats{Recipes: len(recipes), Categories: make([]categoryCount, len(categories))}
+	positions := make(map[string]int, len(categories))
+	for i, c := range categories {
+		stats.Categories[i] = categoryCount{UID: c.UID, Name: c.Name}
+		positions[c.UID] = i
+	}
+
+	unknown := make(map[string]int)
+	for _, r := range recipes {
+		if len(r.Categories) == 0 {
+			stats.Uncategorized++
+			continue
+		}
+		// Recipes listing a category more than once are only counted once
+		for _, uid := range slices.Compact(slices.Sorted(slices.Values(r.Categories))) {
+			if i, ok := positions[uid]; ok {
+				stats.Categories[i].Recipes++
+			} else {
+				unknown[uid]++
+			}
+		}
+	}
+	for uid, count := range unknown {
+		log.Warn().Str("category-uid", uid).Int("recipes-count", count).
+			Msg("ignoring recipe category not found in categories index")
+	}
+
+	slices.SortStableFunc(stats.Categories, func(a, b categoryCount) int {
+		return cmp.Or(cmp.Compare(b.Recipes, a.Recipes), cmp.Compare(a.Name, b.Name))
+	})
+	return stats
+}
+
+// writeRecipeStatsText writes stats to w as human-readable, tab-separated text.
+func writeRecipeStatsText(w io.Writer, stats recipeStats) error {
+	if _, err := fmt.Fprintf(w, "Recipes\t%d\n\nCategory\tRecipes\n", stats.Recipes); err != nil {
+		return err
+	}
+	for _, c := range stats.Categories {
+		if _, err := fmt.Fprintf(w, "%s\t%d\n", c.Name, c.Recipes); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, "(uncategorized)\t%d\n", stats.Uncategorized)
+	return err
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TylerHendrickson/paprika"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatsCMDRun(t *testing.T) {
+	dataDir := newFixtureDir(t, []paprika.Recipe{
+		{UID: "aaaaa", Hash: "h1", Categories: []string{"c-breakfast", "c-sweet"}},
+		{UID: "bbbbb", Hash: "h2", Categories: []string{"c-breakfast", "c-breakfast"}},
+		{UID: "ccccc", Hash: "h3", Categories: []string{"c-dinner", "c-unknown"}},
+		{UID: "ddddd", Hash: "h4", Categories: []string{"c-breakfast", "c-dinner"}},
+		{UID: "eeeee", Hash: "h5"},
+		{UID: "fffff", Hash: "h6", Categories: []string{}},
+	}, []paprika.Category{
+		{UID: "c-sweet", Name: "Sweet"},
+		{UID: "c-dinner", Name: "Dinner"},
+		{UID: "c-empty", Name: "Empty"},
+		{UID: "c-breakfast", Name: "Breakfast"},
+		{UID: "c-dessert", Name: "Dessert", ParentUID: "c-sweet"},
+	})
+
+	runStats := func(t *testing.T, cmd StatsCMD) string {
+		out, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+		require.NoError(t, err)
+		defer out.Close()
+		require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: dataDir, stdout: out}, newTestLogger()))
+		data, err := os.ReadFile(out.Name())
+		require.NoError(t, err)
+		return string(data)
+	}
+
+	t.Run("text", func(t *testing.T) {
+		assert.Equal(t, "Recipes\t6\n\n"+
+			"Category\tRecipes\n"+
+			"Breakfast\t3\n"+
+			"Dinner\t2\n"+
+			"Sweet\t1\n"+
+			"Dessert\t0\n"+
+			"Empty\t0\n"+
+			"(uncategorized)\t2\n",
+			runStats(t, StatsCMD{}))
+	})
+
+	t.Run("json", func(t *testing.T) {
+		assert.JSONEq(t, `{
+			"recipes": 6,
+			"categories": [
+				{"uid": "c-breakfast", "name": "Breakfast", "recipes": 3},
+				{"uid": "c-dinner", "name": "Dinner", "recipes": 2},
+				{"uid": "c-sweet", "name": "Sweet", "recipes": 1},
+				{"uid": "c-dessert", "name": "Dessert", "recipes": 0},
+				{"uid": "c-empty", "name": "Empty", "recipes": 0}
+			],
+			"uncategorized": 2
+		}`, runStats(t, StatsCMD{JSON: true}))
+	})
+
+	t.Run("missing categories index", func(t *testing.T) {
+		require.NoError(t, os.Remove(pathToCategoriesIndexFile(dataDir)))
+		err := (&StatsCMD{}).Run(context.Background(), &CLI{DataDir: dataDir}, newTestLogger())
+		require.ErrorContains(t, err, "categories index file")
+	})
+}
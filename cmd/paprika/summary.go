@@ -0,0 +1,26 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"time"
+)
+
+// SyncSummary summarizes the outcome of a sync, and is printed to stdout by sync --summary.
+type SyncSummary struct {
+	StartedAt      time.Time `json:"started_at"`
+	FinishedAt     time.Time `json:"finished_at"`
+	DryRun         bool      `json:"dry_run,omitempty"`
+	IndexedRecipes int       `json:"indexed_recipes"`
+	SavedRecipes   int64     `json:"saved_recipes"`
+	FailedRecipes  int       `json:"failed_recipes"`
+	// Errors for (up to maxReportedRecipeFailures of) the failed recipes, formatted as "UID: error"
+	FailedRecipeErrors []string `json:"failed_recipe_errors,omitempty"`
+	MissingRecipes     int      `json:"missing_recipes"`
+	Error              string   `json:"error,omitempty"`
+}
+
+// writeSyncSummary writes summary to w as a single line of JSON.
+func writeSyncSummary(w io.Writer, summary SyncSummary) error {
+	return json.NewEncoder(w).Encode(summary)
+}
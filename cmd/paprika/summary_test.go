@@ -0,0 +1,59 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/TylerHendrickson/paprika"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncSummaryStdout(t *testing.T) {
+	restoreZerologFieldNamesCleanup(t)
+	fixtureDir := newFixtureDir(t, []paprika.Recipe{
+		{UID: "aaaaa", Hash: "h1", Name: "Pancakes"},
+		{UID: "bbbbb", Hash: "h2", Name: "Waffles"},
+	}, nil)
+	// An indexed recipe without a recipe file fails to sync
+	require.NoError(t, saveAsJSON([]paprika.RecipeItem{{UID: "aaaaa", Hash: "h1"}, {UID: "bbbbb", Hash: "h2"}, {UID: "ccccc", Hash: "h3"}},
+		pathToRecipesIndexFile(fixtureDir)))
+	stdout, err := os.Create(filepath.Join(t.TempDir(), "stdout"))
+	require.NoError(t, err)
+	defer stdout.Close()
+	stderr, err := os.Create(filepath.Join(t.TempDir(), "stderr"))
+	require.NoError(t, err)
+	defer stderr.Close()
+
+	exitCode := -1
+	Main(context.Background(), stdout, stderr,
+		[]string{"--data-dir", t.TempDir(), "--fixture-dir", fixtureDir, "--log-level", "debug", "sync", "--summary"},
+		func(code int) { exitCode = code })
+	assert.NotEqual(t, -1, exitCode, "should exit with error")
+
+	data, err := os.ReadFile(stdout.Name())
+	require.NoError(t, err)
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var summary SyncSummary
+	require.NoError(t, dec.Decode(&summary), "stdout should contain only the summary: %s", data)
+	assert.False(t, dec.More(), "stdout should contain a single summary: %s", data)
+
+	assert.Equal(t, 3, summary.IndexedRecipes)
+	assert.EqualValues(t, 2, summary.SavedRecipes)
+	assert.Equal(t, 1, summary.FailedRecipes)
+	assert.Len(t, summary.FailedRecipeErrors, 1)
+	assert.Contains(t, summary.FailedRecipeErrors[0], "ccccc: ")
+	assert.Equal(t, 1, summary.MissingRecipes)
+	assert.Contains(t, summary.Error, "sync completed with errors")
+	assert.False(t, summary.StartedAt.IsZero())
+	assert.False(t, summary.FinishedAt.Before(summary.StartedAt))
+
+	logs, err := os.ReadFile(stderr.Name())
+	require.NoError(t, err)
+	assert.Contains(t, string(logs), "saved recipe file")
+}
@@ -1,21 +1,55 @@
 package main
 
 import (
+	"bufio"
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/fs"
+	"maps"
+	"math/rand/v2"
+	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
+	"syscall"
 	"time"
 
-	"fortio.org/duration"
 	"github.com/TylerHendrickson/paprika"
 	"github.com/rs/zerolog"
 )
 
+// Date is a point in time parsed from CLI argument date or timestamp input.
+type Date time.Time
+
+// UnmarshalText parses CLI argument date input bytes.
+// It supports dates (YYYY-MM-DD, interpreted as midnight local time) and RFC 3339 timestamps.
+func (d *Date) UnmarshalText(b []byte) error {
+	if t, err := time.ParseInLocation(time.DateOnly, string(b), time.Local); err == nil {
+		*d = Date(t)
+		return nil
+	}
+	t, err := time.Parse(time.RFC3339, string(b))
+	if err != nil {
+		return fmt.Errorf("expected a date (YYYY-MM-DD) or RFC 3339 timestamp")
+	}
+	*d = Date(t)
+	return nil
+}
+
+// MarshalText formats the date as an RFC 3339 timestamp.
+func (d Date) MarshalText() ([]byte, error) {
+	return time.Time(d).MarshalText()
+}
+
 type NumWorkers int
 
 func (i NumWorkers) Validate() error {
@@ -25,80 +59,399 @@ func (i NumWorkers) Validate() error {
 	return nil
 }
 
-// PurgeAfter is a time.Duration that represents the grace period for purging unindexed recipe data.
-type PurgeAfter time.Duration
+// recipeJob is a unit of work in the sync queue.
+// Job IDs are assigned sequentially as recipe items are queued, and are logged throughout
+// the job lifecycle so that log events for a single recipe can be correlated.
+type recipeJob struct {
+	ID   int
+	Item paprika.RecipeItem
+}
 
-// UnmarshalText parses CLI argument duration input bytes.
-// It supports days (d) and weeks (w) units, in addition to units supported by time.ParseDuration().
-func (d *PurgeAfter) UnmarshalText(b []byte) error {
-	parsed, err := duration.Parse(string(b))
-	if err != nil {
-		return err
+// Reasons that a sync worker stops, which are logged with each worker's final log event.
+const (
+	// The sync was canceled (e.g. by an interrupt) before the queue was drained.
+	workerShutdownCancelled = "cancelled"
+	// The queue was drained and every task performed by the worker succeeded.
+	workerShutdownQueueDrained = "queue-drained"
+	// The queue was drained, but at least one task performed by the worker failed.
+	workerShutdownError = "error"
+)
+
+// workerShutdownCounts counts the number of sync workers that stopped for each reason.
+type workerShutdownCounts struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (c *workerShutdownCounts) add(reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]int)
 	}
-	if parsed < 0 {
-		return fmt.Errorf("duration cannot be negative")
+	c.counts[reason]++
+}
+
+// dict returns the counts as a log event dictionary.
+func (c *workerShutdownCounts) dict() *zerolog.Event {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	d := zerolog.Dict()
+	for _, reason := range []string{workerShutdownQueueDrained, workerShutdownError, workerShutdownCancelled} {
+		d.Int(reason, c.counts[reason])
 	}
-	*d = PurgeAfter(parsed)
-	return nil
+	return d
+}
+
+// maxReportedRecipeFailures is the maximum number of failed recipes that are individually reported
+// in the error returned by a sync.
+const maxReportedRecipeFailures = 10
+
+// recipeFailures collects the recipes that could not be synced, for reporting at the end of a sync.
+// It is safe for concurrent use by sync workers.
+type recipeFailures struct {
+	mu       sync.Mutex
+	count    int
+	failures []string
 }
 
-func (d *PurgeAfter) String() string {
-	if d == nil {
-		return "<never>"
+// add records that the recipe identified by uid failed with err.
+// Only the first maxReportedRecipeFailures failures are retained.
+func (f *recipeFailures) add(uid string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.count++
+	if len(f.failures) < maxReportedRecipeFailures {
+		// Error messages may include multi-line API response bodies, which are collapsed to a single line.
+		f.failures = append(f.failures, uid+": "+strings.Join(strings.Fields(err.Error()), " "))
 	}
-	dur := *d
-	if dur == 0 {
-		return "<immediate>"
+}
+
+// err returns an error summarizing the failed recipes, or nil if no recipes failed.
+func (f *recipeFailures) err() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.count == 0 {
+		return nil
+	}
+	summary := strings.Join(f.failures, "; ")
+	if omitted := f.count - len(f.failures); omitted > 0 {
+		summary += fmt.Sprintf("; and %d more", omitted)
 	}
-	return time.Duration(dur).String()
+	return fmt.Errorf("%d recipe(s) failed: %s", f.count, summary)
 }
 
+// recipeRetryDelay is the delay between successive attempts of a failed worker task for a recipe item.
+var recipeRetryDelay = 2 * time.Second
+
+// indexRetryDelay is the delay before the first reattempt to fetch the recipes index, which doubles after each attempt.
+var indexRetryDelay = 2 * time.Second
+
 // Sync is the sub-command for backing up Paprika data.
 type SyncCMD struct {
-	IncludeRecipes      bool        `help:"Whether to sync include recipes." negatable:"" default:"true" env:"PAPRIKA_SYNC_RECIPES"`
-	PurgeAfter          *PurgeAfter `help:"Grace period for retaining local data for a recipe that does not exist present in Paprika (presumably because it was deleted). Set to zero for immediate purge. [(default: data is retained indefinitely.)]" env:"PAPRIKA_SYNC_PURGE_AFTER" placeholder:"DURATION"`
-	IncludeCategories   bool        `help:"Whether to sync categories." negatable:"" default:"true" env:"PAPRIKA_SYNC_CATEGORIES"`
-	DownloadConcurrency NumWorkers  `help:"Maximum concurrent recipe downloads." default:"10" env:"PAPRIKA_SYNC_WORKERS"`
+	Include                    []string      `help:"Comma-separated data types to sync (${syncDataTypes}). Supersedes the deprecated --[no-]include-recipes and --[no-]include-categories flags. [default: (all)]" enum:"${syncDataTypes}" sep:"," env:"PAPRIKA_SYNC_INCLUDE" placeholder:"TYPES"`
+	IncludeRecipes             bool          `help:"Whether to sync include recipes. Deprecated: use --include." negatable:"" default:"true" env:"PAPRIKA_SYNC_RECIPES"`
+	PurgeAfter                 *PurgeAfter   `help:"Grace period for retaining local data for a recipe that does not exist present in Paprika (presumably because it was deleted). Set to zero for immediate purge. [(default: data is retained indefinitely.)]" xor:"purge" env:"PAPRIKA_SYNC_PURGE_AFTER" placeholder:"DURATION"`
+	AllowEmptyIndexPurge       bool          `help:"Allow purging when the recipes index is empty but local recipe data exists. By default, an empty index is presumed to be erroneous and nothing is purged." env:"PAPRIKA_SYNC_ALLOW_EMPTY_INDEX_PURGE"`
+	MaxPurgePercent            uint          `help:"Maximum percentage of local recipes that may be purged in a single run. If more would be purged, the purge is aborted unless --force-purge is set. Set to zero to disable the limit." default:"50" env:"PAPRIKA_SYNC_MAX_PURGE_PERCENT" placeholder:"PERCENT"`
+	ForcePurge                 bool          `help:"Purge even if more than --max-purge-percent of local recipes would be purged." env:"PAPRIKA_SYNC_FORCE_PURGE"`
+	PruneInterval              time.Duration `help:"Prune empty directories under the recipes data root when this much time has passed since they were last pruned, even if nothing was purged. By default, empty directories are only pruned after something is purged." env:"PAPRIKA_SYNC_PRUNE_INTERVAL" placeholder:"DURATION"`
+	MarkOnly                   bool          `help:"Write deletion markers for local recipe data that does not exist in Paprika, but never purge it." xor:"purge" env:"PAPRIKA_SYNC_MARK_ONLY"`
+	IncludeCategories          bool          `help:"Whether to sync categories. Deprecated: use --include." negatable:"" default:"true" env:"PAPRIKA_SYNC_CATEGORIES"`
+	CategoriesFirst            bool          `help:"Save the categories index before syncing any recipes, rather than concurrently. This is implied by --resolve-categories." env:"PAPRIKA_SYNC_CATEGORIES_FIRST"`
+	PrintCategoryTree          bool          `help:"After saving the categories index, log the category hierarchy as indented text." env:"PAPRIKA_SYNC_PRINT_CATEGORY_TREE"`
+	DownloadConcurrency        NumWorkers    `help:"Maximum concurrent recipe downloads." default:"10" env:"PAPRIKA_SYNC_WORKERS"`
+	QueueOrder                 string        `help:"Order in which recipes are queued for download, so that the order of logs and progress is reproducible between runs: index (the order of the recipes index), uid, or name (the names of local recipe files, since the recipes index does not include names; recipes without local files are queued last). [default: ${default}]" enum:"index,uid,name" default:"index" env:"PAPRIKA_SYNC_QUEUE_ORDER" placeholder:"ORDER"`
+	DebugWorkers               bool          `help:"Periodically log the number of recipe items waiting in the download queue, the numbers of busy and idle download workers, and the rate at which recipe items are completed, for tuning --download-concurrency. Requires --log-level=debug." env:"PAPRIKA_SYNC_DEBUG_WORKERS"`
+	QueueBuffer                uint          `help:"Number of indexed recipe items that may wait in the download queue, so that delivery of the recipes index is not blocked by busy workers. Set to zero to use the number of download workers." default:"0" env:"PAPRIKA_SYNC_QUEUE_BUFFER" placeholder:"N"`
+	ModifiedSince              *Date         `help:"Only save recipes created on or after the given date (YYYY-MM-DD) or RFC 3339 timestamp. The recipes index does not include dates, so recipes are filtered after they are fetched. Local data for filtered recipes is never purged." env:"PAPRIKA_SYNC_MODIFIED_SINCE" placeholder:"DATE"`
+	NoClobber                  bool          `help:"Never overwrite existing recipe files, even if they are outdated; only save recipes that do not yet exist locally. Local data is never purged in this mode." env:"PAPRIKA_SYNC_NO_CLOBBER"`
+	DryRun                     bool          `help:"Fetch data from Paprika and log the changes that would be made (including which fields of updated recipes changed) without modifying any local data." env:"PAPRIKA_SYNC_DRY_RUN"`
+	ConcurrentIndexAndDownload bool          `help:"Begin downloading recipes as soon as each item of the recipes index is received, rather than after the entire index has been fetched and saved. Useful for very large libraries." env:"PAPRIKA_SYNC_CONCURRENT_INDEX_AND_DOWNLOAD"`
+	RecipeUIDFile              string        `help:"Path of a file listing the UIDs of recipes to sync (one per line), which are fetched and saved regardless of whether local copies are current. The recipes index is neither fetched nor saved, and local data is never purged in this mode." type:"existingfile" env:"PAPRIKA_SYNC_RECIPE_UID_FILE" placeholder:"FILE"`
+	IntersectIndex             bool          `help:"With --recipe-uid-file, fetch and save the recipes index as usual, and only sync listed recipes that are indexed (and missing or outdated locally)." env:"PAPRIKA_SYNC_INTERSECT_INDEX"`
+	OnlyIndex                  bool          `help:"Only save the recipes and categories indexes. No recipes are downloaded and no local data is purged." env:"PAPRIKA_SYNC_ONLY_INDEX"`
+	Journal                    bool          `help:"Record each created or updated recipe in an append-only journal file (${journalFile}) in the data directory." env:"PAPRIKA_SYNC_JOURNAL"`
+	KeepVersions               uint          `help:"Number of prior versions of each recipe to retain (in a ${recipeVersionsDir}/ directory alongside each recipe file) when recipes are updated." default:"0" env:"PAPRIKA_SYNC_KEEP_VERSIONS"`
+	IndexTimeout               time.Duration `help:"Maximum duration of each attempt to fetch the recipes index. Set to zero for no timeout." default:"0" env:"PAPRIKA_SYNC_INDEX_TIMEOUT" placeholder:"DURATION"`
+	RecipeTimeout              time.Duration `help:"Maximum duration of each recipe fetch. Set to zero for no timeout." default:"0" env:"PAPRIKA_SYNC_RECIPE_TIMEOUT" placeholder:"DURATION"`
+	CategoriesTimeout          time.Duration `help:"Maximum duration of the categories index fetch. Set to zero for no timeout." default:"0" env:"PAPRIKA_SYNC_CATEGORIES_TIMEOUT" placeholder:"DURATION"`
+	MaxIdleTime                time.Duration `help:"Fail the sync as stalled if no progress (such as a saved index or a completed recipe) is made for the given duration. Set to zero to disable." default:"0" env:"PAPRIKA_SYNC_MAX_IDLE_TIME" placeholder:"DURATION"`
+	IndexMaxAttempts           uint          `help:"Maximum number of attempts to fetch the recipes index when it fails with a retryable error (a network error, or a server error or rate limiting response from the Paprika API), with exponential backoff between attempts." default:"3" env:"PAPRIKA_SYNC_INDEX_MAX_ATTEMPTS"`
+	RecipeMaxAttempts          uint          `help:"Maximum number of attempts to fetch and save each recipe (re-checking local data each time) before it is considered failed." default:"1" env:"PAPRIKA_SYNC_RECIPE_MAX_ATTEMPTS"`
+	StrictUIDValidation        bool          `help:"Reject recipes whose UIDs contain characters other than letters, digits, hyphens, and underscores, which could otherwise be used to write files outside of the data directory." negatable:"" default:"true" env:"PAPRIKA_SYNC_STRICT_UID_VALIDATION"`
+	WarnEmptyRecipes           bool          `help:"Log a warning for each fetched recipe that has no name, or neither ingredients nor directions (e.g. a placeholder), and the number of such recipes after syncing." env:"PAPRIKA_SYNC_WARN_EMPTY_RECIPES"`
+	FailOnWarning              bool          `help:"Treat warnings that indicate problems with a recipe as errors, which fail the sync without saving the recipe. These are: the fetched recipe's hash does not match its hash in the recipes index; the recipe's creation time cannot be determined for --modified-since; and a prior version of an unreadable recipe file cannot be retained for --keep-versions." env:"PAPRIKA_SYNC_FAIL_ON_WARNING"`
+	RequireComplete            bool          `help:"Fail the sync if any indexed recipe has no local recipe file after syncing." env:"PAPRIKA_SYNC_REQUIRE_COMPLETE"`
+	ResolveCategories          bool          `help:"Save the names of each saved recipe's categories (resolved using the categories index) in a ${recipeCategoriesFile} file alongside the recipe file." env:"PAPRIKA_SYNC_RESOLVE_CATEGORIES"`
+	VerifyAfterSync            bool          `help:"After syncing, verify that each saved recipe file can be read back and matches the recipe that was fetched. Recipe files are verified concurrently by up to --download-concurrency workers." env:"PAPRIKA_SYNC_VERIFY"`
+	Interval                   time.Duration `help:"Run continuously, waiting the given interval between the end of one sync and the start of the next, until interrupted. An interrupt stops syncing once the sync in progress (if any) finishes; interrupt again to exit immediately. [(default: sync once and exit.)]" env:"PAPRIKA_SYNC_INTERVAL" placeholder:"DURATION"`
+	IntervalJitter             time.Duration `help:"Randomize each wait between scheduled syncs by up to this amount (in either direction) to spread load on the Paprika API." env:"PAPRIKA_SYNC_INTERVAL_JITTER" placeholder:"DURATION"`
+	Summary                    bool          `help:"After each sync, print a JSON summary of its outcome (as a single line) to stdout. Logs are written to stderr, so stdout contains only summaries." env:"PAPRIKA_SYNC_SUMMARY"`
+	LogSample                  uint          `help:"Log only every Nth \"saved recipe file\" message to reduce log volume when syncing large libraries. Warnings and errors are never sampled. Set to zero or one to log every message." default:"0" env:"PAPRIKA_SYNC_LOG_SAMPLE" placeholder:"N"`
+	Generations                uint          `help:"Number of previous data directory snapshots (generations) to retain. Before syncing, the data directory is snapshotted (using hard links where supported) into ${generationsDir}/. Set to zero to disable." default:"0" env:"PAPRIKA_SYNC_GENERATIONS"`
+
+	// Hashes of local recipe files known from the previous sync, if loaded
+	recipeStates *recipeStateCache
+	// Category names by UID, if recipe categories are resolved
+	categoryNames map[string]string
+	// Sampler for "saved recipe file" logs, if log sampling is enabled
+	savedRecipeSampler zerolog.Sampler
+	// Source of timestamps for snapshots, journal entries, and purge markers (defaults to the system clock)
+	clock Clock
+	// Number of fetched recipes that are empty (see emptyRecipeReason), if counted
+	emptyRecipesCount *atomic.Int64
+	// Writer for fetched recipe files (defaults to writeJSONFile), e.g. to simulate faulty writes in tests
+	saveRecipeJSON func(ctx context.Context, val any, path, tempDir string, trailingNewline bool) error
+}
+
+// writeRecipeFile writes the fetched recipe val as JSON to the file at path, using the command's recipe file writer.
+func (cmd *SyncCMD) writeRecipeFile(ctx context.Context, cli *CLI, val any, path string) error {
+	save := cmd.saveRecipeJSON
+	if save == nil {
+		save = writeJSONFile
+	}
+	return save(ctx, val, path, cli.TempDir, cli.JSONTrailingNewline)
+}
+
+// now returns the current time according to the command's clock.
+func (cmd *SyncCMD) now() time.Time {
+	if cmd.clock == nil {
+		return realClock{}.Now()
+	}
+	return cmd.clock.Now()
 }
 
-func (cmd *SyncCMD) Run(ctx context.Context, cli *CLI, pc *paprika.Client, log zerolog.Logger) error {
+func (cmd *SyncCMD) Validate() error {
+	if cmd.IntersectIndex && cmd.RecipeUIDFile == "" {
+		return fmt.Errorf("--intersect-index requires --recipe-uid-file")
+	}
+	if cmd.QueueOrder != "" && cmd.QueueOrder != queueOrderIndex && cmd.ConcurrentIndexAndDownload {
+		return fmt.Errorf("--queue-order=%s cannot be used with --concurrent-index-and-download, which queues recipes as they are indexed", cmd.QueueOrder)
+	}
+	if cmd.MaxPurgePercent > 100 {
+		return fmt.Errorf("--max-purge-percent must not exceed 100")
+	}
+	return nil
+}
+
+// queueBufferSize returns the capacity of the recipe download queue.
+func (cmd *SyncCMD) queueBufferSize() int {
+	if cmd.QueueBuffer == 0 {
+		return int(cmd.DownloadConcurrency)
+	}
+	return int(cmd.QueueBuffer)
+}
+
+// Data types that may be selected using the sync command's --include flag.
+const (
+	syncDataRecipes    = "recipes"
+	syncDataCategories = "categories"
+)
+
+func (cmd *SyncCMD) Run(ctx context.Context, cli *CLI, pc RecipeFetcher, log zerolog.Logger) error {
+	if len(cmd.Include) > 0 {
+		cmd.IncludeRecipes = slices.Contains(cmd.Include, syncDataRecipes)
+		cmd.IncludeCategories = slices.Contains(cmd.Include, syncDataCategories)
+	}
+	log.Debug().Bool("include-recipes", cmd.IncludeRecipes).
+		Bool("include-categories", cmd.IncludeCategories).
+		Msg("selected data types to sync")
+	if cmd.Interval <= 0 {
+		return cmd.runOnce(ctx, cli, pc, log)
+	}
+	return cmd.runScheduled(ctx, cli, pc, log)
+}
+
+// runScheduled runs sync cycles repeatedly until ctx is canceled, waiting for the configured interval
+// between the end of one cycle and the start of the next. Cycles never overlap.
+// Errors from individual cycles are logged but do not stop subsequent cycles.
+//
+// Canceling ctx (e.g. on SIGINT) only requests a shutdown, which is checked between cycles:
+// a cycle in progress is not interrupted, and its error (if any) is returned once it finishes.
+func (cmd *SyncCMD) runScheduled(ctx context.Context, cli *CLI, pc RecipeFetcher, log zerolog.Logger) error {
+	log.Info().Dur("interval", cmd.Interval).Dur("interval-jitter", cmd.IntervalJitter).
+		Msg("starting scheduled sync")
+	stop := ctx.Done()
+	cycleCtx := context.WithoutCancel(ctx)
+	delays := newJitteredInterval(cmd.Interval, cmd.IntervalJitter)
+	for cycle := 1; ; cycle++ {
+		log := log.With().Int("sync-cycle", cycle).Logger()
+		started := time.Now()
+		err := cmd.runOnce(cycleCtx, cli, pc, log)
+		log.Info().Err(err).
+			Bool("success", err == nil).
+			Dur("duration", time.Since(started)).
+			Msg("finished scheduled sync cycle")
+
+		if ctx.Err() == nil {
+			delay := delays.next()
+			log.Debug().Dur("delay", delay).Msg("waiting for next scheduled sync cycle")
+			timer := time.NewTimer(delay)
+			select {
+			case <-stop:
+				timer.Stop()
+			case <-timer.C:
+				continue
+			}
+		}
+		log.Info().Str("reason", "shutdown requested").Msg("stopping scheduled sync")
+		return err
+	}
+}
+
+func (cmd *SyncCMD) runOnce(ctx context.Context, cli *CLI, pc RecipeFetcher, log zerolog.Logger) error {
+	startedAt := cmd.now()
+	if cmd.DryRun {
+		log = log.With().Bool("dry-run", true).Logger()
+	}
+	if cmd.Generations > 0 && cmd.DryRun {
+		log.Debug().Msg("skipping data directory snapshot in dry-run mode")
+	} else if cmd.Generations > 0 {
+		log.Debug().Uint("generations", cmd.Generations).
+			Msg("snapshotting data directory before sync")
+		if _, err := snapshotGeneration(ctx, cli.DataDir, cmd.now(), int(cmd.Generations), os.Link, log); err != nil {
+			return fmt.Errorf("failed to snapshot data directory: %w", err)
+		}
+	}
+
 	var exitWithErrors atomic.Bool
 	wg := sync.WaitGroup{}
 
+	// The watchdog and byte budget only cover fetching and saving data from Paprika;
+	// ctx is restored once that is done.
+	runCtx := ctx
+	ctx, stopBudget := cli.byteBudget.withCancel(ctx)
+	var watchdog *progressWatchdog
+	if cmd.MaxIdleTime > 0 {
+		ctx, watchdog = startProgressWatchdog(ctx, cmd.MaxIdleTime)
+	}
+
+	resolveCategories := cmd.ResolveCategories && cmd.IncludeRecipes && !cmd.OnlyIndex && !cmd.DryRun
 	if cmd.IncludeCategories {
 		log.Debug().Msg("downloading categories index from Paprika")
-		wg.Go(func() {
+		saveCategoriesIndex := func() {
 			if cmd.SaveCategoriesIndex(ctx, cli, pc, log) != nil {
 				exitWithErrors.Store(true)
 			}
-		})
+			watchdog.progress()
+		}
+		// Recipe categories are resolved using the categories index, so it must be saved first.
+		if resolveCategories || cmd.CategoriesFirst {
+			log.Debug().Msg("waiting for categories index to be saved before syncing recipes")
+			saveCategoriesIndex()
+		} else {
+			wg.Go(saveCategoriesIndex)
+		}
+	}
+	if resolveCategories {
+		categories, err := LoadCategories(cli.categoriesIndexFile())
+		if err != nil {
+			log.Err(err).Msg("failed to load categories index for resolving recipe categories")
+			exitWithErrors.Store(true)
+		} else {
+			cmd.categoryNames = make(map[string]string, len(categories))
+			for _, c := range categories {
+				cmd.categoryNames[c.UID] = c.Name
+			}
+			defer func() { cmd.categoryNames = nil }()
+		}
 	}
 
-	var savedRecipesCount atomic.Int64
-	if cmd.IncludeRecipes {
-		recipesQueue := make(chan paprika.RecipeItem, cmd.DownloadConcurrency)
-		log.Debug().Msg("downloading recipes index from Paprika")
+	var (
+		savedRecipesCount   atomic.Int64
+		savedRecipes        []paprika.RecipeItem
+		savedRecipesMu      sync.Mutex
+		workerShutdowns     workerShutdownCounts
+		failedRecipes       recipeFailures
+		missingRecipesCount int
+		indexedItems        []paprika.RecipeItem
+		workerStats         *workerStats
+	)
+	if cmd.IncludeRecipes && cmd.OnlyIndex {
+		log.Debug().Msg("downloading recipes index from Paprika (index only)")
 		wg.Go(func() {
-			defer close(recipesQueue)
-
-			recipeIndexItems, err := cmd.SaveRecipesIndex(ctx, cli, pc, log)
-			if err != nil {
+			if _, err := cmd.SaveRecipesIndex(ctx, cli, pc, log); err != nil {
 				log.Err(err).Msg("failed to update Paprika recipes index")
 				exitWithErrors.Store(true)
-				return
 			}
+			watchdog.progress()
+		})
+	} else if cmd.IncludeRecipes {
+		cmd.recipeStates = loadRecipeStateCache(pathToSyncStateFile(cli.DataDir), log)
+		defer func() { cmd.recipeStates = nil }()
+		if cmd.WarnEmptyRecipes {
+			cmd.emptyRecipesCount = new(atomic.Int64)
+			defer func() { cmd.emptyRecipesCount = nil }()
+		}
+		if cmd.LogSample > 1 {
+			cmd.savedRecipeSampler = &zerolog.LevelSampler{InfoSampler: &zerolog.BasicSampler{N: uint32(cmd.LogSample)}}
+			defer func() { cmd.savedRecipeSampler = nil }()
+		}
+		recipesQueue := make(chan recipeJob, cmd.queueBufferSize())
+		progress := newProgressEstimator(int(cmd.DownloadConcurrency), progressWindowSize, time.Now())
+		if cmd.DebugWorkers {
+			workerStats = startWorkerStats(int(cmd.DownloadConcurrency), func() int { return len(recipesQueue) }, log)
+		}
+		log.Debug().Msg("downloading recipes index from Paprika")
+		wg.Go(func() {
+			defer close(recipesQueue)
+
 			var itemsQueued int
-			for _, item := range recipeIndexItems {
+			queue := func(item paprika.RecipeItem) bool {
 				select {
 				case <-ctx.Done():
-					log.Warn().Err(ctx.Err()).
+					return false
+				case recipesQueue <- recipeJob{ID: itemsQueued + 1, Item: item}:
+					itemsQueued++
+					watchdog.progress()
+					log.Trace().Int("job-id", itemsQueued).
+						Str("recipe-uid", item.UID).
+						Msg("queued recipe item")
+					return true
+				}
+			}
+
+			if cmd.ConcurrentIndexAndDownload && cmd.RecipeUIDFile == "" {
+				recipeIndexItems, err := cmd.streamRecipesIndex(ctx, cli, pc, queue, log)
+				if ctx.Err() != nil {
+					log.Warn().Err(context.Cause(ctx)).
 						Int("items-queued", itemsQueued).
-						Int("total-items", len(recipeIndexItems)).
 						Str("reason", "shutdown requested").
 						Msg("stopping before all indexed recipe items can be queued")
 					return
-				case recipesQueue <- item:
-					itemsQueued++
+				}
+				if err != nil {
+					log.Err(err).Msg("failed to update Paprika recipes index")
+					exitWithErrors.Store(true)
+					return
+				}
+				indexedItems = recipeIndexItems
+				progress.setTotal(len(recipeIndexItems))
+			} else {
+				var recipeIndexItems []paprika.RecipeItem
+				var err error
+				if cmd.RecipeUIDFile != "" {
+					recipeIndexItems, err = cmd.recipeUIDFileItems(ctx, cli, pc, log)
+				} else if recipeIndexItems, err = cmd.SaveRecipesIndex(ctx, cli, pc, log); err != nil {
+					log.Err(err).Msg("failed to update Paprika recipes index")
+				}
+				if err != nil {
+					exitWithErrors.Store(true)
+					return
+				}
+				indexedItems = recipeIndexItems
+				progress.setTotal(len(recipeIndexItems))
+				watchdog.progress()
+				for _, item := range cmd.queueOrder(cli, recipeIndexItems, log) {
+					if !queue(item) {
+						log.Warn().Err(context.Cause(ctx)).
+							Int("items-queued", itemsQueued).
+							Int("total-items", len(recipeIndexItems)).
+							Str("reason", "shutdown requested").
+							Msg("stopping before all indexed recipe items can be queued")
+						return
+					}
 				}
 			}
 			log.Debug().Int("total-items", itemsQueued).
@@ -106,45 +459,80 @@ func (cmd *SyncCMD) Run(ctx context.Context, cli *CLI, pc *paprika.Client, log z
 		})
 
 		log.Debug().Int("max-workers", int(cmd.DownloadConcurrency)).
+			Int("queue-buffer", cap(recipesQueue)).
 			Msg("checking for new/updated recipes from Paprika")
 		for i := range cmd.DownloadConcurrency {
 			wg.Go(func() {
 				log := log.With().Int("worker-id", int(i)+1).Logger()
 				var workerSavedRecipesCount int64
+				var workerFailed bool
+				var shutdownReason string
 				defer func() {
+					workerShutdowns.add(shutdownReason)
 					if workerSavedRecipesCount > 0 {
 						log.Debug().
 							Int64("saved-recipes-count", workerSavedRecipesCount).
+							Str("shutdown-reason", shutdownReason).
 							Msg("worker saved recipes in queue")
 						savedRecipesCount.Add(workerSavedRecipesCount)
 					} else {
-						log.Debug().Msg("worker stopped before saving any recipes")
+						log.Debug().Str("shutdown-reason", shutdownReason).
+							Msg("worker stopped before saving any recipes")
 					}
 				}()
 
 				for {
 					select {
 					case <-ctx.Done():
+						shutdownReason = workerShutdownCancelled
 						log.Warn().Err(ctx.Err()).
-							Str("reason", "shutdown requested").
+							Str("shutdown-reason", shutdownReason).
 							Msg("shutting down worker")
 						return
-					case ref, ok := <-recipesQueue:
+					case job, ok := <-recipesQueue:
 						if !ok {
-							log.Debug().Str("reason", "no more work").
+							// The queue is also closed when the sync is canceled before all items are queued.
+							switch {
+							case ctx.Err() != nil:
+								shutdownReason = workerShutdownCancelled
+							case workerFailed:
+								shutdownReason = workerShutdownError
+							default:
+								shutdownReason = workerShutdownQueueDrained
+							}
+							log.Debug().Str("shutdown-reason", shutdownReason).
 								Msg("shutting down worker")
 							return
 						}
+						ref := job.Item
 						log := log.With().
+							Int("job-id", job.ID).
 							Str("recipe-uid", ref.UID).
 							Str("recipe-indexed-hash", ref.Hash).Logger()
-						saved, err := cmd.UpsertRecipe(ctx, cli, pc, ref, log)
+						log.Debug().Msg("worker started task for recipe item in queue")
+						started := time.Now()
+						workerStats.started()
+						saved, err := cmd.upsertRecipeWithRetry(ctx, cli, pc, ref, log)
+						workerStats.finished()
+						watchdog.progress()
+						if r, ok := progress.observe(time.Since(started), time.Now()); ok {
+							log.Info().
+								Int("completed-items", r.Completed).
+								Int("total-items", r.Total).
+								Dur("estimated-time-remaining", r.Remaining).
+								Msg("recipe sync progress")
+						}
 						if err != nil {
 							exitWithErrors.Store(true)
+							workerFailed = true
+							failedRecipes.add(ref.UID, err)
 							log.Err(err).Msg("worker task failed for recipe item in queue")
 						}
-						if saved {
+						if saved != nil {
 							workerSavedRecipesCount++
+							savedRecipesMu.Lock()
+							savedRecipes = append(savedRecipes, *saved)
+							savedRecipesMu.Unlock()
 						}
 					}
 				}
@@ -153,84 +541,416 @@ func (cmd *SyncCMD) Run(ctx context.Context, cli *CLI, pc *paprika.Client, log z
 	}
 
 	wg.Wait()
-	if cmd.IncludeRecipes {
+	workerStats.stop()
+	stalled := watchdog.stop()
+	stopBudget()
+	ctx = runCtx
+	if stalled {
+		log.Error().Dur("max-idle-time", cmd.MaxIdleTime).
+			Msg("sync stalled; no progress was made within the maximum idle time")
+		exitWithErrors.Store(true)
+	}
+	budgetExhausted := cli.byteBudget.isExhausted()
+	if budgetExhausted {
+		log.Warn().Int64("downloaded-bytes", cli.byteBudget.bytesUsed()).
+			Int64("max-total-bytes", cli.MaxTotalBytes).
+			Msg("byte budget exhausted; stopped syncing early")
+		exitWithErrors.Store(true)
+	}
+	if cmd.emptyRecipesCount != nil {
+		if count := cmd.emptyRecipesCount.Load(); count > 0 {
+			log.Warn().Int64("empty-recipes-count", count).
+				Msg("fetched empty recipes, which may be cleaned up in Paprika")
+		}
+	}
+	if cmd.IncludeRecipes && !cmd.OnlyIndex {
 		log.Info().Int64("total-saved", savedRecipesCount.Load()).
+			Dict("worker-shutdown-reasons", workerShutdowns.dict()).
 			Msg("saved new/updated recipes")
+		if !cmd.DryRun {
+			if err := cmd.recipeStates.save(pathToSyncStateFile(cli.DataDir), cli.TempDir); err != nil {
+				log.Warn().Err(err).Msg("failed to save sync state file")
+			}
+		}
 	}
 
-	if !exitWithErrors.Load() && cmd.PurgeAfter != nil {
+	if cmd.OnlyIndex && (cmd.PurgeAfter != nil || cmd.MarkOnly) {
+		log.Debug().Msg("skipping purge of unindexed recipes in index-only mode")
+	} else if cmd.NoClobber && (cmd.PurgeAfter != nil || cmd.MarkOnly) {
+		log.Debug().Msg("skipping purge of unindexed recipes in no-clobber mode")
+	} else if cmd.RecipeUIDFile != "" && (cmd.PurgeAfter != nil || cmd.MarkOnly) {
+		log.Debug().Msg("skipping purge of unindexed recipes in recipe UID file mode")
+	} else if cmd.DryRun && (cmd.PurgeAfter != nil || cmd.MarkOnly) {
+		// Purging is based on the saved recipes index, which is not updated in dry-run mode.
+		log.Debug().Msg("skipping purge of unindexed recipes in dry-run mode (see the purge command's --dry-run)")
+	} else if !exitWithErrors.Load() && (cmd.PurgeAfter != nil || cmd.MarkOnly) {
 		log.Debug().Str("grace-period", cmd.PurgeAfter.String()).
+			Bool("mark-only", cmd.MarkOnly).
 			Msg("purging unindexed recipes according to configured grace period")
-		if err := purgeUnreferencedRecipes(ctx, cli.DataDir, time.Now(), time.Duration(*cmd.PurgeAfter), log); err != nil {
-			log.Err(err).Msg("error purging unindexed recipes")
+		now := cmd.now()
+		if err := purgeAndPrune(ctx, cli.DataDir, cli.recipesIndexFile(), cli.TempDir, now, cmd.purgePolicy(cli), log); err != nil {
 			exitWithErrors.Store(true)
 		} else {
-			pruneRoot := pathToRecipesDir(cli.DataDir)
-			log := log.With().Str("recipes-data-root", pruneRoot).Logger()
-			log.Debug().Msg("pruning empty directories under recipes data root")
-			if err := PruneFilelessSubtrees(ctx, pruneRoot); err != nil {
-				log.Err(err).Msg("error pruning empty directories under recipes data root")
+			cli.purgeMirror(ctx, now, cmd.purgePolicy(cli), log)
+		}
+	}
+
+	// Recipes excluded by the modified-since filter are expected to have no local recipe file,
+	// and no recipe files are saved in dry-run mode.
+	if cmd.IncludeRecipes && !cmd.OnlyIndex && !cmd.DryRun && indexedItems != nil && cmd.ModifiedSince == nil {
+		if missing := missingRecipeFiles(cli, indexedItems); len(missing) > 0 {
+			missingRecipesCount = len(missing)
+			log.Warn().Strs("recipe-uids", missing).
+				Int("missing-recipes-count", len(missing)).
+				Bool("require-complete", cmd.RequireComplete).
+				Msg("indexed recipes have no local recipe file")
+			if cmd.RequireComplete {
 				exitWithErrors.Store(true)
 			}
 		}
 	}
 
+	if cmd.VerifyAfterSync && len(savedRecipes) > 0 {
+		log.Debug().Int("saved-recipes-count", len(savedRecipes)).
+			Msg("verifying saved recipe files")
+		issues, err := verifyRecipes(ctx, cli, savedRecipes, int(cmd.DownloadConcurrency))
+		for _, issue := range issues {
+			log.Error().Err(issue.Err).
+				Str("recipe-uid", issue.UID).
+				Str("recipe-file", issue.Path).
+				Msg("saved recipe file failed verification")
+		}
+		if err != nil {
+			log.Err(err).Msg("error verifying saved recipe files")
+			exitWithErrors.Store(true)
+		} else if len(issues) > 0 {
+			exitWithErrors.Store(true)
+		} else {
+			log.Info().Int("verified-recipes-count", len(savedRecipes)).
+				Msg("verified saved recipe files")
+		}
+	}
+
+	var syncErr error
 	if exitWithErrors.Load() {
-		return fmt.Errorf("sync completed with errors")
+		if stalled {
+			syncErr = fmt.Errorf("%w: no progress for %s", errSyncStalled, cmd.MaxIdleTime)
+		} else if budgetExhausted {
+			syncErr = fmt.Errorf("sync incomplete: %w after downloading %d bytes (see --max-total-bytes)",
+				errByteBudgetExhausted, cli.byteBudget.bytesUsed())
+		} else if err := failedRecipes.err(); err != nil {
+			log.Error().Int("failed-recipes-count", failedRecipes.count).
+				Strs("failed-recipes", failedRecipes.failures).
+				Msg("failed to sync recipes")
+			syncErr = fmt.Errorf("sync completed with errors: %w", err)
+		} else {
+			syncErr = fmt.Errorf("sync completed with errors")
+		}
+	} else {
+		log.Info().Msg("sync completed successfully")
 	}
-	log.Info().Msg("sync completed successfully")
-	return nil
+
+	if cmd.Summary {
+		summary := SyncSummary{
+			StartedAt:          startedAt,
+			FinishedAt:         cmd.now(),
+			DryRun:             cmd.DryRun,
+			IndexedRecipes:     len(indexedItems),
+			SavedRecipes:       savedRecipesCount.Load(),
+			FailedRecipes:      failedRecipes.count,
+			FailedRecipeErrors: failedRecipes.failures,
+			MissingRecipes:     missingRecipesCount,
+		}
+		if syncErr != nil {
+			summary.Error = syncErr.Error()
+		}
+		if err := writeSyncSummary(cli.stdout, summary); err != nil {
+			log.Err(err).Msg("failed to write sync summary")
+		}
+	}
+	return syncErr
 }
 
-func (cmd *SyncCMD) SaveCategoriesIndex(ctx context.Context, cli *CLI, c *paprika.Client, log zerolog.Logger) error {
-	categories, err := c.Categories(ctx)
+func (cmd *SyncCMD) SaveCategoriesIndex(ctx context.Context, cli *CLI, c RecipeFetcher, log zerolog.Logger) error {
+	fetchCtx, cancel := contextWithOptionalTimeout(ctx, cmd.CategoriesTimeout)
+	categories, err := c.Categories(fetchCtx)
+	cancel()
 	if err != nil {
-		log.Fatal().Err(err).Msg("failed to get categories from Paprika API")
+		logAPIErrorBody(log, err)
+		log.Err(err).Msg("failed to get categories from Paprika API")
 		return err
 	}
 
-	path := pathToCategoriesIndexFile(cli.DataDir)
+	path := cli.categoriesIndexFile()
 	log = log.With().Str("categories-index-file", path).Logger()
-	if err := saveAsJSON(categories, path); err != nil {
+	if cmd.DryRun {
+		log.Info().Int("categories-count", len(categories)).Msg("would save Paprika categories index file")
+		return nil
+	}
+	if err := writeJSONFile(ctx, categories, path, cli.TempDir, cli.JSONTrailingNewline); err != nil {
 		log.Err(err).Msg("error saving Paprika categories index file")
 		return err
 	}
 	log.Info().Msg("saved Paprika categories index file")
+
+	if cmd.PrintCategoryTree {
+		log.Info().Str("category-tree", formatCategoryTree(paprika.BuildCategoryTree(categories))).
+			Msg("reconstructed Paprika category hierarchy")
+	}
 	return nil
 }
 
-func (cmd *SyncCMD) SaveRecipesIndex(ctx context.Context, cli *CLI, c *paprika.Client, log zerolog.Logger) ([]paprika.RecipeItem, error) {
-	recipesIndex, err := c.Recipes(ctx)
+// formatCategoryTree renders a category hierarchy as text, with one category name per line
+// indented according to its depth in the hierarchy.
+func formatCategoryTree(roots []*paprika.CategoryNode) string {
+	var b strings.Builder
+	var write func(nodes []*paprika.CategoryNode, depth int)
+	write = func(nodes []*paprika.CategoryNode, depth int) {
+		for _, n := range nodes {
+			b.WriteString(strings.Repeat("  ", depth))
+			b.WriteString(n.Name)
+			b.WriteString("\n")
+			write(n.Children, depth+1)
+		}
+	}
+	write(roots, 0)
+	return b.String()
+}
+
+func (cmd *SyncCMD) SaveRecipesIndex(ctx context.Context, cli *CLI, c RecipeFetcher, log zerolog.Logger) ([]paprika.RecipeItem, error) {
+	recipesIndex, err := cmd.fetchRecipesIndexWithRetry(ctx, c, log)
 	if err != nil {
+		logAPIErrorBody(log, err)
 		log.Err(err).Msg("failed to fetch Paprika recipes index")
 		return recipesIndex, err
 	}
 	log.Debug().Int("indexed-recipes-count", len(recipesIndex)).
 		Msg("fetched Paprika recipes index")
+	recipesIndex = dedupeRecipesIndex(recipesIndex, log)
+	return recipesIndex, cmd.writeRecipesIndex(ctx, cli, recipesIndex, log)
+}
+
+// fetchRecipesIndexWithRetry fetches the recipes index, reattempting with exponential backoff when the fetch fails
+// with a retryable error, until it succeeds or the configured maximum number of attempts is reached.
+func (cmd *SyncCMD) fetchRecipesIndexWithRetry(ctx context.Context, c RecipeFetcher, log zerolog.Logger) ([]paprika.RecipeItem, error) {
+	maxAttempts := max(int(cmd.IndexMaxAttempts), 1)
+	delay := indexRetryDelay
+	for attempt := 1; ; attempt++ {
+		fetchCtx, cancel := contextWithOptionalTimeout(ctx, cmd.IndexTimeout)
+		recipesIndex, err := c.Recipes(fetchCtx)
+		// An attempt that exceeds the index timeout (as opposed to the parent context) is retryable
+		timedOut := fetchCtx.Err() != nil && ctx.Err() == nil
+		cancel()
+		if err == nil || attempt >= maxAttempts || ctx.Err() != nil || !(timedOut || isRetryableFetchError(err)) {
+			return recipesIndex, err
+		}
+		logAPIErrorBody(log, err)
+		log.Warn().Err(err).
+			Int("attempt", attempt).
+			Int("max-attempts", maxAttempts).
+			Dur("retry-delay", delay).
+			Msg("retrying failed fetch of Paprika recipes index")
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return recipesIndex, err
+		case <-timer.C:
+		}
+		delay *= 2
+	}
+}
+
+// contextWithOptionalTimeout returns a copy of ctx that is canceled after timeout,
+// or (with a no-op cancel function) ctx itself when timeout is zero or less.
+func contextWithOptionalTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// isRetryableFetchError reports whether a failed request to the Paprika API may succeed if reattempted,
+// i.e. err is a server error or rate limiting response, or a network (rather than context) error.
+func isRetryableFetchError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var apiErr *paprika.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.StatusCode >= http.StatusInternalServerError || apiErr.StatusCode == http.StatusTooManyRequests
+	}
+	var urlErr *url.Error
+	return errors.As(err, &urlErr)
+}
+
+// dedupeRecipesIndex collapses items with duplicate UIDs into a single item with the last indexed hash,
+// at the position of the first occurrence, and logs a warning if any duplicates were removed.
+func dedupeRecipesIndex(items []paprika.RecipeItem, log zerolog.Logger) []paprika.RecipeItem {
+	positions := make(map[string]int, len(items))
+	deduped := make([]paprika.RecipeItem, 0, len(items))
+	for _, item := range items {
+		if i, ok := positions[item.UID]; ok {
+			deduped[i].Hash = item.Hash
+			continue
+		}
+		positions[item.UID] = len(deduped)
+		deduped = append(deduped, item)
+	}
+	if removed := len(items) - len(deduped); removed > 0 {
+		log.Warn().Int("duplicate-recipes-count", removed).
+			Msg("removed duplicate recipe UIDs from Paprika recipes index")
+	}
+	return deduped
+}
+
+// recipeUIDFileItems returns the items to sync for the recipes listed in the recipe UID file.
+// Items have no hash, so that each recipe is fetched, unless the recipes index is intersected with the file,
+// in which case the index is saved and the indexed items for listed recipes are returned.
+func (cmd *SyncCMD) recipeUIDFileItems(ctx context.Context, cli *CLI, c RecipeFetcher, log zerolog.Logger) ([]paprika.RecipeItem, error) {
+	log = log.With().Str("recipe-uid-file", cmd.RecipeUIDFile).Logger()
+	uids, err := readRecipeUIDFile(cmd.RecipeUIDFile)
+	if err != nil {
+		log.Err(err).Msg("failed to read recipe UID file")
+		return nil, err
+	}
+	if !cmd.IntersectIndex {
+		items := make([]paprika.RecipeItem, len(uids))
+		for i, uid := range uids {
+			items[i] = paprika.RecipeItem{UID: uid}
+		}
+		log.Debug().Int("listed-recipes-count", len(items)).Msg("syncing recipes listed in recipe UID file")
+		return items, nil
+	}
+
+	index, err := cmd.SaveRecipesIndex(ctx, cli, c, log)
+	if err != nil {
+		log.Err(err).Msg("failed to update Paprika recipes index")
+		return nil, err
+	}
+	listed := make(map[string]bool, len(uids))
+	for _, uid := range uids {
+		listed[uid] = true
+	}
+	var items []paprika.RecipeItem
+	for _, item := range index {
+		if listed[item.UID] {
+			items = append(items, item)
+			delete(listed, item.UID)
+		}
+	}
+	if len(listed) > 0 {
+		log.Warn().Strs("recipe-uids", slices.Sorted(maps.Keys(listed))).
+			Msg("ignoring listed recipes that are not in the Paprika recipes index")
+	}
+	log.Debug().Int("listed-recipes-count", len(uids)).
+		Int("indexed-listed-recipes-count", len(items)).
+		Msg("syncing indexed recipes listed in recipe UID file")
+	return items, nil
+}
+
+// readRecipeUIDFile reads the newline-delimited recipe UIDs in the file at path.
+// Surrounding whitespace, blank lines, lines beginning with "#", and repeated UIDs are ignored.
+func readRecipeUIDFile(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var uids []string
+	seen := make(map[string]bool)
+	for line := range strings.Lines(string(data)) {
+		uid := strings.TrimSpace(line)
+		if uid == "" || strings.HasPrefix(uid, "#") || seen[uid] {
+			continue
+		}
+		seen[uid] = true
+		uids = append(uids, uid)
+	}
+	return uids, nil
+}
+
+// streamRecipesIndex fetches the recipes index, passing each item to queue as soon as it is decoded,
+// and then saves the complete index. Items with UIDs that were already queued are not queued again.
+// If queue returns false, it stops without saving the index.
+func (cmd *SyncCMD) streamRecipesIndex(ctx context.Context, cli *CLI, c RecipeFetcher, queue func(paprika.RecipeItem) bool, log zerolog.Logger) ([]paprika.RecipeItem, error) {
+	var recipesIndex []paprika.RecipeItem
+	queued := make(map[string]bool)
+	fetchCtx, cancel := contextWithOptionalTimeout(ctx, cmd.IndexTimeout)
+	defer cancel()
+	for item, err := range c.RecipesSeq(fetchCtx) {
+		if err != nil {
+			logAPIErrorBody(log, err)
+			log.Err(err).Int("indexed-recipes-count", len(recipesIndex)).
+				Msg("failed to fetch Paprika recipes index")
+			return recipesIndex, err
+		}
+		recipesIndex = append(recipesIndex, item)
+		if queued[item.UID] {
+			continue
+		}
+		queued[item.UID] = true
+		if !queue(item) {
+			return recipesIndex, ctx.Err()
+		}
+	}
+	log.Debug().Int("indexed-recipes-count", len(recipesIndex)).
+		Msg("fetched Paprika recipes index")
+	recipesIndex = dedupeRecipesIndex(recipesIndex, log)
+	return recipesIndex, cmd.writeRecipesIndex(ctx, cli, recipesIndex, log)
+}
 
+// writeRecipesIndex saves the fetched recipes index to the recipes index file.
+func (cmd *SyncCMD) writeRecipesIndex(ctx context.Context, cli *CLI, recipesIndex []paprika.RecipeItem, log zerolog.Logger) error {
 	if err := ctx.Err(); err != nil {
-		return recipesIndex, err
+		return err
 	}
-	path := pathToRecipesIndexFile(cli.DataDir)
+	path := cli.recipesIndexFile()
 	log = log.With().Str("path", path).Logger()
-	err = saveAsJSON(recipesIndex, path)
+	if cmd.DryRun {
+		log.Info().Int("indexed-recipes-count", len(recipesIndex)).Msg("would save Paprika recipes index file")
+		return nil
+	}
+	err := saveRecipesIndexFile(ctx, recipesIndex, path, cli.TempDir, cli.IndexFormat, cli.JSONTrailingNewline)
 	if err != nil {
 		log.Err(err).Msg("failed to create Paprika recipes index file")
 	} else {
 		log.Info().Msg("saved Paprika recipes index file")
 	}
-	return recipesIndex, err
+	return err
+}
+
+// UpsertRecipe fetches and saves the recipe referenced by ref if the local copy is missing or outdated.
+// It reports whether the recipe file was saved.
+func (cmd *SyncCMD) UpsertRecipe(ctx context.Context, cli *CLI, c RecipeFetcher, ref paprika.RecipeItem, log zerolog.Logger) (bool, error) {
+	saved, err := cmd.upsertRecipe(ctx, cli, c, ref, log)
+	return saved != nil, err
 }
 
-func (cmd *SyncCMD) UpsertRecipe(ctx context.Context, cli *CLI, c *paprika.Client, ref paprika.RecipeItem, log zerolog.Logger) (bool, error) {
-	recipePath := pathToRecipeJSONFile(cli.DataDir, ref.UID)
+// upsertRecipe implements UpsertRecipe. When the recipe file is saved, it returns an item
+// identifying the UID and hash of the recipe as written.
+func (cmd *SyncCMD) upsertRecipe(ctx context.Context, cli *CLI, c RecipeFetcher, ref paprika.RecipeItem, log zerolog.Logger) (*paprika.RecipeItem, error) {
+	if err := validateUID(ref.UID, cmd.StrictUIDValidation); err != nil {
+		log.Err(err).Msg("rejecting recipe item with invalid UID")
+		return nil, err
+	}
+	if cli.ContentAddressed && ref.Hash != "" {
+		if err := validateRecipeHash(ref.Hash); err != nil {
+			log.Err(err).Msg("rejecting recipe item with invalid hash")
+			return nil, err
+		}
+	}
+	recipePath := cli.recipeFile(cli.DataDir, ref.UID, ref.Hash)
 	log = log.With().Str("recipe-file", recipePath).Logger()
 
 	// Determine if recipe file should be created/updated/skipped
 	var recipeFileAction string
-	if doUpdate, exists := shouldSaveRecipe(recipePath, ref.Hash, log); !doUpdate {
+	doUpdate, exists, extantHash := cmd.shouldSaveRecipeCached(ref.UID, recipePath, ref.Hash, log)
+	if !doUpdate {
 		log.Debug().Msg("local recipe exists and does not require update")
-		return false, nil
+		return nil, nil
+	} else if exists && cmd.NoClobber {
+		log.Info().Msg("not updating outdated local recipe in no-clobber mode")
+		return nil, nil
 	} else if exists {
 		log.Debug().Msg("local recipe exists and requires update")
 		recipeFileAction = "update"
@@ -241,308 +961,506 @@ func (cmd *SyncCMD) UpsertRecipe(ctx context.Context, cli *CLI, c *paprika.Clien
 	log = log.With().Str("recipe-file-action", recipeFileAction).Logger()
 
 	log.Debug().Msg("fetching recipe from API")
-	recipe, err := c.Recipe(ctx, ref.UID)
+	// The recipe is saved exactly as provided by the API, so that fields unknown to paprika.Recipe
+	// and the precision of numeric values are preserved.
+	fetchCtx, cancel := contextWithOptionalTimeout(ctx, cmd.RecipeTimeout)
+	rawRecipe, err := c.RecipeRaw(fetchCtx, ref.UID)
+	cancel()
 	if err != nil {
+		logAPIErrorBody(log, err)
 		log.Err(err).Msg("failed to retrieve recipe from API")
-		return false, err
+		return nil, err
+	}
+	var recipe paprika.Recipe
+	if err := json.Unmarshal(rawRecipe, &recipe); err != nil {
+		log.Err(err).Msg("failed to decode recipe retrieved from API")
+		return nil, err
 	}
 
-	if recipe.Hash != ref.Hash {
+	if ref.Hash != "" && recipe.Hash != ref.Hash {
 		// recipe may have been updated since retrieving the reference hash,
 		// or the fetched recipe is stale if it matches the has on disk
 		log = log.With().Str("recipe-fetched-hash", recipe.Hash).Logger()
+		if err := cmd.promoteWarning(log, fmt.Errorf("fetched recipe hash %q does not match reference hash %q", recipe.Hash, ref.Hash)); err != nil {
+			return nil, err
+		}
 		log.Warn().Msg("fetched recipe hash does not match reference hatch")
 	}
 	if recipe.UID != ref.UID {
 		// this would be a major API issue
 		err := fmt.Errorf("fetched recipe UID %q does not match requested UID %q", recipe.UID, ref.UID)
 		log.Err(err).Str("recipe-fetched-uid", recipe.Hash).Msg("rejecting fetched recipe")
-		return false, err
+		return nil, err
+	}
+	if cmd.emptyRecipesCount != nil {
+		if reason := emptyRecipeReason(recipe); reason != "" {
+			cmd.emptyRecipesCount.Add(1)
+			log.Warn().Str("recipe-name", recipe.Name).
+				Str("empty-reason", reason).
+				Msg("fetched recipe is empty")
+		}
+	}
+	if cli.ContentAddressed {
+		// The recipe file is named by the hash of the recipe as fetched, which may differ from the reference hash
+		if err := validateRecipeHash(recipe.Hash); err != nil {
+			log.Err(err).Msg("rejecting fetched recipe")
+			return nil, err
+		}
+		if path := pathToRecipeHashFile(cli.DataDir, recipe.UID, recipe.Hash); path != recipePath {
+			recipePath = path
+			log = log.With().Str("recipe-file", recipePath).Logger()
+		}
 	}
 
-	if err := saveAsJSON(recipe, recipePath); err != nil {
+	if cmd.ModifiedSince != nil {
+		created, err := recipe.CreatedTime(time.Local)
+		if err != nil {
+			log := log.With().Str("recipe-created", recipe.Created).Logger()
+			if err := cmd.promoteWarning(log, fmt.Errorf("unknown recipe creation time for modified-since filter: %w", err)); err != nil {
+				return nil, err
+			}
+			log.Warn().Err(err).
+				Msg("saving recipe with unknown creation time regardless of modified-since filter")
+		} else if created.Before(time.Time(*cmd.ModifiedSince)) {
+			log.Debug().Time("recipe-created", created).
+				Msg("skipping recipe created before modified-since filter")
+			return nil, nil
+		}
+	}
+
+	if cmd.DryRun {
+		logWouldSaveRecipe(recipePath, exists, rawRecipe, log)
+		return nil, nil
+	}
+
+	if exists && cmd.KeepVersions > 0 {
+		if extantHash == "" {
+			if err := cmd.promoteWarning(log, errors.New("cannot retain prior version of unreadable recipe file")); err != nil {
+				return nil, err
+			}
+			log.Warn().Msg("not retaining prior version of unreadable recipe file")
+		} else if err := retainRecipeVersion(cli.DataDir, ref.UID, extantHash, int(cmd.KeepVersions)); err != nil {
+			log.Err(err).Str("recipe-extant-hash", extantHash).
+				Msg("failed to retain prior version of recipe file")
+			return nil, err
+		}
+	}
+
+	if err := cmd.writeRecipeFile(ctx, cli, rawRecipe, recipePath); err != nil {
 		log.Err(err).Msg("failed to save recipe file")
-		return false, err
+		return nil, err
+	}
+	savedLog := log.Sample(cmd.savedRecipeSampler)
+	savedLog.Info().Msg("saved recipe file")
+	cmd.recipeStates.record(ref.UID, recipePath, recipe.Hash)
+	if cli.MirrorDir != "" {
+		cmd.mirrorRecipeFile(ctx, cli, rawRecipe, cli.recipeFile(cli.MirrorDir, recipe.UID, recipe.Hash), log)
+	}
+
+	if cmd.categoryNames != nil {
+		categories := resolveRecipeCategories(recipe, cmd.categoryNames, log)
+		if err := cmd.writeRecipeFile(ctx, cli, categories, pathToRecipeCategoriesFile(cli.DataDir, recipe.UID)); err != nil {
+			log.Err(err).Msg("failed to save resolved recipe categories file")
+			return nil, err
+		}
+		if cli.MirrorDir != "" {
+			cmd.mirrorRecipeFile(ctx, cli, categories, pathToRecipeCategoriesFile(cli.MirrorDir, recipe.UID), log)
+		}
+	}
+
+	if cmd.Journal {
+		entry := journalEntry{
+			Timestamp: cmd.now(),
+			Action:    recipeFileAction,
+			UID:       recipe.UID,
+			OldHash:   extantHash,
+			NewHash:   recipe.Hash,
+		}
+		if err := appendJournalEntry(pathToJournalFile(cli.DataDir), entry); err != nil {
+			log.Err(err).Msg("failed to append recipe change to journal")
+			return nil, err
+		}
+	}
+	return &paprika.RecipeItem{UID: recipe.UID, Hash: recipe.Hash}, nil
+}
+
+// mirrorRecipeFile saves val (e.g. the recipe as fetched) to the file at path in the mirror directory (see --mirror-dir).
+// Failure to save the mirrored recipe file is logged as a warning, since the mirror is secondary to the data directory.
+func (cmd *SyncCMD) mirrorRecipeFile(ctx context.Context, cli *CLI, val any, path string, log zerolog.Logger) {
+	log = log.With().Str("mirror-recipe-file", path).Logger()
+	if err := cmd.writeRecipeFile(ctx, cli, val, path); err != nil {
+		log.Warn().Err(err).Msg("failed to save recipe file to mirror directory")
+		return
+	}
+	log.Debug().Msg("saved recipe file to mirror directory")
+}
+
+// emptyRecipeReason describes why recipe is considered empty (e.g. a placeholder),
+// or returns an empty string if it is not: recipes are empty if they have no name,
+// or neither ingredients nor directions.
+func emptyRecipeReason(recipe paprika.Recipe) string {
+	switch {
+	case strings.TrimSpace(recipe.Name) == "":
+		return "missing name"
+	case strings.TrimSpace(recipe.Ingredients) == "" && strings.TrimSpace(recipe.Directions) == "":
+		return "missing ingredients and directions"
 	}
-	log.Info().Msg("saved recipe file")
-	return true, nil
+	return ""
 }
 
-func shouldSaveRecipe(path, hash string, log zerolog.Logger) (update bool, exists bool) {
+// promoteWarning returns err, which describes a warning condition, when warnings are promoted to errors
+// (see --fail-on-warning), after logging that the recipe is rejected because of it. Otherwise, it returns nil
+// and the caller should log the warning and proceed.
+func (cmd *SyncCMD) promoteWarning(log zerolog.Logger, err error) error {
+	if !cmd.FailOnWarning {
+		return nil
+	}
+	log.Err(err).Msg("rejecting recipe because of warning (see --fail-on-warning)")
+	return err
+}
+
+// upsertRecipeWithRetry calls upsertRecipe for ref, reattempting the whole operation after a short delay
+// when it fails, until it succeeds or the configured maximum number of attempts is reached.
+func (cmd *SyncCMD) upsertRecipeWithRetry(ctx context.Context, cli *CLI, c RecipeFetcher, ref paprika.RecipeItem, log zerolog.Logger) (*paprika.RecipeItem, error) {
+	maxAttempts := max(int(cmd.RecipeMaxAttempts), 1)
+	if maxAttempts == 1 {
+		return cmd.upsertRecipe(ctx, cli, c, ref, log)
+	}
+	for attempt := 1; ; attempt++ {
+		log := log.With().Int("attempt", attempt).Int("max-attempts", maxAttempts).Logger()
+		saved, err := cmd.upsertRecipe(ctx, cli, c, ref, log)
+		if err == nil || attempt >= maxAttempts || ctx.Err() != nil {
+			return saved, err
+		}
+		log.Warn().Err(err).Dur("retry-delay", recipeRetryDelay).
+			Msg("retrying failed worker task for recipe item")
+		timer := time.NewTimer(recipeRetryDelay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return saved, err
+		case <-timer.C:
+		}
+	}
+}
+
+// resolveRecipeCategories returns the names of recipe's categories, keyed by category UID, to be saved alongside
+// the recipe file. Category UIDs not found in categoryNames are omitted.
+func resolveRecipeCategories(recipe paprika.Recipe, categoryNames map[string]string, log zerolog.Logger) map[string]string {
+	resolved := make(map[string]string, len(recipe.Categories))
+	for _, uid := range recipe.Categories {
+		name, ok := categoryNames[uid]
+		if !ok {
+			log.Warn().Str("category-uid", uid).Msg("recipe category not found in categories index")
+			continue
+		}
+		resolved[uid] = name
+	}
+	return resolved
+}
+
+// logWouldSaveRecipe logs that the recipe file at path would be saved with the fetched raw recipe JSON.
+// For existing recipe files, the top-level fields that would change are included.
+func logWouldSaveRecipe(path string, exists bool, rawRecipe []byte, log zerolog.Logger) {
+	if !exists {
+		log.Info().Msg("would save new recipe file")
+		return
+	}
+	extant, err := os.ReadFile(path)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to read extant recipe file for comparison")
+		log.Info().Msg("would update recipe file")
+		return
+	}
+	changed, err := changedJSONFields(extant, rawRecipe)
+	if err != nil {
+		log.Warn().Err(err).Msg("failed to compare extant recipe file with fetched recipe")
+		log.Info().Msg("would update recipe file")
+		return
+	}
+	log.Info().Strs("changed-fields", changed).Msg("would update recipe file")
+}
+
+// missingRecipeFiles returns the UIDs of indexed recipe items that have no local recipe file.
+func missingRecipeFiles(cli *CLI, items []paprika.RecipeItem) []string {
+	var missing []string
+	for _, item := range items {
+		if validateUID(item.UID, false) == nil {
+			if _, err := os.Stat(cli.recipeFile(cli.DataDir, item.UID, item.Hash)); err == nil {
+				continue
+			}
+		}
+		missing = append(missing, item.UID)
+	}
+	return missing
+}
+
+// shouldSaveRecipeCached is like shouldSaveRecipe, but uses the recipe file state recorded by the previous sync
+// (if available and still current) instead of reading the recipe file at path, which belongs to uid.
+func (cmd *SyncCMD) shouldSaveRecipeCached(uid, path, hash string, log zerolog.Logger) (update bool, exists bool, extantHash string) {
+	if st, ok := cmd.recipeStates.lookup(uid, path); ok {
+		if st.Hash == hash {
+			log.Debug().Msg("cached recipe file state matches latest recipe hash")
+			cmd.recipeStates.keep(uid, st)
+			return false, true, st.Hash
+		}
+		log.Debug().Str("recipe-extant-hash", st.Hash).
+			Msg("cached recipe file state does not match latest recipe hash")
+		return true, true, st.Hash
+	}
+
+	update, exists, extantHash = shouldSaveRecipe(path, hash, log)
+	if !update {
+		cmd.recipeStates.record(uid, path, extantHash)
+	}
+	return update, exists, extantHash
+}
+
+// shouldSaveRecipe determines whether the recipe file at path should be saved, given the latest hash for the recipe.
+// It also reports whether the recipe file exists, and the hash of the extant recipe file (if known).
+func shouldSaveRecipe(path, hash string, log zerolog.Logger) (update bool, exists bool, extantHash string) {
 	f, err := os.Open(path)
 	if err != nil {
 		log.Debug().Msg("no extant recipe file")
-		return true, false
+		return true, false, ""
 	}
 	defer f.Close()
 
 	var extantItem paprika.RecipeItem
 	if err := json.NewDecoder(f).Decode(&extantItem); err != nil {
 		log.Err(err).Msg("failed decoding extant recipe file JSON")
-		return true, true
+		return true, true, ""
 	}
 	if extantItem.Hash == hash {
 		log.Debug().Msg("extant recipe file matches latest recipe hash")
-		return false, true
+		return false, true, extantItem.Hash
 	}
 
 	log.Debug().Str("recipe-extant-hash", extantItem.Hash).
 		Msg("extant recipe file does not match latest recipe hash")
-	return true, true
+	return true, true, extantItem.Hash
 }
 
+// saveAsJSON atomically writes val as JSON, followed by a newline, to the file at path.
+// The data is first written to a temporary file in the same directory, which is then renamed to path.
+// Replacing (rather than truncating) existing files ensures that hard links to previous
+// versions of the file (e.g. in data directory generations) are left intact.
 func saveAsJSON(val any, path string) error {
-	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
-		return err
-	}
-	f, err := os.Create(path)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	if err := json.NewEncoder(f).Encode(val); err != nil {
-		return err
-	}
-	return nil
+	return writeJSONFile(context.Background(), val, path, "", true)
 }
 
-// purgeUnreferencedRecipes loads the recipes index and removes on-disk data for recipes not present in the index
-// (indicating that the recipe has been deleted from Paprika) according to a configured grace period.
-// This ensures safe, delayed cleanup of deleted recipes while preventing accidental data loss from temporary index
-// inconsistencies and allowing for manual recovery of recipe data that was mistakenly deleted from Paprika.
-//
-// For recipes that are present in the index, any existing deletion marker file is considered stale and is removed.
-//
-// For recipes that are not present in the index, the function uses a timestamp-based deletion marker
-// to allow for delayed purging according to the following rules:
-//
-//   - If purgeAfter <= 0, unindexed recipes are deleted immediately without using a marker.
-//   - If a deletion marker exists, its timestamp indicates when the recipe was first observed as unindexed.
-//     The recipe data is deleted if this timestamp is older than now minus purgeAfter.
-//   - If no deletion marker exists, one is created with the current timestamp,
-//     which preserves the recipe data until a subsequent run.
-//
-// The function respects context cancellation and aborts early if the context is canceled.
-// If any filesystem or decoding error is encountered, further cleanup is aborted and the error is returned.
-func purgeUnreferencedRecipes(ctx context.Context, dataDir string, now time.Time, purgeAfter time.Duration, log zerolog.Logger) error {
-	cutoff := now.Add(-purgeAfter)
-	log = log.With().
-		Time("purge-cutoff", cutoff).
-		Time("check-timestamp", now).
-		Logger()
-	nowStamp := now.Format(time.RFC3339Nano)
-
-	var index []paprika.RecipeItem
-	indexFile, err := os.Open(pathToRecipesIndexFile(dataDir))
+// writeJSONFile is like saveAsJSON, but stages the file in tempDir if set (see writeFileAtomic),
+// only ends the file with a newline if trailingNewline is set, and aborts the write if ctx is canceled
+// (see writeFileAtomicContext).
+func writeJSONFile(ctx context.Context, val any, path, tempDir string, trailingNewline bool) error {
+	data, err := json.Marshal(val)
 	if err != nil {
 		return err
 	}
-	defer indexFile.Close()
-	if err := json.NewDecoder(indexFile).Decode(&index); err != nil {
-		return err
-	}
-	indexedUIDs := make(map[string]struct{}, len(index))
-	for _, item := range index {
-		indexedUIDs[item.UID] = struct{}{}
+	if trailingNewline {
+		data = append(data, '\n')
 	}
+	return writeFileAtomicContext(ctx, path, tempDir, func(f *os.File) error {
+		_, err := f.Write(data)
+		return err
+	})
+}
 
-	recipesDataRoot := pathToRecipesDir(dataDir)
-	return filepath.WalkDir(recipesDataRoot, func(path string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if ctx.Err() != nil {
-			return err
-		}
-
-		// Skip all that is not a recipe or deletion marker file
-		if d.IsDir() {
-			return nil
-		}
-		currentFileName := d.Name()
-		if currentFileName != filenameRecipeJSON && currentFileName != filenameRecipeDeleteMarker {
-			return nil
-		}
-
-		dir := filepath.Dir(path)
-		uid := filepath.Base(dir)
-		log := log.With().
-			Str("recipe-directory", dir).
-			Str("recipe-uid", uid).
-			Str("filename", currentFileName).
-			Logger()
-
-		// Check if recipe is present in index
-		if _, exists := indexedUIDs[uid]; exists {
-			if currentFileName == filenameRecipeDeleteMarker {
-				if err := os.Remove(path); err != nil {
-					log.Err(err).Msg("failed to delete stale deletion marker file for indexed recipe")
+// saveRecipesIndexFile saves items to the file at path in the given format (see --index-format),
+// staging the file in tempDir if set (see writeFileAtomic).
+// Items are encoded one at a time to a buffered writer, so that the encoded form of
+// a very large index is never held in memory in its entirety.
+// In the JSON format, the resulting file is identical to one written by writeJSONFile.
+// In the NDJSON format, every line (including the last) ends with a newline, regardless of trailingNewline.
+func saveRecipesIndexFile(ctx context.Context, items []paprika.RecipeItem, path, tempDir, format string, trailingNewline bool) error {
+	return writeFileAtomicContext(ctx, path, tempDir, func(f *os.File) error {
+		w := bufio.NewWriter(f)
+		if format == indexFormatNDJSON {
+			for _, item := range items {
+				data, err := json.Marshal(item)
+				if err != nil {
 					return err
 				}
-				log.Debug().Msg("deleted stale deletion marker file for indexed recipe")
-				// No need to continue inspecting this directory's contents
-				return filepath.SkipDir
+				w.Write(data)
+				w.WriteByte('\n')
 			}
-			return nil
-		}
-
-		// Directory pertains to an unindexed recipe, likely because it was deleted from Paprika.
-		// Do one of the following:
-		// - Purge now if immediate purge is requested or a timestamp marker exists and is expired.
-		// - If no timestamp marker exists, create one.
-		// - If a timestamp marker already exists but has not expired, do nothing.
-		doPurge := false
-		if purgeAfter <= 0 {
-			// Skip checking for timestamp marker and purge immediately
-			doPurge = true
-			log = log.With().Str("purge-reason", "immediate purge requested").Logger()
-		} else if currentFileName == filenameRecipeDeleteMarker {
-			// Note: Recipe has not been seen in index since marker was set.
-			marker, err := readTimestampMarker(path, time.RFC3339Nano)
-			if err != nil {
-				log.Err(err).Msg("failed to read timestamp marker file")
-				return err
-			}
-			log = log.With().Time("recipe-unindexed-since", marker).Logger()
-			if marker.After(cutoff) {
-				log.Debug().Msg("ignoring unindexed local recipe data because marker is more recent than cutoff")
-				return filepath.SkipDir
-			}
-			doPurge = true
-			log = log.With().Str("purge-reason", "recipe not seen in index since cutoff").Logger()
+			return w.Flush()
 		}
-
-		if doPurge {
-			if err = os.RemoveAll(dir); err != nil {
-				log.Err(err).Msg("failed to delete local data directory for unindexed recipe")
+		w.WriteByte('[')
+		for i, item := range items {
+			if i > 0 {
+				w.WriteByte(',')
 			}
-			log.Info().Msg("deleted local data for unindexed recipe")
-			return filepath.SkipDir
-		}
-
-		if currentFileName == filenameRecipeJSON {
-			// Create marker file if one does not already exist
-			f, err := os.OpenFile(pathToRecipeDeleteMarkerFile(dataDir, uid),
-				os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0666)
+			data, err := json.Marshal(item)
 			if err != nil {
-				if os.IsExist(err) {
-					// Marker already exists
-					return nil
-				}
-				log.Err(err).Msg("failed to create deletion marker file for unindexed recipe")
-				return err
-			}
-			defer f.Close()
-			if _, err := f.WriteString(nowStamp); err != nil {
-				log.Err(err).Msg("failed to write deletion marker file for unindexed recipe")
 				return err
 			}
-			log.Info().Msg("wrote new deletion marker file for unindexed recipe")
-			return filepath.SkipDir
+			w.Write(data)
+		}
+		w.WriteByte(']')
+		if trailingNewline {
+			w.WriteByte('\n')
 		}
+		return w.Flush()
+	})
+}
 
-		return nil
+// writeFileAtomicContext is like writeFileAtomic, but aborts the write if ctx is canceled before write returns:
+// the staged file is closed, so that write fails at its next attempt to write to it, and ctx.Err() is returned
+// once the temporary file has been removed, leaving path unchanged.
+func writeFileAtomicContext(ctx context.Context, path, tempDir string, write func(*os.File) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, tempDir, func(f *os.File) error {
+		stop := context.AfterFunc(ctx, func() { f.Close() })
+		err := write(f)
+		if !stop() {
+			// f was closed due to cancellation, so its contents may be incomplete regardless of err
+			return ctx.Err()
+		}
+		return err
 	})
 }
 
-// readTimestampMarker reads the file at path and returns the decoded timestamp marker.
-func readTimestampMarker(path, layout string) (t time.Time, err error) {
-	f, err := os.Open(path)
+// writeFileAtomic creates or replaces the file at path with contents written by write.
+// Contents are written to a temporary file in the staging directory, which is tempDir if set (see --temp-dir)
+// or otherwise the directory of path, and which is renamed to path only if write succeeds,
+// so that path is never left partially written.
+// When the staging directory is on a different filesystem than path, the staged file is first copied
+// to a temporary file in the same directory as path, so that path is still replaced atomically.
+func writeFileAtomic(path, tempDir string, write func(*os.File) error) error {
+	tmpPath, err := stageFile(path, tempDir, write)
+	if tmpPath != "" {
+		defer os.Remove(tmpPath)
+	}
 	if err != nil {
-		return
+		return err
 	}
-	defer f.Close()
+	return commitFile(tmpPath, path, os.Rename)
+}
 
-	buf := make([]byte, len(layout))
-	n, err := f.Read(buf)
+// stageFile writes contents (using write) to a new temporary file in tempDir (or the directory of path
+// if tempDir is empty), for replacing the file at path (see writeFileAtomic). The path of the temporary file is returned even if an error is returned,
+// unless the file could not be created, and the caller is responsible for removing it.
+func stageFile(path, tempDir string, write func(*os.File) error) (string, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", err
+	}
+	f, err := createTempFile(cmp.Or(tempDir, dir), path)
 	if err != nil {
-		return
+		return "", err
 	}
+	if err := write(f); err != nil {
+		f.Close()
+		return f.Name(), err
+	}
+	return f.Name(), f.Close()
+}
 
-	return time.Parse(layout, string(buf[:n]))
+// commitFile replaces the file at path with the staged file at tmpPath (see stageFile), using rename (i.e. os.Rename).
+// If rename fails because tmpPath is on a different filesystem than path, the staged file is copied instead.
+func commitFile(tmpPath, path string, rename func(oldpath, newpath string) error) error {
+	if err := rename(tmpPath, path); !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	return copyFileAtomic(tmpPath, path)
 }
 
-// PruneFilelessSubtrees removes subdirectories under the given root directory tree
-// that themselves consist of only directories, recursively.
-// root itself is never removed.
-// Calls to os.RemoveAll() are optimized to occur at the top-most possible level,
-// in order to minimize filesystem writes.
-func PruneFilelessSubtrees(ctx context.Context, root string) error {
-	// Recursive directory traverse-and-prune function
-	var pruneDir func(dir string) (fileless bool, err error)
-	pruneDir = func(dir string) (bool, error) {
-		if err := ctx.Err(); err != nil {
-			return false, err
+// createTempFile creates a new temporary file in dir, in which to stage a replacement for the file at path.
+// Unlike files created by os.CreateTemp, which only their owner may access, the temporary file has the permissions
+// of the file at path if it exists, or those of a new file created by os.Create (i.e. 0666 less the umask) otherwise,
+// so that replacing a file does not change its permissions.
+func createTempFile(dir, path string) (*os.File, error) {
+	info, statErr := os.Stat(path)
+	prefix := "." + filepath.Base(path) + "."
+	for range 10000 {
+		name := filepath.Join(dir, prefix+strconv.FormatUint(uint64(rand.Uint32()), 10)+".tmp")
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if errors.Is(err, fs.ErrExist) {
+			continue
 		}
-		entries, err := os.ReadDir(dir)
 		if err != nil {
-			return false, fmt.Errorf("read dir %q: %w", dir, err)
+			return nil, err
 		}
-
-		hasOnlyDirs := true
-		var emptyChildren []string
-		for _, e := range entries {
-			if err := ctx.Err(); err != nil {
-				return false, err
-			}
-			if !e.IsDir() {
-				hasOnlyDirs = false
-				continue
-			}
-
-			childPath := filepath.Join(dir, e.Name())
-
-			childHasOnlyDirs, err := pruneDir(childPath)
-			if err != nil {
-				return false, err
-			}
-
-			if childHasOnlyDirs {
-				emptyChildren = append(emptyChildren, childPath)
-			} else {
-				hasOnlyDirs = false
-			}
-		}
-
-		if !hasOnlyDirs {
-			// This dir cannot be entirely removed, so remove any fileless children now.
-			for _, p := range emptyChildren {
-				if err := ctx.Err(); err != nil {
-					return false, err
-				}
-				if err := os.RemoveAll(p); err != nil {
-					return false, fmt.Errorf("remove %q: %w", p, err)
-				}
+		// The umask applies when the file is created, so existing permissions are restored explicitly.
+		if statErr == nil {
+			if err := f.Chmod(info.Mode().Perm()); err != nil {
+				f.Close()
+				os.Remove(name)
+				return nil, err
 			}
 		}
+		return f, nil
+	}
+	return nil, &fs.PathError{Op: "createtemp", Path: filepath.Join(dir, prefix+"*.tmp"), Err: fs.ErrExist}
+}
 
-		return hasOnlyDirs, nil
+// copyFileAtomic creates or replaces the file at path with a copy of the file at src, by way of
+// a temporary file in the same directory as path. It is used to move staged files across filesystems,
+// between which files cannot be renamed.
+func copyFileAtomic(src, path string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
 	}
+	defer in.Close()
 
-	entries, err := os.ReadDir(root)
+	f, err := createTempFile(filepath.Dir(path), path)
 	if err != nil {
-		return fmt.Errorf("read root %q: %w", root, err)
+		return err
 	}
-	for _, e := range entries {
-		if !e.IsDir() {
-			continue
-		}
-		childPath := filepath.Join(root, e.Name())
+	tmpPath := f.Name()
+	defer os.Remove(tmpPath)
 
-		childHasOnlyDirs, err := pruneDir(childPath)
-		if err != nil {
-			return err
-		}
+	if _, err := io.Copy(f, in); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
 
-		if childHasOnlyDirs {
-			if err := ctx.Err(); err != nil {
-				return err
-			}
-			if err := os.RemoveAll(childPath); err != nil {
-				return fmt.Errorf("remove %q: %w", childPath, err)
-			}
-		}
+// checkAtomicWriteTempDir logs a warning if files staged in tempDir cannot be renamed into dataDir using rename
+// (i.e. os.Rename) because they are on different filesystems, in which case staged files must be copied.
+func checkAtomicWriteTempDir(tempDir, dataDir string, rename func(oldpath, newpath string) error, log zerolog.Logger) error {
+	f, err := os.CreateTemp(tempDir, ".paprika-temp-dir-check.*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create file in temp dir: %w", err)
+	}
+	probePath := f.Name()
+	defer os.Remove(probePath)
+	if err := f.Close(); err != nil {
+		return err
 	}
 
-	return nil
+	dest := filepath.Join(dataDir, filepath.Base(probePath))
+	err = rename(probePath, dest)
+	if errors.Is(err, syscall.EXDEV) {
+		log.Warn().Str("temp-dir", tempDir).
+			Msg("temp dir is on a different filesystem than the data directory; staged files will be copied into the data directory before they are renamed")
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to move file from temp dir to data directory: %w", err)
+	}
+	return os.Remove(dest)
+}
+
+// purgePolicy returns the purge policy configured for the sync command.
+func (cmd *SyncCMD) purgePolicy(cli *CLI) purgePolicy {
+	p := purgePolicy{
+		MarkOnly:         cmd.MarkOnly,
+		AllowEmptyIndex:  cmd.AllowEmptyIndexPurge,
+		MaxPurgePercent:  cmd.MaxPurgePercent,
+		Force:            cmd.ForcePurge,
+		PruneInterval:    cmd.PruneInterval,
+		ContentAddressed: cli.ContentAddressed,
+	}
+	if cmd.PurgeAfter != nil {
+		p.PurgeAfter = time.Duration(*cmd.PurgeAfter)
+	}
+	return p
 }
@@ -1,20 +1,28 @@
 package main
 
 import (
+	"bytes"
+	"cmp"
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"iter"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/TylerHendrickson/paprika"
+	"github.com/alecthomas/kong"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
@@ -24,11 +32,29 @@ func newTestLogger() zerolog.Logger {
 	return zerolog.New(io.Discard)
 }
 
-func newMockClient(t *testing.T, server *httptest.Server) *paprika.Client {
+// safeBuffer is a bytes.Buffer that is safe for concurrent use, e.g. for capturing logs from multiple goroutines.
+type safeBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *safeBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *safeBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func newMockClient(t *testing.T, server *httptest.Server, opts ...paprika.ClientOption) *paprika.Client {
 	t.Helper()
 	baseURL, err := url.Parse(server.URL + "/")
 	require.NoError(t, err)
-	client, err := paprika.NewClientWithURL("user", "pass", baseURL)
+	client, err := paprika.NewClientWithURL("user", "pass", baseURL, opts...)
 	require.NoError(t, err)
 	return client
 }
@@ -38,25 +64,6 @@ func TestNumWorkersValidate(t *testing.T) {
 	require.EqualError(t, NumWorkers(0).Validate(), "must be at least 1 worker")
 }
 
-func TestPurgeAfterUnmarshalText(t *testing.T) {
-	var p PurgeAfter
-	require.NoError(t, p.UnmarshalText([]byte("2h30m")))
-	assert.Equal(t, PurgeAfter(150*time.Minute), p)
-
-	err := p.UnmarshalText([]byte("-5m"))
-	require.EqualError(t, err, "duration cannot be negative")
-}
-
-func TestPurgeAfterString(t *testing.T) {
-	assert.Equal(t, "<never>", (*PurgeAfter)(nil).String())
-
-	var zero PurgeAfter
-	assert.Equal(t, "<immediate>", zero.String())
-
-	p := PurgeAfter(3 * time.Hour)
-	assert.Equal(t, "3h0m0s", p.String())
-}
-
 func TestSaveCategoriesIndex(t *testing.T) {
 	tempDir := t.TempDir()
 	cli := &CLI{DataDir: tempDir}
@@ -73,14 +80,68 @@ func TestSaveCategoriesIndex(t *testing.T) {
 	err := cmd.SaveCategoriesIndex(context.Background(), cli, client, newTestLogger())
 	require.NoError(t, err)
 
-	data, err := os.ReadFile(pathToCategoriesIndexFile(tempDir))
+	categories, err := LoadCategories(pathToCategoriesIndexFile(tempDir))
 	require.NoError(t, err)
-
-	var categories []paprika.Category
-	require.NoError(t, json.Unmarshal(data, &categories))
 	assert.Equal(t, []paprika.Category{{UID: "cat1", Name: "Breakfast"}}, categories)
 }
 
+func TestSaveCategoriesIndexAPIError(t *testing.T) {
+	tempDir := t.TempDir()
+	cli := &CLI{DataDir: tempDir}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "nope", http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	client := newMockClient(t, server)
+
+	var buf safeBuffer
+	cmd := SyncCMD{}
+	err := cmd.SaveCategoriesIndex(context.Background(), cli, client, zerolog.New(&buf))
+	require.Error(t, err)
+	assert.Contains(t, buf.String(), `"level":"error"`)
+	assert.Contains(t, buf.String(), "failed to get categories from Paprika API")
+	assert.NoFileExists(t, pathToCategoriesIndexFile(tempDir))
+}
+
+func TestSaveCategoriesIndexPrintCategoryTree(t *testing.T) {
+	tempDir := t.TempDir()
+	cli := &CLI{DataDir: tempDir}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"result":[
+			{"uid":"cookies","name":"Cookies","parent_uid":"desserts"},
+			{"uid":"desserts","name":"Desserts","order_flag":2},
+			{"uid":"breakfast","name":"Breakfast","order_flag":1},
+			{"uid":"choc","name":"Chocolate Chip","parent_uid":"cookies"}
+		]}`))
+	}))
+	defer server.Close()
+
+	client := newMockClient(t, server)
+
+	var buf safeBuffer
+	cmd := SyncCMD{PrintCategoryTree: true}
+	require.NoError(t, cmd.SaveCategoriesIndex(context.Background(), cli, client, zerolog.New(&buf)))
+
+	var event struct {
+		CategoryTree string `json:"category-tree"`
+	}
+	for line := range strings.Lines(buf.String()) {
+		require.NoError(t, json.Unmarshal([]byte(line), &event))
+		if event.CategoryTree != "" {
+			break
+		}
+	}
+	assert.Equal(t, "Breakfast\nDesserts\n  Cookies\n    Chocolate Chip\n", event.CategoryTree)
+
+	// Saved categories index is unaffected
+	categories, err := LoadCategories(pathToCategoriesIndexFile(tempDir))
+	require.NoError(t, err)
+	assert.Len(t, categories, 4)
+}
+
 func TestSaveRecipesIndex(t *testing.T) {
 	tempDir := t.TempDir()
 	cli := &CLI{DataDir: tempDir}
@@ -98,14 +159,45 @@ func TestSaveRecipesIndex(t *testing.T) {
 	require.NoError(t, err)
 	assert.Len(t, items, 2)
 
-	data, err := os.ReadFile(pathToRecipesIndexFile(tempDir))
+	index, err := LoadRecipesIndex(pathToRecipesIndexFile(tempDir))
 	require.NoError(t, err)
-
-	var index []paprika.RecipeItem
-	require.NoError(t, json.Unmarshal(data, &index))
 	assert.Equal(t, items, index)
 }
 
+func TestSaveRecipesIndexFile(t *testing.T) {
+	tempDir := t.TempDir()
+	for _, items := range [][]paprika.RecipeItem{
+		{},
+		{{UID: "abcde", Hash: "h1"}},
+		{{UID: "abcde", Hash: "h1"}, {UID: "fghij", Hash: "<h&2>"}},
+	} {
+		streamed, encoded := filepath.Join(tempDir, "streamed.json"), filepath.Join(tempDir, "encoded.json")
+		require.NoError(t, saveRecipesIndexFile(context.Background(), items, streamed, "", indexFormatJSON, true))
+		require.NoError(t, saveAsJSON(items, encoded))
+
+		want, err := os.ReadFile(encoded)
+		require.NoError(t, err)
+		got, err := os.ReadFile(streamed)
+		require.NoError(t, err)
+		assert.Equal(t, string(want), string(got))
+	}
+}
+
+func BenchmarkSaveRecipesIndexFile(b *testing.B) {
+	items := make([]paprika.RecipeItem, 50_000)
+	for i := range items {
+		items[i] = paprika.RecipeItem{UID: fmt.Sprintf("recipe-%08d", i), Hash: fmt.Sprintf("%064x", i)}
+	}
+	path := filepath.Join(b.TempDir(), filenameRecipesIndex)
+
+	b.ReportAllocs()
+	for b.Loop() {
+		if err := saveRecipesIndexFile(context.Background(), items, path, "", indexFormatJSON, true); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
 func TestUpsertRecipe(t *testing.T) {
 	t.Run("createNewRecipe", func(t *testing.T) {
 		tempDir := t.TempDir()
@@ -133,6 +225,28 @@ func TestUpsertRecipe(t *testing.T) {
 		assert.Equal(t, recipe, stored)
 	})
 
+	t.Run("preservesRawRecipeJSON", func(t *testing.T) {
+		tempDir := t.TempDir()
+		cli := &CLI{DataDir: tempDir}
+
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"result":{"uid":"precis","hash":"h1","rating":5,"scale":"1","unknown_quantity":0.12345678901234567890123456789,"big_count":123456789012345678901234567890}}`))
+		}))
+		defer server.Close()
+
+		client := newMockClient(t, server)
+
+		cmd := SyncCMD{}
+		saved, err := cmd.UpsertRecipe(context.Background(), cli, client, paprika.RecipeItem{UID: "precis", Hash: "h1"}, newTestLogger())
+		require.NoError(t, err)
+		assert.True(t, saved)
+
+		data, err := os.ReadFile(pathToRecipeJSONFile(tempDir, "precis"))
+		require.NoError(t, err)
+		assert.Contains(t, string(data), `"unknown_quantity":0.12345678901234567890123456789`)
+		assert.Contains(t, string(data), `"big_count":123456789012345678901234567890`)
+	})
+
 	t.Run("skipWhenHashesMatch", func(t *testing.T) {
 		tempDir := t.TempDir()
 		cli := &CLI{DataDir: tempDir}
@@ -207,28 +321,28 @@ func TestShouldSaveRecipe(t *testing.T) {
 	log := newTestLogger()
 
 	t.Run("missingFile", func(t *testing.T) {
-		update, exists := shouldSaveRecipe(path, "h1", log)
+		update, exists, _ := shouldSaveRecipe(path, "h1", log)
 		assert.True(t, update)
 		assert.False(t, exists)
 	})
 
 	t.Run("invalidJSON", func(t *testing.T) {
 		require.NoError(t, os.WriteFile(path, []byte("{not-json"), 0644))
-		update, exists := shouldSaveRecipe(path, "h2", log)
+		update, exists, _ := shouldSaveRecipe(path, "h2", log)
 		assert.True(t, update)
 		assert.True(t, exists)
 	})
 
 	t.Run("matchingHash", func(t *testing.T) {
 		require.NoError(t, saveAsJSON(paprika.Recipe{UID: "abc", Hash: "h3"}, path))
-		update, exists := shouldSaveRecipe(path, "h3", log)
+		update, exists, _ := shouldSaveRecipe(path, "h3", log)
 		assert.False(t, update)
 		assert.True(t, exists)
 	})
 
 	t.Run("differentHash", func(t *testing.T) {
 		require.NoError(t, saveAsJSON(paprika.Recipe{UID: "abc", Hash: "old"}, path))
-		update, exists := shouldSaveRecipe(path, "new", log)
+		update, exists, _ := shouldSaveRecipe(path, "new", log)
 		assert.True(t, update)
 		assert.True(t, exists)
 	})
@@ -246,124 +360,204 @@ func TestSaveAsJSON(t *testing.T) {
 	assert.Contains(t, string(data), `"k":"v"`)
 }
 
-func TestPurgeUnreferencedRecipes(t *testing.T) {
-	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
-
-	t.Run("purgesExpiredUnindexedRecipe", func(t *testing.T) {
+func TestWriteFileAtomicContext(t *testing.T) {
+	t.Run("canceledDuringWrite", func(t *testing.T) {
 		tempDir := t.TempDir()
-		require.NoError(t, saveAsJSON([]paprika.RecipeItem{{UID: "keep1", Hash: "h1"}}, pathToRecipesIndexFile(tempDir)))
-
-		uid := "old11"
-		recipeDir := pathToRecipeDir(tempDir, uid)
-		require.NoError(t, os.MkdirAll(recipeDir, 0755))
-		require.NoError(t, os.WriteFile(pathToRecipeJSONFile(tempDir, uid), []byte(`{"uid":"old11","hash":"old"}`), 0644))
-		require.NoError(t, os.WriteFile(pathToRecipeDeleteMarkerFile(tempDir, uid), []byte(now.Add(-48*time.Hour).Format(time.RFC3339Nano)), 0644))
+		targetPath := filepath.Join(tempDir, "file.json")
+		ctx, cancel := context.WithCancel(context.Background())
+
+		writing := make(chan struct{})
+		done := make(chan error)
+		go func() {
+			done <- writeFileAtomicContext(ctx, targetPath, "", func(f *os.File) error {
+				close(writing)
+				// Simulate a stuck write, which only ends once it fails
+				for {
+					if _, err := f.WriteString(`{"partial":`); err != nil {
+						return err
+					}
+					time.Sleep(time.Millisecond)
+				}
+			})
+		}()
+
+		<-writing
+		cancel()
+		select {
+		case err := <-done:
+			require.ErrorIs(t, err, context.Canceled)
+		case <-time.After(5 * time.Second):
+			t.Fatal("write did not return promptly after cancellation")
+		}
 
-		err := purgeUnreferencedRecipes(context.Background(), tempDir, now, 24*time.Hour, newTestLogger())
+		assert.NoFileExists(t, targetPath)
+		entries, err := os.ReadDir(tempDir)
 		require.NoError(t, err)
-
-		_, err = os.Stat(recipeDir)
-		require.True(t, os.IsNotExist(err))
+		assert.Empty(t, entries, "temporary file should be removed")
 	})
 
-	t.Run("createsMarkerForNewUnindexedRecipe", func(t *testing.T) {
-		tempDir := t.TempDir()
-		require.NoError(t, saveAsJSON([]paprika.RecipeItem{}, pathToRecipesIndexFile(tempDir)))
+	t.Run("canceledConcurrently", func(t *testing.T) {
+		// Whether cancellation is observed before or after the file is replaced,
+		// the result must agree with whether the file was replaced.
+		for i := range 200 {
+			tempDir := t.TempDir()
+			targetPath := filepath.Join(tempDir, "file.json")
+			ctx, cancel := context.WithCancel(context.Background())
+			err := writeFileAtomicContext(ctx, targetPath, "", func(f *os.File) error {
+				// Cancel either before the file can be replaced, or concurrently with replacing it
+				if i%2 == 0 {
+					cancel()
+				} else {
+					go cancel()
+				}
+				_, err := f.WriteString(`{"ok":true}`)
+				return err
+			})
+			entries, readErr := os.ReadDir(tempDir)
+			require.NoError(t, readErr)
+			for _, entry := range entries {
+				require.False(t, strings.HasSuffix(entry.Name(), ".tmp"), "iteration %d: temporary file should be removed", i)
+			}
+			if err != nil {
+				require.ErrorIs(t, err, context.Canceled)
+				require.NoFileExists(t, targetPath, "iteration %d: file should not be replaced when canceled", i)
+			} else {
+				require.FileExists(t, targetPath, "iteration %d: file should be replaced when successful", i)
+			}
+		}
+	})
 
-		uid := "new22"
-		recipeDir := pathToRecipeDir(tempDir, uid)
-		require.NoError(t, os.MkdirAll(recipeDir, 0755))
-		require.NoError(t, os.WriteFile(pathToRecipeJSONFile(tempDir, uid), []byte(`{"uid":"new22","hash":"h"}`), 0644))
+	t.Run("alreadyCanceled", func(t *testing.T) {
+		targetPath := filepath.Join(t.TempDir(), "file.json")
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err := writeFileAtomicContext(ctx, targetPath, "", func(f *os.File) error {
+			t.Error("write should not be called")
+			return nil
+		})
+		require.ErrorIs(t, err, context.Canceled)
+		assert.NoFileExists(t, targetPath)
+	})
+}
 
-		err := purgeUnreferencedRecipes(context.Background(), tempDir, now, time.Hour, newTestLogger())
+func TestWriteFileAtomicMode(t *testing.T) {
+	writeContents := func(f *os.File) error {
+		_, err := f.WriteString(`{"ok":true}`)
+		return err
+	}
+	modeOf := func(t *testing.T, path string) os.FileMode {
+		info, err := os.Stat(path)
 		require.NoError(t, err)
+		return info.Mode().Perm()
+	}
 
-		markerPath := pathToRecipeDeleteMarkerFile(tempDir, uid)
-		data, err := os.ReadFile(markerPath)
+	t.Run("newFile", func(t *testing.T) {
+		tempDir := t.TempDir()
+		// Files created by os.Create have mode 0666 less the umask
+		created, err := os.Create(filepath.Join(tempDir, "created.json"))
 		require.NoError(t, err)
+		require.NoError(t, created.Close())
 
-		markerTime, err := time.Parse(time.RFC3339Nano, string(data))
-		require.NoError(t, err)
-		assert.Equal(t, now, markerTime)
+		path := filepath.Join(tempDir, "file.json")
+		require.NoError(t, writeFileAtomic(path, "", writeContents))
+		assert.Equal(t, modeOf(t, created.Name()), modeOf(t, path))
+		assert.NotEqual(t, os.FileMode(0600), modeOf(t, path), "new files should not be owner-only")
 	})
 
-	t.Run("retainsUnexpiredMarker", func(t *testing.T) {
-		tempDir := t.TempDir()
-		require.NoError(t, saveAsJSON([]paprika.RecipeItem{}, pathToRecipesIndexFile(tempDir)))
-
-		uid := "recent3"
-		recipeDir := pathToRecipeDir(tempDir, uid)
-		require.NoError(t, os.MkdirAll(recipeDir, 0755))
-		marker := now.Add(-10 * time.Minute).Format(time.RFC3339Nano)
-		require.NoError(t, os.WriteFile(pathToRecipeDeleteMarkerFile(tempDir, uid), []byte(marker), 0644))
-
-		err := purgeUnreferencedRecipes(context.Background(), tempDir, now, time.Hour, newTestLogger())
-		require.NoError(t, err)
+	t.Run("existingFile", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "file.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"ok":false}`), 0644))
+		require.NoError(t, os.Chmod(path, 0640))
+		require.NoError(t, writeFileAtomic(path, "", writeContents))
+		assert.Equal(t, os.FileMode(0640), modeOf(t, path))
+	})
 
-		_, err = os.Stat(recipeDir)
-		require.NoError(t, err)
+	t.Run("copiedAcrossFilesystems", func(t *testing.T) {
+		src := filepath.Join(t.TempDir(), "staged.json")
+		require.NoError(t, os.WriteFile(src, []byte(`{"ok":true}`), 0600))
+		path := filepath.Join(t.TempDir(), "file.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"ok":false}`), 0644))
+		require.NoError(t, os.Chmod(path, 0664))
+		require.NoError(t, copyFileAtomic(src, path))
+		assert.Equal(t, os.FileMode(0664), modeOf(t, path))
 	})
+}
 
-	t.Run("removesStaleMarkerForIndexedRecipe", func(t *testing.T) {
-		tempDir := t.TempDir()
-		require.NoError(t, saveAsJSON([]paprika.RecipeItem{{UID: "keepm", Hash: "h1"}}, pathToRecipesIndexFile(tempDir)))
+func TestWriteFileAtomicTempDir(t *testing.T) {
+	// crossDeviceRename records the directories from which files are renamed, and fails renames from stagingDir
+	// to any other directory as though they were on different filesystems.
+	crossDeviceRename := func(stagingDir string, renamedFrom *[]string) func(oldpath, newpath string) error {
+		return func(oldpath, newpath string) error {
+			*renamedFrom = append(*renamedFrom, filepath.Dir(oldpath))
+			if filepath.Dir(oldpath) == stagingDir && filepath.Dir(newpath) != stagingDir {
+				return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+			}
+			return os.Rename(oldpath, newpath)
+		}
+	}
+	writeContents := func(f *os.File) error {
+		_, err := f.WriteString(`{"ok":true}`)
+		return err
+	}
+	assertNoTempFiles := func(t *testing.T, dirs ...string) {
+		t.Helper()
+		for _, dir := range dirs {
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			for _, entry := range entries {
+				assert.False(t, strings.HasSuffix(entry.Name(), ".tmp"), "temporary file %s should be removed", entry.Name())
+			}
+		}
+	}
 
-		recipeDir := pathToRecipeDir(tempDir, "keepm")
-		require.NoError(t, os.MkdirAll(recipeDir, 0755))
-		require.NoError(t, os.WriteFile(pathToRecipeDeleteMarkerFile(tempDir, "keepm"), []byte(now.Add(-time.Hour).Format(time.RFC3339Nano)), 0644))
+	t.Run("sameFilesystem", func(t *testing.T) {
+		stagingDir, dataDir := t.TempDir(), t.TempDir()
+		path := filepath.Join(dataDir, "file.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"ok":false}`), 0644))
+		require.NoError(t, writeFileAtomic(path, stagingDir, writeContents))
 
-		err := purgeUnreferencedRecipes(context.Background(), tempDir, now, time.Hour, newTestLogger())
+		data, err := os.ReadFile(path)
 		require.NoError(t, err)
-
-		_, err = os.Stat(pathToRecipeDeleteMarkerFile(tempDir, "keepm"))
-		require.True(t, os.IsNotExist(err))
+		assert.Equal(t, `{"ok":true}`, string(data))
+		assertNoTempFiles(t, stagingDir, dataDir)
 	})
 
-	t.Run("immediatePurgeWithoutMarker", func(t *testing.T) {
-		tempDir := t.TempDir()
-		require.NoError(t, saveAsJSON([]paprika.RecipeItem{}, pathToRecipesIndexFile(tempDir)))
-
-		uid := "now44"
-		recipeDir := pathToRecipeDir(tempDir, uid)
-		require.NoError(t, os.MkdirAll(recipeDir, 0755))
-		require.NoError(t, os.WriteFile(pathToRecipeJSONFile(tempDir, uid), []byte(`{"uid":"now44"}`), 0644))
+	t.Run("crossFilesystem", func(t *testing.T) {
+		stagingDir, dataDir := t.TempDir(), t.TempDir()
+		path := filepath.Join(dataDir, "file.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"ok":false}`), 0644))
 
-		err := purgeUnreferencedRecipes(context.Background(), tempDir, now, 0, newTestLogger())
+		tmpPath, err := stageFile(path, stagingDir, writeContents)
 		require.NoError(t, err)
+		assert.Equal(t, stagingDir, filepath.Dir(tmpPath))
+		var renamedFrom []string
+		require.NoError(t, commitFile(tmpPath, path, crossDeviceRename(stagingDir, &renamedFrom)))
+		assert.Equal(t, []string{stagingDir}, renamedFrom)
+		require.NoError(t, os.Remove(tmpPath))
 
-		_, err = os.Stat(recipeDir)
-		require.True(t, os.IsNotExist(err))
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, `{"ok":true}`, string(data))
+		assertNoTempFiles(t, stagingDir, dataDir)
 	})
-}
-
-func TestReadTimestampMarker(t *testing.T) {
-	tempDir := t.TempDir()
-	target := filepath.Join(tempDir, "marker")
-	expected := time.Date(2023, 5, 6, 7, 8, 9, 0, time.UTC)
-	require.NoError(t, os.WriteFile(target, []byte(expected.Format(time.RFC3339Nano)), 0644))
-
-	got, err := readTimestampMarker(target, time.RFC3339Nano)
-	require.NoError(t, err)
-	assert.True(t, expected.Equal(got))
-}
 
-func TestPruneFilelessSubtrees(t *testing.T) {
-	tempDir := t.TempDir()
-	keepDir := filepath.Join(tempDir, "keep", "child")
-	removeDir := filepath.Join(tempDir, "remove", "empty", "nested")
-
-	require.NoError(t, os.MkdirAll(keepDir, 0755))
-	require.NoError(t, os.MkdirAll(removeDir, 0755))
-	require.NoError(t, os.WriteFile(filepath.Join(keepDir, "file.txt"), []byte("data"), 0644))
+	t.Run("checkAtomicWriteTempDir", func(t *testing.T) {
+		stagingDir, dataDir := t.TempDir(), t.TempDir()
 
-	err := PruneFilelessSubtrees(context.Background(), tempDir)
-	require.NoError(t, err)
+		var buf safeBuffer
+		require.NoError(t, checkAtomicWriteTempDir(stagingDir, dataDir, os.Rename, zerolog.New(&buf)))
+		assert.Empty(t, buf.String())
 
-	_, err = os.Stat(keepDir)
-	require.NoError(t, err)
+		var renamedFrom []string
+		require.NoError(t, checkAtomicWriteTempDir(stagingDir, dataDir, crossDeviceRename(stagingDir, &renamedFrom), zerolog.New(&buf)))
+		assert.Contains(t, buf.String(), "temp dir is on a different filesystem than the data directory")
 
-	_, err = os.Stat(filepath.Join(tempDir, "remove"))
-	require.True(t, os.IsNotExist(err))
+		for _, dir := range []string{stagingDir, dataDir} {
+			entries, err := os.ReadDir(dir)
+			require.NoError(t, err)
+			assert.Empty(t, entries, "probe files should be removed")
+		}
+	})
 }
 
 func TestSyncRunSuccess(t *testing.T) {
@@ -450,3 +644,1652 @@ func TestSyncRunWithErrors(t *testing.T) {
 	err := cmd.Run(context.Background(), cli, client, newTestLogger())
 	require.EqualError(t, err, "sync completed with errors")
 }
+
+func TestSyncRunConcurrentIndexAndDownload(t *testing.T) {
+	tempDir := t.TempDir()
+	firstDownloaded := make(chan struct{})
+	indexFileExisted := make(chan bool, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/recipes":
+			_, _ = w.Write([]byte(`{"result":[{"uid":"first","hash":"h1"},`))
+			w.(http.Flusher).Flush()
+			// Complete the index only once the first recipe has been downloaded.
+			select {
+			case <-firstDownloaded:
+			case <-time.After(5 * time.Second):
+				t.Error("first recipe was not downloaded before the index was complete")
+			}
+			_, _ = w.Write([]byte(`{"uid":"second","hash":"h2"}]}`))
+		case "/recipe/first":
+			_, err := os.Stat(pathToRecipesIndexFile(tempDir))
+			indexFileExisted <- err == nil
+			_, _ = w.Write([]byte(`{"result":{"uid":"first","hash":"h1"}}`))
+			close(firstDownloaded)
+		default:
+			_, _ = w.Write([]byte(`{"result":{"uid":"second","hash":"h2"}}`))
+		}
+	}))
+	defer server.Close()
+	client := newMockClient(t, server)
+
+	cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 2, ConcurrentIndexAndDownload: true}
+	require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: tempDir}, client, newTestLogger()))
+
+	assert.False(t, <-indexFileExisted, "recipe download should begin before the index file is saved")
+	assert.FileExists(t, pathToRecipeJSONFile(tempDir, "first"))
+	assert.FileExists(t, pathToRecipeJSONFile(tempDir, "second"))
+	index, err := LoadRecipesIndex(pathToRecipesIndexFile(tempDir))
+	require.NoError(t, err)
+	assert.Equal(t, []paprika.RecipeItem{{UID: "first", Hash: "h1"}, {UID: "second", Hash: "h2"}}, index)
+}
+
+func TestSyncRunQueueBuffer(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		queueBuffer uint
+		wantQueued  bool
+	}{
+		{"defaultsToWorkerCount", 0, false},
+		{"configured", 5, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs safeBuffer
+			queuedWhileBlocked := make(chan bool, 1)
+			var once sync.Once
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.URL.Path {
+				case "/recipes":
+					_, _ = w.Write([]byte(`{"result":[{"uid":"uid-a","hash":"h"},{"uid":"uid-b","hash":"h"},{"uid":"uid-c","hash":"h"},` +
+						`{"uid":"uid-d","hash":"h"},{"uid":"uid-e","hash":"h"},{"uid":"uid-f","hash":"h"}]}`))
+				default:
+					// Block the only worker on its first recipe, while the remaining items are queued.
+					once.Do(func() {
+						deadline := time.Now().Add(500 * time.Millisecond)
+						for !strings.Contains(logs.String(), "added all indexed recipe items to sync queue") && time.Now().Before(deadline) {
+							time.Sleep(10 * time.Millisecond)
+						}
+						queuedWhileBlocked <- strings.Contains(logs.String(), "added all indexed recipe items to sync queue")
+					})
+					uid := filepath.Base(r.URL.Path)
+					_, _ = w.Write([]byte(`{"result":{"uid":"` + uid + `","hash":"h"}}`))
+				}
+			}))
+			defer server.Close()
+			client := newMockClient(t, server)
+
+			tempDir := t.TempDir()
+			cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1, QueueBuffer: tt.queueBuffer}
+			log := zerolog.New(&logs).Level(zerolog.DebugLevel)
+			require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: tempDir}, client, log))
+
+			assert.Equal(t, tt.wantQueued, <-queuedWhileBlocked)
+			for _, uid := range []string{"uid-a", "uid-b", "uid-c", "uid-d", "uid-e", "uid-f"} {
+				assert.FileExists(t, pathToRecipeJSONFile(tempDir, uid))
+			}
+		})
+	}
+}
+
+func TestSyncRunWorkerShutdownReasons(t *testing.T) {
+	type logEvent struct {
+		Message         string         `json:"message"`
+		WorkerID        int            `json:"worker-id"`
+		ShutdownReason  string         `json:"shutdown-reason"`
+		ShutdownReasons map[string]int `json:"worker-shutdown-reasons"`
+	}
+	runSync := func(t *testing.T, ctx context.Context, handler http.HandlerFunc) []logEvent {
+		server := httptest.NewServer(handler)
+		defer server.Close()
+		client := newMockClient(t, server)
+
+		var logs safeBuffer
+		cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 2}
+		_ = cmd.Run(ctx, &CLI{DataDir: t.TempDir()}, client, zerolog.New(&logs).Level(zerolog.DebugLevel))
+
+		var events []logEvent
+		for line := range strings.Lines(logs.String()) {
+			var e logEvent
+			require.NoError(t, json.Unmarshal([]byte(line), &e))
+			events = append(events, e)
+		}
+		return events
+	}
+	finalWorkerReasons := func(events []logEvent) map[int]string {
+		reasons := make(map[int]string)
+		for _, e := range events {
+			if e.Message == "worker saved recipes in queue" || e.Message == "worker stopped before saving any recipes" {
+				reasons[e.WorkerID] = e.ShutdownReason
+			}
+		}
+		return reasons
+	}
+	aggregateReasons := func(t *testing.T, events []logEvent) map[string]int {
+		for _, e := range events {
+			if e.Message == "saved new/updated recipes" {
+				return e.ShutdownReasons
+			}
+		}
+		t.Fatal("no aggregate log event")
+		return nil
+	}
+	const index = `{"result":[{"uid":"uid-a","hash":"h"},{"uid":"uid-b","hash":"h"},{"uid":"uid-c","hash":"h"},` +
+		`{"uid":"uid-d","hash":"h"},{"uid":"uid-e","hash":"h"},{"uid":"uid-f","hash":"h"}]}`
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		events := runSync(t, ctx, func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/recipes" {
+				_, _ = w.Write([]byte(index))
+				return
+			}
+			// Cancel the sync while the first recipe is being fetched.
+			cancel()
+			<-r.Context().Done()
+		})
+
+		assert.Equal(t, map[int]string{1: workerShutdownCancelled, 2: workerShutdownCancelled}, finalWorkerReasons(events))
+		assert.Equal(t, map[string]int{
+			workerShutdownQueueDrained: 0, workerShutdownError: 0, workerShutdownCancelled: 2,
+		}, aggregateReasons(t, events))
+	})
+
+	t.Run("completed", func(t *testing.T) {
+		events := runSync(t, context.Background(), func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/recipes":
+				_, _ = w.Write([]byte(index))
+			case "/recipe/uid-c":
+				w.WriteHeader(http.StatusInternalServerError)
+			default:
+				_, _ = w.Write([]byte(`{"result":{"uid":"` + filepath.Base(r.URL.Path) + `","hash":"h"}}`))
+			}
+		})
+
+		reasons := finalWorkerReasons(events)
+		require.Len(t, reasons, 2)
+		assert.ElementsMatch(t, []string{workerShutdownError, workerShutdownQueueDrained}, []string{reasons[1], reasons[2]})
+		assert.Equal(t, map[string]int{
+			workerShutdownQueueDrained: 1, workerShutdownError: 1, workerShutdownCancelled: 0,
+		}, aggregateReasons(t, events))
+	})
+}
+
+func TestSyncRunDuplicateIndexedUIDs(t *testing.T) {
+	for _, concurrentIndex := range []bool{false, true} {
+		t.Run(fmt.Sprintf("concurrentIndexAndDownload=%t", concurrentIndex), func(t *testing.T) {
+			fetcher := &mockFetcher{
+				index: []paprika.RecipeItem{{UID: "uid-a", Hash: "h1"}, {UID: "uid-b", Hash: "h1"}, {UID: "uid-a", Hash: "h2"}},
+				recipes: map[string]paprika.Recipe{
+					"uid-a": {UID: "uid-a", Hash: "h2"},
+					"uid-b": {UID: "uid-b", Hash: "h1"},
+				},
+			}
+
+			tempDir := t.TempDir()
+			var logs safeBuffer
+			cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 2, ConcurrentIndexAndDownload: concurrentIndex}
+			require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: tempDir}, fetcher, zerolog.New(&logs)))
+
+			var fetches []string
+			for _, call := range fetcher.calls {
+				if uid, ok := strings.CutPrefix(call, "RecipeRaw "); ok {
+					fetches = append(fetches, uid)
+				}
+			}
+			assert.ElementsMatch(t, []string{"uid-a", "uid-b"}, fetches)
+			assert.Contains(t, logs.String(), `"duplicate-recipes-count":1,"message":"removed duplicate recipe UIDs from Paprika recipes index"`)
+			index, err := LoadRecipesIndex(pathToRecipesIndexFile(tempDir))
+			require.NoError(t, err)
+			assert.Equal(t, []paprika.RecipeItem{{UID: "uid-a", Hash: "h2"}, {UID: "uid-b", Hash: "h1"}}, index)
+		})
+	}
+}
+
+func TestSyncRunRecipeUIDFile(t *testing.T) {
+	newServer := func(t *testing.T, fetched *[]string) *httptest.Server {
+		var mu sync.Mutex
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/recipes" {
+				_, _ = w.Write([]byte(`{"result":[{"uid":"uid-a","hash":"h1"},{"uid":"uid-b","hash":"h1"},{"uid":"uid-c","hash":"h1"}]}`))
+				return
+			}
+			uid := filepath.Base(r.URL.Path)
+			mu.Lock()
+			*fetched = append(*fetched, uid)
+			mu.Unlock()
+			_, _ = w.Write([]byte(`{"result":{"uid":"` + uid + `","hash":"h1"}}`))
+		}))
+		t.Cleanup(server.Close)
+		return server
+	}
+	writeUIDFile := func(t *testing.T, content string) string {
+		path := filepath.Join(t.TempDir(), "uids.txt")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0666))
+		return path
+	}
+	const uidFile = "# recipes to fix\nuid-a\n\n  uid-c  \nuid-z\nuid-a\n"
+
+	t.Run("listedOnly", func(t *testing.T) {
+		var fetched []string
+		client := newMockClient(t, newServer(t, &fetched))
+		tempDir := t.TempDir()
+		// Current local copies are fetched again
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: "uid-c", Hash: "h1"}, pathToRecipeJSONFile(tempDir, "uid-c")))
+
+		cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 2, RecipeUIDFile: writeUIDFile(t, uidFile)}
+		require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: tempDir}, client, newTestLogger()))
+
+		assert.ElementsMatch(t, []string{"uid-a", "uid-c", "uid-z"}, fetched)
+		assert.NoFileExists(t, pathToRecipeJSONFile(tempDir, "uid-b"))
+		assert.NoFileExists(t, pathToRecipesIndexFile(tempDir), "recipes index should not be fetched")
+	})
+
+	t.Run("intersectIndex", func(t *testing.T) {
+		var fetched []string
+		client := newMockClient(t, newServer(t, &fetched))
+		tempDir := t.TempDir()
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: "uid-c", Hash: "h1"}, pathToRecipeJSONFile(tempDir, "uid-c")))
+
+		cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 2, RecipeUIDFile: writeUIDFile(t, uidFile), IntersectIndex: true}
+		require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: tempDir}, client, newTestLogger()))
+
+		assert.Equal(t, []string{"uid-a"}, fetched)
+		assert.FileExists(t, pathToRecipesIndexFile(tempDir))
+	})
+
+	t.Run("purgeDisabled", func(t *testing.T) {
+		var fetched []string
+		client := newMockClient(t, newServer(t, &fetched))
+		tempDir := t.TempDir()
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: "uid-old", Hash: "h1"}, pathToRecipeJSONFile(tempDir, "uid-old")))
+
+		purgeAfter := PurgeAfter(0)
+		cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1, RecipeUIDFile: writeUIDFile(t, "uid-a\n"),
+			IntersectIndex: true, PurgeAfter: &purgeAfter}
+		require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: tempDir}, client, newTestLogger()))
+
+		assert.Equal(t, []string{"uid-a"}, fetched)
+		assert.FileExists(t, pathToRecipeJSONFile(tempDir, "uid-old"))
+	})
+
+	t.Run("intersectIndexRequiresFile", func(t *testing.T) {
+		var cli CLI
+		parser, err := kong.New(&cli, kongVars(), kong.BindTo(context.Background(), (*context.Context)(nil)))
+		require.NoError(t, err)
+		_, err = parser.Parse([]string{"--data-dir", t.TempDir(), "sync", "--intersect-index"})
+		require.ErrorContains(t, err, "--intersect-index requires --recipe-uid-file")
+	})
+}
+
+func TestSyncRunAggregatesRecipeFailures(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/recipes":
+			_, _ = w.Write([]byte(`{"result":[{"uid":"uid-a","hash":"h"},{"uid":"broken1","hash":"h"},{"uid":"broken2","hash":"h"}]}`))
+		case "/recipe/broken1":
+			w.WriteHeader(http.StatusInternalServerError)
+			_, _ = w.Write([]byte("boom\n"))
+		case "/recipe/broken2":
+			http.NotFound(w, r)
+		default:
+			_, _ = w.Write([]byte(`{"result":{"uid":"uid-a","hash":"h"}}`))
+		}
+	}))
+	defer server.Close()
+	client := newMockClient(t, server)
+
+	var logs safeBuffer
+	cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1}
+	err := cmd.Run(context.Background(), &CLI{DataDir: t.TempDir()}, client, zerolog.New(&logs))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "sync completed with errors: 2 recipe(s) failed: ")
+	assert.Contains(t, err.Error(), "broken1: unexpected status code: 500 Internal Server Error boom")
+	assert.Contains(t, err.Error(), "broken2: unexpected status code: 404 Not Found 404 page not found")
+	assert.NotContains(t, err.Error(), "uid-a")
+
+	var summary struct {
+		Count    int      `json:"failed-recipes-count"`
+		Failures []string `json:"failed-recipes"`
+	}
+	for line := range strings.Lines(logs.String()) {
+		if strings.Contains(line, `"message":"failed to sync recipes"`) {
+			require.NoError(t, json.Unmarshal([]byte(line), &summary))
+		}
+	}
+	assert.Equal(t, 2, summary.Count)
+	assert.Len(t, summary.Failures, 2)
+}
+
+func TestRecipeFailuresBounded(t *testing.T) {
+	var f recipeFailures
+	require.NoError(t, f.err())
+	for i := range maxReportedRecipeFailures + 3 {
+		f.add(fmt.Sprintf("uid%02d", i), errors.New("boom"))
+	}
+	err := f.err()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), fmt.Sprintf("%d recipe(s) failed: uid00: boom; ", maxReportedRecipeFailures+3))
+	assert.True(t, strings.HasSuffix(err.Error(), "; and 3 more"), err.Error())
+	assert.Len(t, f.failures, maxReportedRecipeFailures)
+}
+
+func TestSyncRunJSONTrailingNewline(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/categories":
+			_, _ = w.Write([]byte(`{"result":[{"uid":"c1","name":"Breakfast"}]}`))
+		case "/recipes":
+			_, _ = w.Write([]byte(`{"result":[{"uid":"abcde","hash":"h1"}]}`))
+		default:
+			_, _ = w.Write([]byte(`{"result":{"uid":"abcde","hash":"h1"}}`))
+		}
+	}))
+	defer server.Close()
+	client := newMockClient(t, server)
+
+	for _, trailingNewline := range []bool{false, true} {
+		t.Run(fmt.Sprintf("trailingNewline=%t", trailingNewline), func(t *testing.T) {
+			tempDir := t.TempDir()
+			cli := &CLI{DataDir: tempDir, JSONTrailingNewline: trailingNewline}
+			cmd := SyncCMD{IncludeRecipes: true, IncludeCategories: true, DownloadConcurrency: 1}
+			require.NoError(t, cmd.Run(context.Background(), cli, client, newTestLogger()))
+
+			for _, path := range []string{
+				pathToRecipeJSONFile(tempDir, "abcde"),
+				pathToRecipesIndexFile(tempDir),
+				pathToCategoriesIndexFile(tempDir),
+			} {
+				data, err := os.ReadFile(path)
+				require.NoError(t, err)
+				require.NotEmpty(t, data)
+				assert.Equal(t, trailingNewline, data[len(data)-1] == '\n', "unexpected final byte of %s", filepath.Base(path))
+				assert.True(t, json.Valid(data))
+			}
+		})
+	}
+}
+
+func TestSyncIncludeFlag(t *testing.T) {
+	parse := func(t *testing.T, args ...string) (*CLI, error) {
+		var cli CLI
+		parser, err := kong.New(&cli, kongVars(), kong.BindTo(context.Background(), (*context.Context)(nil)))
+		require.NoError(t, err)
+		_, err = parser.Parse(append([]string{"--data-dir", t.TempDir(), "sync"}, args...))
+		return &cli, err
+	}
+
+	for _, tt := range []struct {
+		args    []string
+		want    []string
+		wantErr string
+	}{
+		{args: nil, want: nil},
+		{args: []string{"--include", "recipes"}, want: []string{"recipes"}},
+		{args: []string{"--include", "categories,recipes"}, want: []string{"categories", "recipes"}},
+		{args: []string{"--include", "recipes", "--include", "categories"}, want: []string{"recipes", "categories"}},
+		{args: []string{"--include", "recipes,photos"}, wantErr: `--include must be one of "recipes","categories" but got "photos"`},
+	} {
+		t.Run(strings.Join(tt.args, " "), func(t *testing.T) {
+			cli, err := parse(t, tt.args...)
+			if tt.wantErr != "" {
+				require.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, cli.Sync.Include)
+		})
+	}
+}
+
+func TestSyncRunInclude(t *testing.T) {
+	for _, tt := range []struct {
+		include   []string
+		wantCalls []string
+	}{
+		{[]string{syncDataCategories}, []string{"Categories"}},
+		{[]string{syncDataRecipes}, []string{"Recipes", "RecipeRaw abcde"}},
+		{[]string{syncDataRecipes, syncDataCategories}, []string{"Categories", "Recipes", "RecipeRaw abcde"}},
+	} {
+		t.Run(strings.Join(tt.include, ","), func(t *testing.T) {
+			fetcher := &mockFetcher{
+				index:   []paprika.RecipeItem{{UID: "abcde", Hash: "h1"}},
+				recipes: map[string]paprika.Recipe{"abcde": {UID: "abcde", Hash: "h1"}},
+			}
+
+			// The deprecated flags are superseded by --include
+			cmd := SyncCMD{Include: tt.include, IncludeRecipes: true, IncludeCategories: true, DownloadConcurrency: 1}
+			require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: t.TempDir()}, fetcher, newTestLogger()))
+			assert.ElementsMatch(t, tt.wantCalls, fetcher.calls)
+		})
+	}
+}
+
+func TestSyncRunOnlyIndex(t *testing.T) {
+	tempDir := t.TempDir()
+	cli := &CLI{DataDir: tempDir}
+	purgeAfter := PurgeAfter(0)
+	cmd := SyncCMD{
+		IncludeRecipes:      true,
+		IncludeCategories:   true,
+		DownloadConcurrency: 2,
+		OnlyIndex:           true,
+		PurgeAfter:          &purgeAfter,
+	}
+
+	var recipeRequests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/categories":
+			_, _ = w.Write([]byte(`{"result":[{"uid":"cat1","name":"Lunch"}]}`))
+		case "/recipes":
+			_, _ = w.Write([]byte(`{"result":[{"uid":"abcde","hash":"h1"}]}`))
+		default:
+			recipeRequests.Add(1)
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := newMockClient(t, server)
+
+	// Unindexed recipe would be purged immediately if purge were not skipped.
+	require.NoError(t, saveAsJSON(paprika.Recipe{UID: "old11"}, pathToRecipeJSONFile(tempDir, "old11")))
+
+	err := cmd.Run(context.Background(), cli, client, newTestLogger())
+	require.NoError(t, err)
+
+	_, err = os.Stat(pathToRecipesIndexFile(tempDir))
+	require.NoError(t, err)
+	_, err = os.Stat(pathToCategoriesIndexFile(tempDir))
+	require.NoError(t, err)
+	_, err = os.Stat(pathToRecipeJSONFile(tempDir, "abcde"))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(pathToRecipeJSONFile(tempDir, "old11"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), recipeRequests.Load())
+}
+
+func TestSyncRunScheduled(t *testing.T) {
+	run := func(t *testing.T, ctx context.Context, cli *CLI, client *paprika.Client) error {
+		cmd := SyncCMD{
+			IncludeRecipes:      true,
+			DownloadConcurrency: 1,
+			Interval:            10 * time.Millisecond,
+		}
+		done := make(chan error)
+		go func() { done <- cmd.Run(ctx, cli, client, newTestLogger()) }()
+
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("scheduled sync did not stop after cancellation")
+			return nil
+		}
+	}
+
+	t.Run("stopsAfterCancellation", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var indexRequests atomic.Int64
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/recipes" {
+				http.NotFound(w, r)
+				return
+			}
+			_, _ = w.Write([]byte(`{"result":[]}`))
+			if indexRequests.Add(1) == 2 {
+				cancel()
+			}
+		}))
+		defer server.Close()
+
+		require.NoError(t, run(t, ctx, &CLI{DataDir: t.TempDir()}, newMockClient(t, server)))
+		assert.Equal(t, int64(2), indexRequests.Load())
+	})
+
+	t.Run("finishesCycleInProgress", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var indexRequests atomic.Int64
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/recipes":
+				indexRequests.Add(1)
+				_, _ = w.Write([]byte(`{"result":[{"uid":"abcde","hash":"h1"}]}`))
+			default:
+				// Shutdown is requested while the recipe is being fetched
+				cancel()
+				_, _ = w.Write([]byte(`{"result":{"uid":"abcde","hash":"h1"}}`))
+			}
+		}))
+		defer server.Close()
+
+		tempDir := t.TempDir()
+		require.NoError(t, run(t, ctx, &CLI{DataDir: tempDir}, newMockClient(t, server)))
+		assert.Equal(t, int64(1), indexRequests.Load())
+		assert.FileExists(t, pathToRecipeJSONFile(tempDir, "abcde"))
+		assert.FileExists(t, pathToRecipesIndexFile(tempDir))
+	})
+
+	t.Run("returnsLastCycleError", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		var indexRequests atomic.Int64
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/recipes":
+				indexRequests.Add(1)
+				_, _ = w.Write([]byte(`{"result":[{"uid":"abcde","hash":"h1"}]}`))
+			default:
+				cancel()
+				http.Error(w, "nope", http.StatusInternalServerError)
+			}
+		}))
+		defer server.Close()
+
+		err := run(t, ctx, &CLI{DataDir: t.TempDir()}, newMockClient(t, server))
+		require.EqualError(t, err, "sync completed with errors: 1 recipe(s) failed: abcde: unexpected status code: 500 Internal Server Error nope")
+		assert.Equal(t, int64(1), indexRequests.Load())
+	})
+}
+
+func TestSyncRunVerifyAfterSync(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/recipes":
+			_, _ = w.Write([]byte(`{"result":[{"uid":"abcde","hash":"h1"}]}`))
+		case "/recipe/abcde":
+			_, _ = w.Write([]byte(`{"result":{"uid":"abcde","hash":"h1","name":"First"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	client := newMockClient(t, server)
+	cmd := SyncCMD{
+		IncludeRecipes:      true,
+		DownloadConcurrency: 1,
+		VerifyAfterSync:     true,
+	}
+
+	t.Run("success", func(t *testing.T) {
+		cli := &CLI{DataDir: t.TempDir()}
+		err := cmd.Run(context.Background(), cli, client, newTestLogger())
+		require.NoError(t, err)
+	})
+
+	t.Run("corruptWriteDetected", func(t *testing.T) {
+		cmd := cmd
+		cmd.saveRecipeJSON = func(ctx context.Context, val any, path, tempDir string, trailingNewline bool) error {
+			// Simulate a truncated write that nevertheless reports success
+			require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+			return os.WriteFile(path, []byte(`{"uid":"abc`), 0644)
+		}
+
+		cli := &CLI{DataDir: t.TempDir()}
+		err := cmd.Run(context.Background(), cli, client, newTestLogger())
+		require.EqualError(t, err, "sync completed with errors")
+	})
+}
+
+func TestSyncRunLogsJobIDs(t *testing.T) {
+	tempDir := t.TempDir()
+	cli := &CLI{DataDir: tempDir}
+	cmd := SyncCMD{
+		IncludeRecipes:      true,
+		DownloadConcurrency: 2,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/recipes":
+			_, _ = w.Write([]byte(`{"result":[{"uid":"aaaaa","hash":"h1"},{"uid":"bbbbb","hash":"h2"},{"uid":"ccccc","hash":"h3"}]}`))
+		default:
+			uid := strings.TrimPrefix(r.URL.Path, "/recipe/")
+			_, _ = w.Write([]byte(`{"result":{"uid":"` + uid + `","hash":"h"}}`))
+		}
+	}))
+	defer server.Close()
+
+	client := newMockClient(t, server)
+
+	var buf safeBuffer
+	log := zerolog.New(&buf).Level(zerolog.DebugLevel)
+	require.NoError(t, cmd.Run(context.Background(), cli, client, log))
+
+	jobIDsByUID := map[string]map[int]struct{}{}
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var event struct {
+			JobID     *int   `json:"job-id"`
+			RecipeUID string `json:"recipe-uid"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(line), &event))
+		if event.JobID == nil {
+			continue
+		}
+		if jobIDsByUID[event.RecipeUID] == nil {
+			jobIDsByUID[event.RecipeUID] = map[int]struct{}{}
+		}
+		jobIDsByUID[event.RecipeUID][*event.JobID] = struct{}{}
+	}
+
+	require.Len(t, jobIDsByUID, 3)
+	seen := map[int]string{}
+	for uid, ids := range jobIDsByUID {
+		require.Len(t, ids, 1, "recipe %s should be logged with a single job ID", uid)
+		for id := range ids {
+			assert.NotContains(t, seen, id, "job IDs should be distinct")
+			seen[id] = uid
+		}
+	}
+	assert.Equal(t, map[int]string{1: "aaaaa", 2: "bbbbb", 3: "ccccc"}, seen)
+}
+
+func TestSyncRunQueueOrder(t *testing.T) {
+	index := []paprika.RecipeItem{{UID: "uid-c", Hash: "h3"}, {UID: "uid-a", Hash: "h1"}, {UID: "uid-d", Hash: "h4"}, {UID: "uid-b", Hash: "h2"}}
+	recipes := make(map[string]paprika.Recipe, len(index))
+	for _, item := range index {
+		recipes[item.UID] = paprika.Recipe{UID: item.UID, Hash: item.Hash}
+	}
+	for _, tt := range []struct {
+		order string
+		want  []string
+	}{
+		{"", []string{"uid-c", "uid-a", "uid-d", "uid-b"}},
+		{queueOrderIndex, []string{"uid-c", "uid-a", "uid-d", "uid-b"}},
+		{queueOrderUID, []string{"uid-a", "uid-b", "uid-c", "uid-d"}},
+		// Only uid-d and uid-b have local recipe files (with outdated hashes, so that they are fetched)
+		{queueOrderName, []string{"uid-d", "uid-b", "uid-a", "uid-c"}},
+	} {
+		t.Run(cmp.Or(tt.order, "default"), func(t *testing.T) {
+			dataDir := t.TempDir()
+			require.NoError(t, saveAsJSON(paprika.Recipe{UID: "uid-b", Hash: "old", Name: "pancakes"}, pathToRecipeJSONFile(dataDir, "uid-b")))
+			require.NoError(t, saveAsJSON(paprika.Recipe{UID: "uid-d", Hash: "old", Name: "Apple Pie"}, pathToRecipeJSONFile(dataDir, "uid-d")))
+			fetcher := &mockFetcher{index: index, recipes: recipes}
+
+			cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1, QueueOrder: tt.order}
+			require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: dataDir}, fetcher, newTestLogger()))
+
+			var fetched []string
+			for _, call := range fetcher.calls {
+				if uid, ok := strings.CutPrefix(call, "RecipeRaw "); ok {
+					fetched = append(fetched, uid)
+				}
+			}
+			assert.Equal(t, tt.want, fetched)
+
+			saved, err := LoadRecipesIndex(pathToRecipesIndexFile(dataDir))
+			require.NoError(t, err)
+			assert.Equal(t, index, saved, "saved index should retain the order of the fetched index")
+		})
+	}
+
+	t.Run("concurrentIndexAndDownload", func(t *testing.T) {
+		var cli CLI
+		parser, err := kong.New(&cli, kongVars(), kong.BindTo(context.Background(), (*context.Context)(nil)))
+		require.NoError(t, err)
+		_, err = parser.Parse([]string{"--data-dir", t.TempDir(), "sync", "--queue-order=uid", "--concurrent-index-and-download"})
+		require.ErrorContains(t, err, "--queue-order=uid cannot be used with --concurrent-index-and-download")
+	})
+}
+
+func TestSyncRunFailOnWarning(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/recipes":
+			_, _ = w.Write([]byte(`{"result":[{"uid":"uid-a","hash":"indexed"},{"uid":"uid-b","hash":"h2"}]}`))
+		case "/recipe/uid-a":
+			_, _ = w.Write([]byte(`{"result":{"uid":"uid-a","hash":"fetched"}}`))
+		case "/recipe/uid-b":
+			_, _ = w.Write([]byte(`{"result":{"uid":"uid-b","hash":"h2"}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	client := newMockClient(t, server)
+
+	t.Run("disabled", func(t *testing.T) {
+		dataDir := t.TempDir()
+		cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1}
+		require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: dataDir}, client, newTestLogger()))
+		assert.FileExists(t, pathToRecipeJSONFile(dataDir, "uid-a"))
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		dataDir := t.TempDir()
+		cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1, FailOnWarning: true}
+		err := cmd.Run(context.Background(), &CLI{DataDir: dataDir}, client, newTestLogger())
+		require.ErrorContains(t, err, "sync completed with errors")
+		assert.ErrorContains(t, err, `fetched recipe hash "fetched" does not match reference hash "indexed"`)
+		assert.NoFileExists(t, pathToRecipeJSONFile(dataDir, "uid-a"))
+		assert.FileExists(t, pathToRecipeJSONFile(dataDir, "uid-b"))
+	})
+}
+
+func TestSyncRunMarkOnly(t *testing.T) {
+	tempDir := t.TempDir()
+	cli := &CLI{DataDir: tempDir}
+	cmd := SyncCMD{
+		IncludeRecipes:       true,
+		DownloadConcurrency:  1,
+		MarkOnly:             true,
+		AllowEmptyIndexPurge: true,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/recipes" {
+			_, _ = w.Write([]byte(`{"result":[]}`))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	client := newMockClient(t, server)
+
+	// Directory containing only a deletion marker must survive pruning.
+	markerOnlyUID := "mkonly"
+	require.NoError(t, os.MkdirAll(pathToRecipeDir(tempDir, markerOnlyUID), 0755))
+	require.NoError(t, os.WriteFile(pathToRecipeDeleteMarkerFile(tempDir, markerOnlyUID), []byte(time.Now().Add(-time.Hour).Format(time.RFC3339Nano)), 0644))
+	require.NoError(t, saveAsJSON(paprika.Recipe{UID: "gone1"}, pathToRecipeJSONFile(tempDir, "gone1")))
+
+	require.NoError(t, cmd.Run(context.Background(), cli, client, newTestLogger()))
+
+	_, err := os.Stat(pathToRecipeDeleteMarkerFile(tempDir, markerOnlyUID))
+	require.NoError(t, err)
+	_, err = os.Stat(pathToRecipeJSONFile(tempDir, "gone1"))
+	require.NoError(t, err)
+	_, err = os.Stat(pathToRecipeDeleteMarkerFile(tempDir, "gone1"))
+	require.NoError(t, err)
+}
+
+func TestSyncRunIndexFormatNDJSON(t *testing.T) {
+	tempDir := t.TempDir()
+	cli := &CLI{DataDir: tempDir, IndexFormat: indexFormatNDJSON}
+	fetcher := &mockFetcher{
+		index:   []paprika.RecipeItem{{UID: "uid-a", Hash: "h1"}, {UID: "uid-b", Hash: "h2"}},
+		recipes: map[string]paprika.Recipe{"uid-a": {UID: "uid-a", Hash: "h1"}, "uid-b": {UID: "uid-b", Hash: "h2"}},
+	}
+	require.NoError(t, saveAsJSON(paprika.Recipe{UID: "gone1", Hash: "h0"}, pathToRecipeJSONFile(tempDir, "gone1")))
+
+	cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1, MarkOnly: true}
+	require.NoError(t, cmd.Run(context.Background(), cli, fetcher, newTestLogger()))
+
+	data, err := os.ReadFile(pathToRecipesIndexFile(tempDir))
+	require.NoError(t, err)
+	assert.Equal(t, `{"hash":"h1","uid":"uid-a"}`+"\n"+`{"hash":"h2","uid":"uid-b"}`+"\n", string(data))
+	index, err := LoadRecipesIndex(pathToRecipesIndexFile(tempDir))
+	require.NoError(t, err)
+	assert.Equal(t, fetcher.index, index)
+
+	// Only the unindexed recipe is marked for deletion
+	assert.FileExists(t, pathToRecipeDeleteMarkerFile(tempDir, "gone1"))
+	assert.NoFileExists(t, pathToRecipeDeleteMarkerFile(tempDir, "uid-a"))
+	assert.NoFileExists(t, pathToRecipeDeleteMarkerFile(tempDir, "uid-b"))
+
+	removed, err := purgeUnreferencedRecipes(context.Background(), tempDir, pathToRecipesIndexFile(tempDir),
+		time.Now().Add(48*time.Hour), purgePolicy{PurgeAfter: 24 * time.Hour}, newTestLogger())
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	assert.NoFileExists(t, pathToRecipeJSONFile(tempDir, "gone1"))
+	assert.FileExists(t, pathToRecipeJSONFile(tempDir, "uid-a"))
+	assert.FileExists(t, pathToRecipeJSONFile(tempDir, "uid-b"))
+}
+
+func TestSyncRunEmptyIndexSkipsPurge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/recipes" {
+			_, _ = w.Write([]byte(`{"result":[]}`))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+	client := newMockClient(t, server)
+
+	tempDir := t.TempDir()
+	require.NoError(t, saveAsJSON(paprika.Recipe{UID: "local1"}, pathToRecipeJSONFile(tempDir, "local1")))
+
+	var buf safeBuffer
+	purgeAfter := PurgeAfter(0)
+	cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1, PurgeAfter: &purgeAfter}
+	require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: tempDir}, client, zerolog.New(&buf)))
+
+	assert.FileExists(t, pathToRecipeJSONFile(tempDir, "local1"))
+	assert.NoFileExists(t, pathToRecipeDeleteMarkerFile(tempDir, "local1"))
+	assert.Contains(t, buf.String(), `"level":"warn"`)
+	assert.Contains(t, buf.String(), "recipes index is empty but local recipe data exists")
+}
+
+func TestSyncRunMaxPurgePercent(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/recipes":
+			// The index suddenly omits most local recipes
+			_, _ = w.Write([]byte(`{"result":[{"uid":"keep1","hash":"h1"}]}`))
+		default:
+			_, _ = w.Write([]byte(`{"result":{"uid":"keep1","hash":"h1"}}`))
+		}
+	}))
+	defer server.Close()
+	client := newMockClient(t, server)
+
+	localUIDs := []string{"keep1", "gone1", "gone2", "gone3"}
+	for _, force := range []bool{false, true} {
+		t.Run(fmt.Sprintf("force=%t", force), func(t *testing.T) {
+			tempDir := t.TempDir()
+			for _, uid := range localUIDs {
+				require.NoError(t, saveAsJSON(paprika.Recipe{UID: uid, Hash: "h1"}, pathToRecipeJSONFile(tempDir, uid)))
+			}
+
+			purgeAfter := PurgeAfter(0)
+			cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1, PurgeAfter: &purgeAfter, MaxPurgePercent: 50, ForcePurge: force}
+			err := cmd.Run(context.Background(), &CLI{DataDir: tempDir}, client, newTestLogger())
+			if force {
+				require.NoError(t, err)
+			} else {
+				require.EqualError(t, err, "sync completed with errors")
+			}
+
+			for _, uid := range localUIDs[1:] {
+				_, err := os.Stat(pathToRecipeDir(tempDir, uid))
+				assert.Equal(t, force, os.IsNotExist(err), "unexpected purge state for recipe %q", uid)
+			}
+			assert.FileExists(t, pathToRecipeJSONFile(tempDir, "keep1"))
+		})
+	}
+}
+
+// fakeClock is a Clock whose current time is set explicitly.
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func TestSyncRunPurgeWithClock(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/recipes" {
+			_, _ = w.Write([]byte(`{"result":[]}`))
+			return
+		}
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+	client := newMockClient(t, server)
+
+	tempDir := t.TempDir()
+	require.NoError(t, saveAsJSON(paprika.Recipe{UID: "gone1"}, pathToRecipeJSONFile(tempDir, "gone1")))
+
+	start := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	clock := &fakeClock{now: start}
+	purgeAfter := PurgeAfter(time.Hour)
+	cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1, PurgeAfter: &purgeAfter, AllowEmptyIndexPurge: true, clock: clock}
+	runSync := func() {
+		t.Helper()
+		require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: tempDir}, client, newTestLogger()))
+	}
+
+	runSync()
+	marker, err := readDeleteMarker(pathToRecipeDeleteMarkerFile(tempDir, "gone1"))
+	require.NoError(t, err)
+	assert.True(t, start.Equal(marker.UnindexedSince), "marker should record the injected clock time")
+
+	clock.now = start.Add(time.Hour - time.Second)
+	runSync()
+	assert.FileExists(t, pathToRecipeJSONFile(tempDir, "gone1"))
+
+	clock.now = start.Add(time.Hour)
+	runSync()
+	assert.NoDirExists(t, pathToRecipeDir(tempDir, "gone1"))
+}
+
+func TestSyncRunRecipeMaxAttempts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/recipes" {
+			_, _ = w.Write([]byte(`{"result":[{"uid":"retry","hash":"h1"}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"result":{"uid":"retry","hash":"h1"}}`))
+	}))
+	defer server.Close()
+	client := newMockClient(t, server)
+
+	origDelay := recipeRetryDelay
+	t.Cleanup(func() { recipeRetryDelay = origDelay })
+	recipeRetryDelay = 0
+
+	failFirstSave := func() (func(ctx context.Context, val any, path, tempDir string, trailingNewline bool) error, *atomic.Int32) {
+		var attempts atomic.Int32
+		return func(ctx context.Context, val any, path, tempDir string, trailingNewline bool) error {
+			if attempts.Add(1) == 1 {
+				return errors.New("simulated disk error")
+			}
+			return writeJSONFile(ctx, val, path, tempDir, trailingNewline)
+		}, &attempts
+	}
+
+	t.Run("succeedsOnSecondAttempt", func(t *testing.T) {
+		save, attempts := failFirstSave()
+		tempDir := t.TempDir()
+		cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1, RecipeMaxAttempts: 2, saveRecipeJSON: save}
+		require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: tempDir}, client, newTestLogger()))
+
+		assert.EqualValues(t, 2, attempts.Load())
+		_, err := os.Stat(pathToRecipeJSONFile(tempDir, "retry"))
+		require.NoError(t, err)
+	})
+
+	t.Run("failsWithoutRetry", func(t *testing.T) {
+		save, attempts := failFirstSave()
+		tempDir := t.TempDir()
+		cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1, saveRecipeJSON: save}
+		err := cmd.Run(context.Background(), &CLI{DataDir: tempDir}, client, newTestLogger())
+		require.EqualError(t, err, "sync completed with errors: 1 recipe(s) failed: retry: simulated disk error")
+
+		assert.EqualValues(t, 1, attempts.Load())
+		_, err = os.Stat(pathToRecipeJSONFile(tempDir, "retry"))
+		require.True(t, os.IsNotExist(err))
+	})
+}
+
+func TestSyncRunIndexMaxAttempts(t *testing.T) {
+	origDelay := indexRetryDelay
+	t.Cleanup(func() { indexRetryDelay = origDelay })
+	indexRetryDelay = 0
+
+	newServer := func(t *testing.T, failures int32, status int) (*paprika.Client, *atomic.Int32) {
+		var indexRequests atomic.Int32
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/recipes" {
+				if indexRequests.Add(1) <= failures {
+					w.WriteHeader(status)
+					return
+				}
+				_, _ = w.Write([]byte(`{"result":[{"uid":"uid-a","hash":"h1"}]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"result":{"uid":"uid-a","hash":"h1"}}`))
+		}))
+		t.Cleanup(server.Close)
+		return newMockClient(t, server), &indexRequests
+	}
+
+	t.Run("succeedsOnSecondAttempt", func(t *testing.T) {
+		client, indexRequests := newServer(t, 1, http.StatusServiceUnavailable)
+		tempDir := t.TempDir()
+		cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1, IndexMaxAttempts: 2}
+		require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: tempDir}, client, newTestLogger()))
+
+		assert.EqualValues(t, 2, indexRequests.Load())
+		_, err := os.Stat(pathToRecipeJSONFile(tempDir, "uid-a"))
+		require.NoError(t, err, "recipes should be synced after the index is fetched")
+	})
+
+	t.Run("givesUpAfterMaxAttempts", func(t *testing.T) {
+		client, indexRequests := newServer(t, 3, http.StatusBadGateway)
+		tempDir := t.TempDir()
+		cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1, IndexMaxAttempts: 3}
+		require.Error(t, cmd.Run(context.Background(), &CLI{DataDir: tempDir}, client, newTestLogger()))
+
+		assert.EqualValues(t, 3, indexRequests.Load())
+		_, err := os.Stat(pathToRecipeJSONFile(tempDir, "uid-a"))
+		require.True(t, os.IsNotExist(err))
+	})
+
+	t.Run("doesNotRetryClientErrors", func(t *testing.T) {
+		client, indexRequests := newServer(t, 1, http.StatusUnauthorized)
+		cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1, IndexMaxAttempts: 3}
+		require.Error(t, cmd.Run(context.Background(), &CLI{DataDir: t.TempDir()}, client, newTestLogger()))
+		assert.EqualValues(t, 1, indexRequests.Load())
+	})
+}
+
+// deadlineFetcher is a mockFetcher that records the time remaining until the deadline (if any) of each call's context.
+type deadlineFetcher struct {
+	*mockFetcher
+	deadlines map[string]time.Duration
+}
+
+func (f *deadlineFetcher) recordDeadline(call string, ctx context.Context) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if deadline, ok := ctx.Deadline(); ok {
+		f.deadlines[call] = time.Until(deadline)
+	}
+}
+
+func (f *deadlineFetcher) Recipes(ctx context.Context) ([]paprika.RecipeItem, error) {
+	f.recordDeadline("Recipes", ctx)
+	return f.mockFetcher.Recipes(ctx)
+}
+
+func (f *deadlineFetcher) RecipesSeq(ctx context.Context) iter.Seq2[paprika.RecipeItem, error] {
+	f.recordDeadline("RecipesSeq", ctx)
+	return f.mockFetcher.RecipesSeq(ctx)
+}
+
+func (f *deadlineFetcher) RecipeRaw(ctx context.Context, uid string) (json.RawMessage, error) {
+	f.recordDeadline("RecipeRaw", ctx)
+	return f.mockFetcher.RecipeRaw(ctx, uid)
+}
+
+func (f *deadlineFetcher) Categories(ctx context.Context) ([]paprika.Category, error) {
+	f.recordDeadline("Categories", ctx)
+	return f.mockFetcher.Categories(ctx)
+}
+
+func TestSyncRunEndpointTimeouts(t *testing.T) {
+	const (
+		indexTimeout      = 1 * time.Hour
+		recipeTimeout     = 2 * time.Hour
+		categoriesTimeout = 3 * time.Hour
+	)
+	for _, tt := range []struct {
+		name string
+		cmd  SyncCMD
+		want map[string]time.Duration
+	}{
+		{"none", SyncCMD{}, map[string]time.Duration{}},
+		{"index", SyncCMD{IndexTimeout: indexTimeout},
+			map[string]time.Duration{"Recipes": indexTimeout}},
+		{"streamedIndex", SyncCMD{IndexTimeout: indexTimeout, ConcurrentIndexAndDownload: true},
+			map[string]time.Duration{"RecipesSeq": indexTimeout}},
+		{"recipe", SyncCMD{RecipeTimeout: recipeTimeout},
+			map[string]time.Duration{"RecipeRaw": recipeTimeout}},
+		{"categories", SyncCMD{CategoriesTimeout: categoriesTimeout},
+			map[string]time.Duration{"Categories": categoriesTimeout}},
+		{"all", SyncCMD{IndexTimeout: indexTimeout, RecipeTimeout: recipeTimeout, CategoriesTimeout: categoriesTimeout},
+			map[string]time.Duration{"Recipes": indexTimeout, "RecipeRaw": recipeTimeout, "Categories": categoriesTimeout}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			fetcher := &deadlineFetcher{
+				mockFetcher: &mockFetcher{
+					index:      []paprika.RecipeItem{{UID: "uid-a", Hash: "h1"}},
+					recipes:    map[string]paprika.Recipe{"uid-a": {UID: "uid-a", Hash: "h1"}},
+					categories: []paprika.Category{{UID: "c1", Name: "Breakfast"}},
+				},
+				deadlines: map[string]time.Duration{},
+			}
+			cmd := tt.cmd
+			cmd.IncludeRecipes, cmd.IncludeCategories, cmd.DownloadConcurrency = true, true, 1
+			require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: t.TempDir()}, fetcher, newTestLogger()))
+
+			require.Len(t, fetcher.deadlines, len(tt.want))
+			for call, timeout := range tt.want {
+				require.Contains(t, fetcher.deadlines, call)
+				assert.InDelta(t, timeout, fetcher.deadlines[call], float64(time.Minute), "unexpected timeout for %s", call)
+			}
+		})
+	}
+
+	t.Run("exceeded", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/recipes" {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(100 * time.Millisecond):
+				}
+				_, _ = w.Write([]byte(`{"result":[{"uid":"uid-a","hash":"h1"}]}`))
+				return
+			}
+			_, _ = w.Write([]byte(`{"result":{"uid":"uid-a","hash":"h1"}}`))
+		}))
+		defer server.Close()
+		client := newMockClient(t, server)
+
+		cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1, RecipeTimeout: 10 * time.Millisecond}
+		require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: t.TempDir()}, client, newTestLogger()),
+			"recipe timeout should not apply to the index")
+
+		dataDir := t.TempDir()
+		cmd = SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1, IndexTimeout: 10 * time.Millisecond}
+		require.Error(t, cmd.Run(context.Background(), &CLI{DataDir: dataDir}, client, newTestLogger()))
+		assert.NoFileExists(t, pathToRecipesIndexFile(dataDir))
+	})
+}
+
+func TestSyncRunMaxIdleTime(t *testing.T) {
+	t.Run("stalled", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			switch r.URL.Path {
+			case "/recipes":
+				_, _ = w.Write([]byte(`{"result":[{"uid":"uid-a","hash":"h1"},{"uid":"uid-b","hash":"h2"}]}`))
+			case "/recipe/uid-a":
+				_, _ = w.Write([]byte(`{"result":{"uid":"uid-a","hash":"h1"}}`))
+			default:
+				// Stop responding until the request is abandoned.
+				<-r.Context().Done()
+			}
+		}))
+		defer server.Close()
+
+		dataDir := t.TempDir()
+		cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1, MaxIdleTime: 50 * time.Millisecond}
+		err := cmd.Run(context.Background(), &CLI{DataDir: dataDir}, newMockClient(t, server), newTestLogger())
+		require.ErrorIs(t, err, errSyncStalled)
+		assert.ErrorContains(t, err, "stalled")
+		assert.FileExists(t, pathToRecipeJSONFile(dataDir, "uid-a"))
+		assert.NoFileExists(t, pathToRecipeJSONFile(dataDir, "uid-b"))
+	})
+
+	t.Run("completed", func(t *testing.T) {
+		fetcher := &mockFetcher{
+			index:   []paprika.RecipeItem{{UID: "uid-a", Hash: "h1"}},
+			recipes: map[string]paprika.Recipe{"uid-a": {UID: "uid-a", Hash: "h1"}},
+		}
+		cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1, MaxIdleTime: 50 * time.Millisecond}
+		require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: t.TempDir()}, fetcher, newTestLogger()))
+	})
+}
+
+func TestSyncRunContentAddressed(t *testing.T) {
+	dataDir := t.TempDir()
+	cli := &CLI{DataDir: dataDir, ContentAddressed: true}
+	fetcher := &mockFetcher{
+		index:   []paprika.RecipeItem{{UID: "uid-a", Hash: "h1"}, {UID: "uid-b", Hash: "h1"}},
+		recipes: map[string]paprika.Recipe{"uid-a": {UID: "uid-a", Hash: "h1", Name: "Pancakes"}, "uid-b": {UID: "uid-b", Hash: "h1"}},
+	}
+	cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1, VerifyAfterSync: true, RequireComplete: true}
+	require.NoError(t, cmd.Run(context.Background(), cli, fetcher, newTestLogger()))
+	assert.FileExists(t, pathToRecipeHashFile(dataDir, "uid-a", "h1"))
+	assert.NoFileExists(t, pathToRecipeJSONFile(dataDir, "uid-a"))
+
+	// Updated recipes are saved alongside prior versions, and unindexed recipes are purged
+	fetcher.index = []paprika.RecipeItem{{UID: "uid-a", Hash: "h2"}}
+	fetcher.recipes["uid-a"] = paprika.Recipe{UID: "uid-a", Hash: "h2", Name: "Waffles"}
+	purgeAfter := PurgeAfter(0)
+	cmd.PurgeAfter = &purgeAfter
+	require.NoError(t, cmd.Run(context.Background(), cli, fetcher, newTestLogger()))
+	assert.FileExists(t, pathToRecipeHashFile(dataDir, "uid-a", "h1"))
+	assert.FileExists(t, pathToRecipeHashFile(dataDir, "uid-a", "h2"))
+	assert.NoDirExists(t, pathToRecipeDir(dataDir, "uid-b"))
+
+	// The current version is read according to the recipes index
+	recipes, err := loadLocalRecipes(context.Background(), cli, newTestLogger())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Waffles"}, recipeNames(recipes))
+
+	// Fetched recipes are saved according to their own hash, so the indexed version is missing until the next sync
+	fetcher.index = []paprika.RecipeItem{{UID: "uid-a", Hash: "h3"}}
+	cmd.RequireComplete = false
+	require.NoError(t, cmd.Run(context.Background(), cli, fetcher, newTestLogger()))
+	assert.NoFileExists(t, pathToRecipeHashFile(dataDir, "uid-a", "h3"))
+
+	// Hashes that are unsafe for use in file paths are rejected
+	fetcher.index = []paprika.RecipeItem{{UID: "uid-a", Hash: "../h4"}}
+	require.Error(t, cmd.Run(context.Background(), cli, fetcher, newTestLogger()))
+	assert.NoFileExists(t, filepath.Join(pathToRecipesDir(dataDir), "uid-a", "h4.json"))
+}
+
+func TestIsRetryableFetchError(t *testing.T) {
+	for _, tt := range []struct {
+		err  error
+		want bool
+	}{
+		{&paprika.APIError{StatusCode: http.StatusInternalServerError}, true},
+		{fmt.Errorf("wrapped: %w", &paprika.APIError{StatusCode: http.StatusTooManyRequests}), true},
+		{&paprika.APIError{StatusCode: http.StatusNotFound}, false},
+		{&url.Error{Op: "Get", URL: "https://example.com", Err: errors.New("connection refused")}, true},
+		{&url.Error{Op: "Get", URL: "https://example.com", Err: context.Canceled}, false},
+		{errors.New("failed to decode response"), false},
+	} {
+		assert.Equal(t, tt.want, isRetryableFetchError(tt.err), "%v", tt.err)
+	}
+}
+
+func TestUpsertRecipeRejectsUnsafeUID(t *testing.T) {
+	rootDir := t.TempDir()
+	dataDir := filepath.Join(rootDir, "data")
+	require.NoError(t, os.Mkdir(dataDir, 0755))
+	cli := &CLI{DataDir: dataDir}
+
+	var requested atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested.Store(true)
+		_, _ = w.Write([]byte(`{"result":{"uid":"../../../evil","hash":"h1"}}`))
+	}))
+	defer server.Close()
+	client := newMockClient(t, server)
+
+	for _, uid := range []string{"../../../evil", "ab/../../cd", `ab\cd`, "ab"} {
+		t.Run(uid, func(t *testing.T) {
+			cmd := SyncCMD{StrictUIDValidation: true}
+			saved, err := cmd.UpsertRecipe(context.Background(), cli, client, paprika.RecipeItem{UID: uid, Hash: "h1"}, newTestLogger())
+			require.ErrorContains(t, err, "recipe UID")
+			assert.False(t, saved)
+		})
+	}
+	assert.False(t, requested.Load(), "no recipe should be fetched for an invalid UID")
+
+	entries, err := os.ReadDir(rootDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1, "no files should be written outside the data directory")
+	entries, err = os.ReadDir(dataDir)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestValidateUID(t *testing.T) {
+	require.NoError(t, validateUID("5E9B7C4B-9E2B-4E0F-A6D6-26A0D6A3A0F1", true))
+	require.NoError(t, validateUID("abc_123", true))
+	require.EqualError(t, validateUID("ab", false), `recipe UID "ab" is shorter than 3 characters`)
+	require.EqualError(t, validateUID("../x", true), `recipe UID "../x" contains characters that are not allowed in file paths`)
+	require.NoError(t, validateUID("../x", false))
+}
+
+func TestSyncRunWarnsMissingRecipeFiles(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/recipes":
+			_, _ = w.Write([]byte(`{"result":[{"uid":"found","hash":"h1"},{"uid":"gone1","hash":"h2"}]}`))
+		case "/recipe/gone1":
+			http.NotFound(w, r)
+		default:
+			_, _ = w.Write([]byte(`{"result":{"uid":"found","hash":"h1"}}`))
+		}
+	}))
+	defer server.Close()
+	client := newMockClient(t, server)
+
+	for _, requireComplete := range []bool{false, true} {
+		t.Run(fmt.Sprintf("requireComplete=%t", requireComplete), func(t *testing.T) {
+			cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1, RequireComplete: requireComplete}
+			var buf safeBuffer
+			err := cmd.Run(context.Background(), &CLI{DataDir: t.TempDir()}, client, zerolog.New(&buf))
+			require.EqualError(t, err, "sync completed with errors: 1 recipe(s) failed: gone1: unexpected status code: 404 Not Found 404 page not found")
+
+			var warning struct {
+				RecipeUIDs []string `json:"recipe-uids"`
+			}
+			for line := range strings.Lines(buf.String()) {
+				if strings.Contains(line, "indexed recipes have no local recipe file") {
+					require.NoError(t, json.Unmarshal([]byte(line), &warning))
+				}
+			}
+			assert.Equal(t, []string{"gone1"}, warning.RecipeUIDs)
+		})
+	}
+}
+
+func TestSyncRunWarnEmptyRecipes(t *testing.T) {
+	fetcher := &mockFetcher{
+		index: []paprika.RecipeItem{{UID: "full1", Hash: "h1"}, {UID: "noname", Hash: "h2"}, {UID: "stub1", Hash: "h3"}},
+		recipes: map[string]paprika.Recipe{
+			"full1":  {UID: "full1", Hash: "h1", Name: "Soup", Ingredients: "water", Directions: "Boil."},
+			"noname": {UID: "noname", Hash: "h2", Name: " ", Ingredients: "water"},
+			"stub1":  {UID: "stub1", Hash: "h3", Name: "Stub"},
+		},
+	}
+	for _, warn := range []bool{false, true} {
+		t.Run(fmt.Sprintf("warn=%t", warn), func(t *testing.T) {
+			var buf safeBuffer
+			dataDir := t.TempDir()
+			cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1, WarnEmptyRecipes: warn}
+			require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: dataDir}, fetcher, zerolog.New(&buf)))
+
+			// Empty recipes are still saved
+			for _, item := range fetcher.index {
+				assert.FileExists(t, pathToRecipeJSONFile(dataDir, item.UID))
+			}
+			emptyReasons := make(map[string]string)
+			var countLogs []string
+			for line := range strings.Lines(buf.String()) {
+				var event map[string]any
+				require.NoError(t, json.Unmarshal([]byte(line), &event))
+				switch event["message"] {
+				case "fetched recipe is empty":
+					emptyReasons[event["recipe-uid"].(string)] = event["empty-reason"].(string)
+				case "fetched empty recipes, which may be cleaned up in Paprika":
+					countLogs = append(countLogs, fmt.Sprint(event["empty-recipes-count"]))
+				}
+			}
+			if !warn {
+				assert.Empty(t, emptyReasons)
+				assert.Empty(t, countLogs)
+				return
+			}
+			assert.Equal(t, map[string]string{
+				"noname": "missing name",
+				"stub1":  "missing ingredients and directions",
+			}, emptyReasons)
+			assert.Equal(t, []string{"2"}, countLogs)
+			assert.Nil(t, cmd.emptyRecipesCount)
+		})
+	}
+}
+
+func TestSyncRunMirrorDir(t *testing.T) {
+	fetcher := &mockFetcher{
+		index: []paprika.RecipeItem{{UID: "uid-a", Hash: "h1"}, {UID: "uid-b", Hash: "h2"}},
+		recipes: map[string]paprika.Recipe{
+			"uid-a": {UID: "uid-a", Hash: "h1", Name: "Soup"},
+			"uid-b": {UID: "uid-b", Hash: "h2", Name: "Stew"},
+		},
+	}
+	cli := &CLI{DataDir: t.TempDir(), MirrorDir: t.TempDir()}
+	purgeAfter := PurgeAfter(0)
+	cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1, PurgeAfter: &purgeAfter}
+	require.NoError(t, cmd.Run(context.Background(), cli, fetcher, newTestLogger()))
+
+	for _, uid := range []string{"uid-a", "uid-b"} {
+		primary, err := os.ReadFile(pathToRecipeJSONFile(cli.DataDir, uid))
+		require.NoError(t, err)
+		mirrored, err := os.ReadFile(pathToRecipeJSONFile(cli.MirrorDir, uid))
+		require.NoError(t, err)
+		assert.Equal(t, primary, mirrored)
+	}
+
+	t.Run("purge", func(t *testing.T) {
+		fetcher.index = fetcher.index[:1]
+		require.NoError(t, cmd.Run(context.Background(), cli, fetcher, newTestLogger()))
+		for _, dataDir := range []string{cli.DataDir, cli.MirrorDir} {
+			assert.FileExists(t, pathToRecipeJSONFile(dataDir, "uid-a"))
+			assert.NoDirExists(t, pathToRecipeDir(dataDir, "uid-b"))
+		}
+	})
+
+	t.Run("purgeCommand", func(t *testing.T) {
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: "uid-c"}, pathToRecipeJSONFile(cli.MirrorDir, "uid-c")))
+		purgeCmd := PurgeCMD{}
+		require.NoError(t, purgeCmd.Run(context.Background(), cli, newTestLogger()))
+		assert.NoDirExists(t, pathToRecipeDir(cli.MirrorDir, "uid-c"))
+		assert.FileExists(t, pathToRecipeJSONFile(cli.MirrorDir, "uid-a"))
+	})
+
+	t.Run("mirrorWriteFailure", func(t *testing.T) {
+		cli := &CLI{DataDir: t.TempDir(), MirrorDir: t.TempDir()}
+		cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1}
+		cmd.saveRecipeJSON = func(ctx context.Context, val any, path, tempDir string, trailingNewline bool) error {
+			if strings.HasPrefix(path, cli.MirrorDir) {
+				return errors.New("simulated disk error")
+			}
+			return writeJSONFile(ctx, val, path, tempDir, trailingNewline)
+		}
+		require.NoError(t, cmd.Run(context.Background(), cli, fetcher, newTestLogger()))
+		assert.FileExists(t, pathToRecipeJSONFile(cli.DataDir, "uid-a"))
+		assert.NoFileExists(t, pathToRecipeJSONFile(cli.MirrorDir, "uid-a"))
+	})
+}
+
+func TestSyncRunLogSample(t *testing.T) {
+	const recipesCount = 20
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch uid := strings.TrimPrefix(r.URL.Path, "/recipe/"); {
+		case r.URL.Path == "/recipes":
+			items := make([]string, 0, recipesCount+1)
+			for i := range recipesCount {
+				items = append(items, fmt.Sprintf(`{"uid":"rcp%02d","hash":"h1"}`, i))
+			}
+			items = append(items, `{"uid":"broken","hash":"h1"}`)
+			_, _ = fmt.Fprintf(w, `{"result":[%s]}`, strings.Join(items, ","))
+		case uid == "broken":
+			http.Error(w, "nope", http.StatusInternalServerError)
+		default:
+			_, _ = fmt.Fprintf(w, `{"result":{"uid":%q,"hash":"h1"}}`, uid)
+		}
+	}))
+	defer server.Close()
+	client := newMockClient(t, server)
+
+	var buf safeBuffer
+	cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1, LogSample: 5}
+	err := cmd.Run(context.Background(), &CLI{DataDir: t.TempDir()}, client, zerolog.New(&buf))
+	require.EqualError(t, err, "sync completed with errors: 1 recipe(s) failed: broken: unexpected status code: 500 Internal Server Error nope")
+
+	var savedLogs, failedLogs int
+	for line := range strings.Lines(buf.String()) {
+		if strings.Contains(line, `"message":"saved recipe file"`) {
+			savedLogs++
+		}
+		if strings.Contains(line, `"recipe-uid":"broken"`) && strings.Contains(line, `"level":"error"`) {
+			failedLogs++
+		}
+	}
+	assert.Equal(t, recipesCount/5, savedLogs)
+	assert.Positive(t, failedLogs, "errors should never be sampled")
+	assert.Nil(t, cmd.savedRecipeSampler)
+}
+
+func TestMissingRecipeFiles(t *testing.T) {
+	tempDir := t.TempDir()
+	require.NoError(t, saveAsJSON(paprika.Recipe{UID: "found"}, pathToRecipeJSONFile(tempDir, "found")))
+	missing := missingRecipeFiles(&CLI{DataDir: tempDir}, []paprika.RecipeItem{{UID: "found"}, {UID: "gone1"}, {UID: "x"}})
+	assert.Equal(t, []string{"gone1", "x"}, missing)
+}
+
+func TestDateUnmarshalText(t *testing.T) {
+	var d Date
+	require.NoError(t, d.UnmarshalText([]byte("2024-03-15")))
+	assert.Equal(t, time.Date(2024, 3, 15, 0, 0, 0, 0, time.Local), time.Time(d))
+
+	require.NoError(t, d.UnmarshalText([]byte("2024-03-15T10:30:00Z")))
+	assert.Equal(t, time.Date(2024, 3, 15, 10, 30, 0, 0, time.UTC), time.Time(d).UTC())
+
+	require.EqualError(t, d.UnmarshalText([]byte("last tuesday")), "expected a date (YYYY-MM-DD) or RFC 3339 timestamp")
+}
+
+func TestSyncRunModifiedSince(t *testing.T) {
+	created := map[string]string{
+		"old01": "2023-12-31 23:59:59",
+		"new01": "2024-01-01 00:00:00",
+		"new02": "2024-06-01 12:00:00",
+		"unk01": "",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/recipes" {
+			_, _ = w.Write([]byte(`{"result":[{"uid":"old01","hash":"h"},{"uid":"new01","hash":"h"},{"uid":"new02","hash":"h"},{"uid":"unk01","hash":"h"}]}`))
+			return
+		}
+		uid := strings.TrimPrefix(r.URL.Path, "/recipe/")
+		_, _ = w.Write([]byte(`{"result":{"uid":"` + uid + `","hash":"h","created":"` + created[uid] + `"}}`))
+	}))
+	defer server.Close()
+	client := newMockClient(t, server)
+
+	tempDir := t.TempDir()
+	// Local data for a filtered-out recipe must not be purged
+	require.NoError(t, saveAsJSON(paprika.Recipe{UID: "old01", Hash: "stale"}, pathToRecipeJSONFile(tempDir, "old01")))
+
+	var since Date
+	require.NoError(t, since.UnmarshalText([]byte("2024-01-01")))
+	purgeAfter := PurgeAfter(0)
+	cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 2, ModifiedSince: &since, PurgeAfter: &purgeAfter}
+	require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: tempDir}, client, newTestLogger()))
+
+	for uid, wantHash := range map[string]string{"old01": "stale", "new01": "h", "new02": "h", "unk01": "h"} {
+		r, err := readRecipeFile(pathToRecipeJSONFile(tempDir, uid))
+		require.NoError(t, err, uid)
+		assert.Equal(t, wantHash, r.Hash, uid)
+	}
+}
+
+func TestSyncRunNoClobber(t *testing.T) {
+	var fetched []string
+	var fetchedMu sync.Mutex
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/recipes" {
+			_, _ = w.Write([]byte(`{"result":[{"uid":"stale","hash":"new"},{"uid":"fresh","hash":"new"}]}`))
+			return
+		}
+		uid := strings.TrimPrefix(r.URL.Path, "/recipe/")
+		fetchedMu.Lock()
+		fetched = append(fetched, uid)
+		fetchedMu.Unlock()
+		_, _ = w.Write([]byte(`{"result":{"uid":"` + uid + `","hash":"new"}}`))
+	}))
+	defer server.Close()
+	client := newMockClient(t, server)
+
+	tempDir := t.TempDir()
+	stalePath := pathToRecipeJSONFile(tempDir, "stale")
+	require.NoError(t, saveAsJSON(paprika.Recipe{UID: "stale", Hash: "old"}, stalePath))
+	staleBefore, err := os.ReadFile(stalePath)
+	require.NoError(t, err)
+	require.NoError(t, saveAsJSON(paprika.Recipe{UID: "gone1"}, pathToRecipeJSONFile(tempDir, "gone1")))
+
+	purgeAfter := PurgeAfter(0)
+	cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1, NoClobber: true, PurgeAfter: &purgeAfter}
+	require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: tempDir}, client, newTestLogger()))
+
+	staleAfter, err := os.ReadFile(stalePath)
+	require.NoError(t, err)
+	assert.Equal(t, staleBefore, staleAfter, "existing recipe file should be left untouched")
+	assert.Equal(t, []string{"fresh"}, fetched)
+	_, err = os.Stat(pathToRecipeJSONFile(tempDir, "fresh"))
+	require.NoError(t, err)
+	_, err = os.Stat(pathToRecipeJSONFile(tempDir, "gone1"))
+	require.NoError(t, err, "purge should be disabled in no-clobber mode")
+}
+
+func TestSyncRunResolveCategories(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/categories":
+			_, _ = w.Write([]byte(`{"result":[{"uid":"c1","name":"Breakfast"},{"uid":"c2","name":"Quick"},{"uid":"c3","name":"Unused"}]}`))
+		case "/recipes":
+			_, _ = w.Write([]byte(`{"result":[{"uid":"pancakes","hash":"h1"}]}`))
+		default:
+			_, _ = w.Write([]byte(`{"result":{"uid":"pancakes","hash":"h1","categories":["c1","c2","unknown"]}}`))
+		}
+	}))
+	defer server.Close()
+	client := newMockClient(t, server)
+
+	t.Run("resolvesNames", func(t *testing.T) {
+		tempDir := t.TempDir()
+		cmd := SyncCMD{IncludeRecipes: true, IncludeCategories: true, ResolveCategories: true, DownloadConcurrency: 1}
+		require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: tempDir}, client, newTestLogger()))
+
+		data, err := os.ReadFile(pathToRecipeCategoriesFile(tempDir, "pancakes"))
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"c1":"Breakfast","c2":"Quick"}`, string(data))
+		assert.False(t, bytes.HasSuffix(data, []byte("\n")), "--json-trailing-newline is disabled")
+	})
+
+	t.Run("trailingNewlineAndMirror", func(t *testing.T) {
+		cli := &CLI{DataDir: t.TempDir(), MirrorDir: t.TempDir(), JSONTrailingNewline: true}
+		cmd := SyncCMD{IncludeRecipes: true, IncludeCategories: true, ResolveCategories: true, DownloadConcurrency: 1}
+		require.NoError(t, cmd.Run(context.Background(), cli, client, newTestLogger()))
+
+		for _, dir := range []string{cli.DataDir, cli.MirrorDir} {
+			data, err := os.ReadFile(pathToRecipeCategoriesFile(dir, "pancakes"))
+			require.NoError(t, err)
+			assert.JSONEq(t, `{"c1":"Breakfast","c2":"Quick"}`, string(data))
+			assert.True(t, bytes.HasSuffix(data, []byte("\n")))
+		}
+	})
+
+	t.Run("usesRecipeFileWriter", func(t *testing.T) {
+		tempDir := t.TempDir()
+		cmd := SyncCMD{IncludeRecipes: true, IncludeCategories: true, ResolveCategories: true, DownloadConcurrency: 1}
+		cmd.saveRecipeJSON = func(ctx context.Context, val any, path, tempDir string, trailingNewline bool) error {
+			if filepath.Base(path) == filenameRecipeCategories {
+				return errors.New("simulated disk error")
+			}
+			return writeJSONFile(ctx, val, path, tempDir, trailingNewline)
+		}
+		err := cmd.Run(context.Background(), &CLI{DataDir: tempDir}, client, newTestLogger())
+		require.Error(t, err)
+		assert.NoFileExists(t, pathToRecipeCategoriesFile(tempDir, "pancakes"))
+	})
+}
+
+func TestSyncRunDryRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/categories":
+			_, _ = w.Write([]byte(`{"result":[{"uid":"c1","name":"Breakfast"}]}`))
+		case "/recipes":
+			_, _ = w.Write([]byte(`{"result":[{"uid":"chngd","hash":"h2"},{"uid":"newrc","hash":"h1"}]}`))
+		case "/recipe/chngd":
+			_, _ = w.Write([]byte(`{"result":{"uid":"chngd","hash":"h2","name":"Soup","ingredients":"2 eggs","rating":5}}`))
+		default:
+			_, _ = w.Write([]byte(`{"result":{"uid":"newrc","hash":"h1"}}`))
+		}
+	}))
+	defer server.Close()
+	client := newMockClient(t, server)
+
+	tempDir := t.TempDir()
+	changedPath := pathToRecipeJSONFile(tempDir, "chngd")
+	require.NoError(t, saveAsJSON(paprika.Recipe{UID: "chngd", Hash: "h1", Name: "Soup", Ingredients: "1 egg"}, changedPath))
+	before, err := os.ReadFile(changedPath)
+	require.NoError(t, err)
+
+	var buf safeBuffer
+	cmd := SyncCMD{IncludeRecipes: true, IncludeCategories: true, DownloadConcurrency: 1, DryRun: true, Journal: true, Generations: 1}
+	require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: tempDir}, client, zerolog.New(&buf)))
+
+	var changedFields []string
+	for line := range strings.Lines(buf.String()) {
+		var event struct {
+			Message       string   `json:"message"`
+			RecipeUID     string   `json:"recipe-uid"`
+			ChangedFields []string `json:"changed-fields"`
+		}
+		require.NoError(t, json.Unmarshal([]byte(line), &event))
+		if event.Message == "would update recipe file" && event.RecipeUID == "chngd" {
+			changedFields = event.ChangedFields
+		}
+	}
+	assert.Equal(t, []string{"hash", "ingredients", "rating"}, changedFields)
+
+	// No local data is modified
+	after, err := os.ReadFile(changedPath)
+	require.NoError(t, err)
+	assert.Equal(t, before, after)
+	entries, err := os.ReadDir(tempDir)
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "recipes", entries[0].Name())
+	_, err = os.Stat(pathToRecipeJSONFile(tempDir, "newrc"))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestSyncRunDebugWorkers(t *testing.T) {
+	interval := workerStatsInterval
+	workerStatsInterval = 10 * time.Millisecond
+	t.Cleanup(func() { workerStatsInterval = interval })
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch uid, ok := strings.CutPrefix(r.URL.Path, "/recipe/"); {
+		case r.URL.Path == "/recipes":
+			_, _ = w.Write([]byte(`{"result":[{"uid":"uid-a","hash":"h1"},{"uid":"uid-b","hash":"h2"},{"uid":"uid-c","hash":"h3"}]}`))
+		case ok:
+			time.Sleep(50 * time.Millisecond)
+			_, _ = fmt.Fprintf(w, `{"result":{"uid":%q,"hash":"h%d"}}`, uid, uid[len(uid)-1]-'a'+1)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	t.Run("enabled", func(t *testing.T) {
+		var buf safeBuffer
+		cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 2, DebugWorkers: true}
+		require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: t.TempDir()}, newMockClient(t, server), zerolog.New(&buf)))
+		logs := buf.String()
+		assert.Contains(t, logs, `"message":"recipe download worker stats"`)
+		assert.Contains(t, logs, `"busy-workers":2,"idle-workers":0`)
+		assert.Contains(t, logs, `"completed-items-per-second":`)
+
+		// No stats are logged once the sync is completed
+		n := strings.Count(buf.String(), "recipe download worker stats")
+		time.Sleep(5 * workerStatsInterval)
+		assert.Equal(t, n, strings.Count(buf.String(), "recipe download worker stats"))
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		var buf safeBuffer
+		cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 2}
+		require.NoError(t, cmd.Run(context.Background(), &CLI{DataDir: t.TempDir()}, newMockClient(t, server), zerolog.New(&buf)))
+		assert.NotContains(t, buf.String(), "recipe download worker stats")
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// recipeFileState records the hash of a saved recipe file along with the file's size and modification time,
+// so that a later sync can determine whether the file is up to date without reading it.
+type recipeFileState struct {
+	Hash    string    `json:"hash"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// syncState is the persisted state of the most recent sync.
+type syncState struct {
+	Recipes map[string]recipeFileState `json:"recipes"`
+}
+
+// recipeStateCache provides the hashes of local recipe files recorded by the previous sync,
+// and collects the same for the current sync. It is safe for concurrent use.
+// A nil *recipeStateCache is valid and never contains any recipe file states.
+type recipeStateCache struct {
+	// previous is read-only after the cache is loaded.
+	previous map[string]recipeFileState
+
+	mu      sync.Mutex
+	current map[string]recipeFileState
+}
+
+// loadRecipeStateCache loads the sync state file at path. If the file does not exist or cannot be read,
+// an empty cache is returned, so that recipe files are read to determine their hashes instead.
+func loadRecipeStateCache(path string, log zerolog.Logger) *recipeStateCache {
+	c := &recipeStateCache{current: map[string]recipeFileState{}}
+	var state syncState
+	if err := loadJSONFile(path, "sync state", &state); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			log.Debug().Msg("no sync state file; local recipe files will be read to determine their hashes")
+		} else {
+			log.Warn().Err(err).Msg("ignoring unreadable sync state file")
+		}
+		return c
+	}
+	c.previous = state.Recipes
+	log.Debug().Int("cached-recipes-count", len(c.previous)).Msg("loaded sync state file")
+	return c
+}
+
+// lookup returns the state recorded by the previous sync for the recipe file at path, which belongs to uid.
+// The recorded state is only returned if the file's size and modification time are unchanged since it was recorded.
+func (c *recipeStateCache) lookup(uid, path string) (recipeFileState, bool) {
+	if c == nil {
+		return recipeFileState{}, false
+	}
+	st, ok := c.previous[uid]
+	if !ok {
+		return st, false
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Size() != st.Size || !info.ModTime().Equal(st.ModTime) {
+		return st, false
+	}
+	return st, true
+}
+
+// keep carries over state recorded by the previous sync for uid to the current sync.
+func (c *recipeStateCache) keep(uid string, st recipeFileState) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.current[uid] = st
+}
+
+// record records hash as the hash of the recipe file at path, which belongs to uid.
+func (c *recipeStateCache) record(uid, path, hash string) {
+	if c == nil {
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	c.keep(uid, recipeFileState{Hash: hash, Size: info.Size(), ModTime: info.ModTime()})
+}
+
+// save writes the recipe file states recorded during the current sync to the sync state file at path,
+// staging it in tempDir if set (see writeFileAtomic).
+func (c *recipeStateCache) save(path, tempDir string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return writeJSONFile(context.Background(), syncState{Recipes: c.current}, path, tempDir, true)
+}
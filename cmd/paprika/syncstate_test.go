@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/TylerHendrickson/paprika"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecipeStateCache(t *testing.T) {
+	tempDir := t.TempDir()
+	recipePath := pathToRecipeJSONFile(tempDir, "abcde")
+	statePath := pathToSyncStateFile(tempDir)
+	require.NoError(t, saveAsJSON(paprika.Recipe{UID: "abcde", Hash: "h1"}, recipePath))
+
+	t.Run("missingStateFile", func(t *testing.T) {
+		c := loadRecipeStateCache(statePath, newTestLogger())
+		_, ok := c.lookup("abcde", recipePath)
+		assert.False(t, ok)
+	})
+
+	c := loadRecipeStateCache(statePath, newTestLogger())
+	c.record("abcde", recipePath, "h1")
+	require.NoError(t, c.save(statePath, ""))
+
+	t.Run("unchangedFile", func(t *testing.T) {
+		c := loadRecipeStateCache(statePath, newTestLogger())
+		st, ok := c.lookup("abcde", recipePath)
+		require.True(t, ok)
+		assert.Equal(t, "h1", st.Hash)
+	})
+
+	t.Run("changedFile", func(t *testing.T) {
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: "abcde", Hash: "h2", Name: "Changed"}, recipePath))
+		c := loadRecipeStateCache(statePath, newTestLogger())
+		_, ok := c.lookup("abcde", recipePath)
+		assert.False(t, ok, "cached state should be ignored when the recipe file changes")
+	})
+
+	t.Run("corruptStateFile", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(statePath, []byte("{"), 0644))
+		c := loadRecipeStateCache(statePath, newTestLogger())
+		_, ok := c.lookup("abcde", recipePath)
+		assert.False(t, ok)
+	})
+
+	t.Run("nilCache", func(t *testing.T) {
+		var c *recipeStateCache
+		c.record("abcde", recipePath, "h1")
+		_, ok := c.lookup("abcde", recipePath)
+		assert.False(t, ok)
+	})
+}
+
+func TestSyncRunUsesSyncState(t *testing.T) {
+	tempDir := t.TempDir()
+	cli := &CLI{DataDir: tempDir}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/recipes" {
+			_, _ = w.Write([]byte(`{"result":[{"uid":"abcde","hash":"h1"}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"result":{"uid":"abcde","hash":"h1"}}`))
+	}))
+	defer server.Close()
+	client := newMockClient(t, server)
+
+	cmd := SyncCMD{IncludeRecipes: true, DownloadConcurrency: 1}
+	require.NoError(t, cmd.Run(context.Background(), cli, client, newTestLogger()))
+
+	// Replace the recipe file contents without changing its size or modification time.
+	// Since the file is not read again, the recipe is not re-fetched.
+	recipePath := pathToRecipeJSONFile(tempDir, "abcde")
+	info, err := os.Stat(recipePath)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(recipePath, make([]byte, info.Size()), 0644))
+	require.NoError(t, os.Chtimes(recipePath, info.ModTime(), info.ModTime()))
+
+	update, exists, _ := (&SyncCMD{recipeStates: loadRecipeStateCache(pathToSyncStateFile(tempDir), newTestLogger())}).
+		shouldSaveRecipeCached("abcde", recipePath, "h1", newTestLogger())
+	assert.False(t, update)
+	assert.True(t, exists)
+}
+
+func BenchmarkShouldSaveRecipe(b *testing.B) {
+	const numRecipes = 500
+	tempDir := b.TempDir()
+	cache := &recipeStateCache{current: map[string]recipeFileState{}}
+	var uids []string
+	for i := range numRecipes {
+		uid := fmt.Sprintf("uid%05d", i)
+		path := pathToRecipeJSONFile(tempDir, uid)
+		require.NoError(b, saveAsJSON(paprika.Recipe{UID: uid, Hash: "h1", Ingredients: "1 cup flour"}, path))
+		cache.record(uid, path, "h1")
+		uids = append(uids, uid)
+	}
+	cache.previous, cache.current = cache.current, map[string]recipeFileState{}
+
+	b.Run("readRecipeFiles", func(b *testing.B) {
+		cmd := SyncCMD{}
+		for b.Loop() {
+			for _, uid := range uids {
+				cmd.shouldSaveRecipeCached(uid, pathToRecipeJSONFile(tempDir, uid), "h1", newTestLogger())
+			}
+		}
+	})
+
+	b.Run("cachedSyncState", func(b *testing.B) {
+		cmd := SyncCMD{recipeStates: cache}
+		for b.Loop() {
+			for _, uid := range uids {
+				cmd.shouldSaveRecipeCached(uid, pathToRecipeJSONFile(tempDir, uid), "h1", newTestLogger())
+			}
+		}
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// requestThrottle spaces out the Paprika API requests made by the clients that share it (see throttleMiddleware),
+// according to a maximum request rate (see --rate-limit) and a delay before each request (see --request-delay).
+// It is safe for concurrent use. A nil *requestThrottle is valid and never delays requests.
+type requestThrottle struct {
+	// Minimum time between the starts of consecutive requests, or zero for no rate limit
+	interval time.Duration
+	delay    time.Duration
+
+	mu sync.Mutex
+	// Earliest time at which the next request may be sent according to the rate limit
+	next time.Time
+}
+
+// newRequestThrottle returns a requestThrottle that allows at most rate requests per second
+// (or any number, if rate is zero), each of which is sent no sooner than delay after it is made.
+func newRequestThrottle(rate float64, delay time.Duration) *requestThrottle {
+	t := &requestThrottle{delay: delay}
+	if rate > 0 {
+		t.interval = time.Duration(float64(time.Second) / rate)
+	}
+	return t
+}
+
+// reserve returns how long a request made at now must wait before it is sent,
+// and reserves its place according to the rate limit.
+func (t *requestThrottle) reserve(now time.Time) time.Duration {
+	wait := t.delay
+	if t.interval > 0 {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		sendAt := now.Add(wait)
+		if sendAt.Before(t.next) {
+			sendAt = t.next
+		}
+		t.next = sendAt.Add(t.interval)
+		wait = sendAt.Sub(now)
+	}
+	return wait
+}
+
+// wait blocks until a request may be sent, or returns ctx.Err() if ctx is canceled first.
+func (t *requestThrottle) wait(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	d := t.reserve(time.Now())
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// throttleMiddleware delays each Paprika API request according to throttle.
+// Requests whose context is canceled while they are delayed fail without being sent.
+func throttleMiddleware(throttle *requestThrottle) func(http.RoundTripper) http.RoundTripper {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if err := throttle.wait(req.Context()); err != nil {
+				return nil, err
+			}
+			return next.RoundTrip(req)
+		})
+	}
+}
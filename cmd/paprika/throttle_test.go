@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestThrottleReserve(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	t.Run("rateLimit", func(t *testing.T) {
+		throttle := newRequestThrottle(4, 0)
+		assert.Equal(t, time.Duration(0), throttle.reserve(now))
+		assert.Equal(t, 250*time.Millisecond, throttle.reserve(now))
+		assert.Equal(t, 500*time.Millisecond, throttle.reserve(now))
+		assert.Equal(t, 250*time.Millisecond, throttle.reserve(now.Add(500*time.Millisecond)))
+		assert.Equal(t, time.Duration(0), throttle.reserve(now.Add(2*time.Second)), "unused capacity is not accumulated")
+	})
+
+	t.Run("delay", func(t *testing.T) {
+		throttle := newRequestThrottle(0, 100*time.Millisecond)
+		for range 3 {
+			assert.Equal(t, 100*time.Millisecond, throttle.reserve(now))
+		}
+	})
+
+	t.Run("rateLimitAndDelay", func(t *testing.T) {
+		throttle := newRequestThrottle(2, 100*time.Millisecond)
+		assert.Equal(t, 100*time.Millisecond, throttle.reserve(now))
+		assert.Equal(t, 600*time.Millisecond, throttle.reserve(now))
+		assert.Equal(t, 100*time.Millisecond, throttle.reserve(now.Add(time.Second)))
+	})
+}
+
+func TestThrottleMiddleware(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer server.Close()
+
+	t.Run("delaysRequests", func(t *testing.T) {
+		client := &http.Client{Transport: throttleMiddleware(newRequestThrottle(50, 0))(http.DefaultTransport)}
+		started := time.Now()
+		for range 3 {
+			resp, err := client.Get(server.URL)
+			require.NoError(t, err)
+			resp.Body.Close()
+		}
+		assert.GreaterOrEqual(t, time.Since(started), 40*time.Millisecond)
+	})
+
+	t.Run("canceledWhileDelayed", func(t *testing.T) {
+		requests.Store(0)
+		client := &http.Client{Transport: throttleMiddleware(newRequestThrottle(0, time.Hour))(http.DefaultTransport)}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+		require.NoError(t, err)
+		_, err = client.Do(req)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Zero(t, requests.Load(), "request should not be sent")
+	})
+
+	t.Run("nilThrottle", func(t *testing.T) {
+		var throttle *requestThrottle
+		require.NoError(t, throttle.wait(context.Background()))
+	})
+}
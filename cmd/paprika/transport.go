@@ -0,0 +1,153 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"net/http/httptrace"
+	"time"
+
+	"github.com/TylerHendrickson/paprika"
+	"github.com/rs/zerolog"
+)
+
+// roundTripperFunc adapts a function to the http.RoundTripper interface.
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// connDiagnosticsMiddleware returns HTTP client middleware that logs the negotiated protocol
+// and connection reuse details for each response at Debug level.
+func connDiagnosticsMiddleware(log zerolog.Logger) func(http.RoundTripper) http.RoundTripper {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if log.GetLevel() > zerolog.DebugLevel {
+				return next.RoundTrip(req)
+			}
+
+			var connInfo httptrace.GotConnInfo
+			trace := &httptrace.ClientTrace{
+				GotConn: func(info httptrace.GotConnInfo) { connInfo = info },
+			}
+			resp, err := next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+			if err != nil {
+				return resp, err
+			}
+
+			log.Debug().
+				Str("method", req.Method).
+				Str("url", req.URL.Redacted()).
+				Int("status-code", resp.StatusCode).
+				Str("proto", resp.Proto).
+				Bool("conn-reused", connInfo.Reused).
+				Bool("conn-was-idle", connInfo.WasIdle).
+				Dur("conn-idle-time", connInfo.IdleTime).
+				Msg("received Paprika API response")
+			return resp, nil
+		})
+	}
+}
+
+// httpTraceMiddleware returns HTTP client middleware that logs the DNS lookup, connection, TLS handshake,
+// and time-to-first-byte events of each request at Trace level. Durations are relative to the start of the request.
+func httpTraceMiddleware(log zerolog.Logger) func(http.RoundTripper) http.RoundTripper {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if log.GetLevel() > zerolog.TraceLevel {
+				return next.RoundTrip(req)
+			}
+
+			log := log.With().
+				Str("method", req.Method).
+				Str("url", req.URL.Redacted()).
+				Logger()
+			start := time.Now()
+			event := func(err error) *zerolog.Event {
+				return log.Trace().Err(err).Dur("elapsed", time.Since(start))
+			}
+			trace := &httptrace.ClientTrace{
+				GetConn: func(hostPort string) {
+					event(nil).Str("host-port", hostPort).Msg("http trace: getting connection")
+				},
+				DNSStart: func(info httptrace.DNSStartInfo) {
+					event(nil).Str("host", info.Host).Msg("http trace: DNS lookup started")
+				},
+				DNSDone: func(info httptrace.DNSDoneInfo) {
+					addrs := make([]string, len(info.Addrs))
+					for i, addr := range info.Addrs {
+						addrs[i] = addr.String()
+					}
+					event(info.Err).Strs("addrs", addrs).Msg("http trace: DNS lookup done")
+				},
+				ConnectStart: func(network, addr string) {
+					event(nil).Str("network", network).Str("addr", addr).Msg("http trace: connect started")
+				},
+				ConnectDone: func(network, addr string, err error) {
+					event(err).Str("network", network).Str("addr", addr).Msg("http trace: connect done")
+				},
+				TLSHandshakeStart: func() {
+					event(nil).Msg("http trace: TLS handshake started")
+				},
+				TLSHandshakeDone: func(state tls.ConnectionState, err error) {
+					event(err).
+						Str("tls-version", tls.VersionName(state.Version)).
+						Str("negotiated-protocol", state.NegotiatedProtocol).
+						Msg("http trace: TLS handshake done")
+				},
+				GotConn: func(info httptrace.GotConnInfo) {
+					event(nil).Bool("conn-reused", info.Reused).Msg("http trace: got connection")
+				},
+				WroteRequest: func(info httptrace.WroteRequestInfo) {
+					event(info.Err).Msg("http trace: wrote request")
+				},
+				GotFirstResponseByte: func() {
+					event(nil).Msg("http trace: got first response byte")
+				},
+			}
+			return next.RoundTrip(req.WithContext(httptrace.WithClientTrace(req.Context(), trace)))
+		})
+	}
+}
+
+// captureHeadersMiddleware returns HTTP client middleware that logs the values of the given response headers
+// (when present) for each response at Debug level.
+func captureHeadersMiddleware(headers []string, log zerolog.Logger) func(http.RoundTripper) http.RoundTripper {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			resp, err := next.RoundTrip(req)
+			if err != nil || log.GetLevel() > zerolog.DebugLevel {
+				return resp, err
+			}
+
+			captured := zerolog.Dict()
+			var count int
+			for _, name := range headers {
+				if values := resp.Header.Values(name); len(values) > 0 {
+					captured.Strs(http.CanonicalHeaderKey(name), values)
+					count++
+				}
+			}
+			log.Debug().
+				Str("method", req.Method).
+				Str("url", req.URL.Redacted()).
+				Int("status-code", resp.StatusCode).
+				Dict("response-headers", captured).
+				Int("captured-headers-count", count).
+				Msg("captured Paprika API response headers")
+			return resp, nil
+		})
+	}
+}
+
+// logAPIErrorBody logs the complete response body at Debug level when err is (or wraps) a Paprika API error,
+// since the body may be truncated in the error message itself.
+func logAPIErrorBody(log zerolog.Logger, err error) {
+	var apiErr *paprika.APIError
+	if errors.As(err, &apiErr) {
+		log.Debug().Int("status-code", apiErr.StatusCode).
+			Bytes("response-body", apiErr.Body).
+			Msg("received Paprika API error response")
+	}
+}
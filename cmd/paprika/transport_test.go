@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/TylerHendrickson/paprika"
+	"github.com/alecthomas/kong"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnDiagnosticsMiddleware(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"result":[]}`))
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	log := zerolog.New(&buf).Level(zerolog.DebugLevel)
+	client := newMockClient(t, server, paprika.WithMiddleware(connDiagnosticsMiddleware(log)))
+
+	_, err := client.Recipes(context.Background())
+	require.NoError(t, err)
+	_, err = client.Recipes(context.Background())
+	require.NoError(t, err)
+
+	lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2)
+	assert.Contains(t, string(lines[0]), `"proto":"HTTP/1.1"`)
+	assert.Contains(t, string(lines[0]), `"conn-reused":false`)
+	assert.Contains(t, string(lines[1]), `"conn-reused":true`)
+}
+
+func TestHTTPTraceMiddleware(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"result":[]}`))
+	}))
+	defer server.Close()
+	// Request the server by hostname, so that a DNS lookup is made
+	serverURL, err := url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	serverURL.Host = "localhost:" + serverURL.Port()
+
+	t.Run("enabled", func(t *testing.T) {
+		var buf bytes.Buffer
+		log := zerolog.New(&buf).Level(zerolog.TraceLevel)
+		client, err := paprika.NewClientWithURL("user", "pass", serverURL, paprika.WithMiddleware(httpTraceMiddleware(log)))
+		require.NoError(t, err)
+
+		_, err = client.Recipes(context.Background())
+		require.NoError(t, err)
+
+		for _, msg := range []string{
+			"http trace: getting connection",
+			"http trace: DNS lookup started",
+			"http trace: DNS lookup done",
+			"http trace: connect started",
+			"http trace: connect done",
+			"http trace: got connection",
+			"http trace: wrote request",
+			"http trace: got first response byte",
+		} {
+			assert.Contains(t, buf.String(), `"message":"`+msg+`"`)
+		}
+		assert.Contains(t, buf.String(), `"level":"trace"`)
+		assert.Contains(t, buf.String(), `"url":"`+serverURL.String()+`recipes"`)
+		assert.Contains(t, buf.String(), `"elapsed":`)
+	})
+
+	t.Run("disabled above trace level", func(t *testing.T) {
+		var buf bytes.Buffer
+		log := zerolog.New(&buf).Level(zerolog.DebugLevel)
+		client, err := paprika.NewClientWithURL("user", "pass", serverURL, paprika.WithMiddleware(httpTraceMiddleware(log)))
+		require.NoError(t, err)
+
+		_, err = client.Recipes(context.Background())
+		require.NoError(t, err)
+		assert.Empty(t, buf.String())
+	})
+}
+
+func TestCaptureHeadersMiddleware(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-RateLimit-Remaining", "42")
+		w.Header().Set("ETag", `"abc"`)
+		w.Header().Set("X-Other", "secret")
+		_, _ = w.Write([]byte(`{"result":[]}`))
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	log := zerolog.New(&buf).Level(zerolog.DebugLevel)
+	headers := []string{"x-ratelimit-remaining", "ETag", "X-Missing"}
+	client := newMockClient(t, server, paprika.WithMiddleware(captureHeadersMiddleware(headers, log)))
+
+	_, err := client.Recipes(context.Background())
+	require.NoError(t, err)
+
+	var entry struct {
+		Message         string              `json:"message"`
+		ResponseHeaders map[string][]string `json:"response-headers"`
+		Count           int                 `json:"captured-headers-count"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "captured Paprika API response headers", entry.Message)
+	assert.Equal(t, map[string][]string{"X-Ratelimit-Remaining": {"42"}, "Etag": {`"abc"`}}, entry.ResponseHeaders)
+	assert.Equal(t, 2, entry.Count)
+	assert.NotContains(t, buf.String(), "secret")
+
+	t.Run("disabled above debug level", func(t *testing.T) {
+		var buf bytes.Buffer
+		log := zerolog.New(&buf).Level(zerolog.InfoLevel)
+		client := newMockClient(t, server, paprika.WithMiddleware(captureHeadersMiddleware(headers, log)))
+		_, err := client.Recipes(context.Background())
+		require.NoError(t, err)
+		assert.Empty(t, buf.String())
+	})
+
+	t.Run("flag", func(t *testing.T) {
+		restoreZerologFieldNamesCleanup(t)
+		var cli CLI
+		parser, err := kong.New(&cli, kongVars(), kong.BindTo(context.Background(), (*context.Context)(nil)))
+		require.NoError(t, err)
+		_, err = parser.Parse([]string{"--data-dir", t.TempDir(), "--capture-headers", "X-RateLimit-Remaining,ETag", "config"})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"X-RateLimit-Remaining", "ETag"}, cli.CaptureHeaders)
+	})
+}
+
+func TestLogAPIErrorBody(t *testing.T) {
+	body := strings.Repeat("x", 2*paprika.DefaultErrorBodyLimit)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	client := newMockClient(t, server)
+	var buf bytes.Buffer
+	log := zerolog.New(&buf).Level(zerolog.DebugLevel)
+
+	cmd := SyncCMD{}
+	_, err := cmd.UpsertRecipe(context.Background(), &CLI{DataDir: t.TempDir()}, client, paprika.RecipeItem{UID: "abcde", Hash: "h1"}, log)
+	require.Error(t, err)
+	assert.Less(t, len(err.Error()), len(body), "error message should contain truncated body")
+	assert.True(t, strings.HasSuffix(err.Error(), "…"))
+
+	assert.Contains(t, buf.String(), `"response-body":"`+body+`"`)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/TylerHendrickson/paprika"
+)
+
+// recipeIssue describes a problem found with locally stored recipe data.
+type recipeIssue struct {
+	UID  string
+	Path string
+	Err  error
+}
+
+// verifyRecipes checks the locally stored recipe file for each of the given items using up to concurrency workers,
+// and returns an issue for each recipe file that fails verification, in the order of items.
+// A non-nil error is returned only if verification could not be completed, e.g. because ctx was canceled,
+// in which case the issues found so far are returned.
+func verifyRecipes(ctx context.Context, cli *CLI, items []paprika.RecipeItem, concurrency int) ([]recipeIssue, error) {
+	paths := make([]string, len(items))
+	errs := make([]error, len(items))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(max(concurrency, 1), len(items)) {
+		wg.Go(func() {
+			for i := range jobs {
+				paths[i] = cli.recipeFile(cli.DataDir, items[i].UID, items[i].Hash)
+				errs[i] = verifyRecipeFile(paths[i], items[i])
+			}
+		})
+	}
+
+	var err error
+dispatch:
+	for i := range items {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			err = ctx.Err()
+			break dispatch
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	var issues []recipeIssue
+	for i, item := range items {
+		if errs[i] != nil {
+			issues = append(issues, recipeIssue{UID: item.UID, Path: paths[i], Err: errs[i]})
+		}
+	}
+	return issues, err
+}
+
+// verifyRecipeFile checks that the file at path contains a valid recipe whose UID and hash match item.
+func verifyRecipeFile(path string, item paprika.RecipeItem) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var recipe paprika.Recipe
+	if err := json.NewDecoder(f).Decode(&recipe); err != nil {
+		return fmt.Errorf("failed to decode recipe JSON: %w", err)
+	}
+	if recipe.UID != item.UID {
+		return fmt.Errorf("stored recipe UID %q does not match expected UID %q", recipe.UID, item.UID)
+	}
+	if recipe.Hash != item.Hash {
+		return fmt.Errorf("stored recipe hash %q does not match expected hash %q", recipe.Hash, item.Hash)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/TylerHendrickson/paprika"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifyRecipes(t *testing.T) {
+	tempDir := t.TempDir()
+	require.NoError(t, saveAsJSON(paprika.Recipe{UID: "good1", Hash: "h1"}, pathToRecipeJSONFile(tempDir, "good1")))
+	require.NoError(t, saveAsJSON(paprika.Recipe{UID: "wronguid", Hash: "h2"}, pathToRecipeJSONFile(tempDir, "uid22")))
+	require.NoError(t, saveAsJSON(paprika.Recipe{UID: "hash3", Hash: "stale"}, pathToRecipeJSONFile(tempDir, "hash3")))
+	require.NoError(t, os.MkdirAll(pathToRecipeDir(tempDir, "bad44"), 0755))
+	require.NoError(t, os.WriteFile(pathToRecipeJSONFile(tempDir, "bad44"), []byte(`{"uid":`), 0644))
+
+	issues, err := verifyRecipes(context.Background(), &CLI{DataDir: tempDir}, []paprika.RecipeItem{
+		{UID: "good1", Hash: "h1"},
+		{UID: "uid22", Hash: "h2"},
+		{UID: "hash3", Hash: "h3"},
+		{UID: "bad44", Hash: "h4"},
+		{UID: "gone5", Hash: "h5"},
+	}, 2)
+	require.NoError(t, err)
+
+	issuesByUID := map[string]error{}
+	for _, issue := range issues {
+		issuesByUID[issue.UID] = issue.Err
+	}
+	require.Len(t, issuesByUID, 4)
+	assert.NotContains(t, issuesByUID, "good1")
+	assert.ErrorContains(t, issuesByUID["uid22"], "does not match expected UID")
+	assert.ErrorContains(t, issuesByUID["hash3"], "does not match expected hash")
+	assert.ErrorContains(t, issuesByUID["bad44"], "failed to decode recipe JSON")
+	assert.ErrorIs(t, issuesByUID["gone5"], os.ErrNotExist)
+}
+
+func TestVerifyRecipesConcurrency(t *testing.T) {
+	tempDir := t.TempDir()
+	var items []paprika.RecipeItem
+	var expected []string
+	for i := range 200 {
+		uid := fmt.Sprintf("uid%03d", i)
+		item := paprika.RecipeItem{UID: uid, Hash: "h1"}
+		items = append(items, item)
+		switch i % 4 {
+		case 0:
+			require.NoError(t, saveAsJSON(paprika.Recipe{UID: uid, Hash: "h1"}, pathToRecipeJSONFile(tempDir, uid)))
+			continue
+		case 1:
+			require.NoError(t, saveAsJSON(paprika.Recipe{UID: uid, Hash: "stale"}, pathToRecipeJSONFile(tempDir, uid)))
+		case 2:
+			require.NoError(t, os.MkdirAll(pathToRecipeDir(tempDir, uid), 0755))
+			require.NoError(t, os.WriteFile(pathToRecipeJSONFile(tempDir, uid), []byte(`{"uid":`), 0644))
+		case 3:
+			// No recipe file
+		}
+		expected = append(expected, uid)
+	}
+
+	for _, concurrency := range []int{0, 1, 4, 16, 500} {
+		t.Run(fmt.Sprint(concurrency), func(t *testing.T) {
+			issues, err := verifyRecipes(context.Background(), &CLI{DataDir: tempDir}, items, concurrency)
+			require.NoError(t, err)
+			var uids []string
+			for _, issue := range issues {
+				uids = append(uids, issue.UID)
+				assert.Equal(t, pathToRecipeJSONFile(tempDir, issue.UID), issue.Path)
+			}
+			assert.Equal(t, expected, uids, "all issues should be found, in the order of items")
+		})
+	}
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		issues, err := verifyRecipes(ctx, &CLI{DataDir: tempDir}, items, 4)
+		require.ErrorIs(t, err, context.Canceled)
+		assert.Empty(t, issues)
+	})
+}
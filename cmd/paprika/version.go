@@ -58,6 +58,12 @@ func versionStringShort() string {
 	return BuildVersion
 }
 
+// defaultUserAgent returns the default User-Agent header value for Paprika API requests,
+// which identifies this application and its build version.
+func defaultUserAgent() string {
+	return "paprika-backup/" + versionStringShort() + " (+https://github.com/TylerHendrickson/paprika)"
+}
+
 func versionStringFull() string {
 	enrichBuildInfo()
 	out := BuildVersion
@@ -43,6 +43,13 @@ func TestVersionStringShort(t *testing.T) {
 	assert.Equal(t, testBuildVersion, versionStringShort())
 }
 
+func TestDefaultUserAgent(t *testing.T) {
+	resetBuildVarsTestCleanup(t)
+
+	BuildVersion = "v1.2.3"
+	assert.Equal(t, "paprika-backup/v1.2.3 (+https://github.com/TylerHendrickson/paprika)", defaultUserAgent())
+}
+
 func TestVersionStringFull(t *testing.T) {
 	for _, tt := range []struct {
 		name      string
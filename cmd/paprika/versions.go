@@ -0,0 +1,83 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// recipeVersion describes a prior version of a recipe file.
+type recipeVersion struct {
+	Hash    string
+	Path    string
+	ModTime time.Time
+}
+
+// retainRecipeVersion preserves the current recipe file for uid as a prior version identified by hash,
+// then removes the oldest prior versions so that at most keep remain.
+// The current recipe file is hard-linked (or copied, if hard links are unsupported) rather than moved,
+// so that it remains in place until it is replaced.
+func retainRecipeVersion(dataDir, uid, hash string, keep int) error {
+	if err := os.MkdirAll(pathToRecipeVersionsDir(dataDir, uid), os.ModePerm); err != nil {
+		return err
+	}
+	src := pathToRecipeJSONFile(dataDir, uid)
+	dst := pathToRecipeVersionFile(dataDir, uid, hash)
+	if err := os.Remove(dst); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Link(src, dst); err != nil {
+		if err := copyFile(src, dst); err != nil {
+			return fmt.Errorf("copy %q: %w", src, err)
+		}
+	}
+
+	versions, err := listRecipeVersions(dataDir, uid)
+	if err != nil {
+		return err
+	}
+	for len(versions) > keep {
+		if err := os.Remove(versions[0].Path); err != nil {
+			return fmt.Errorf("remove %q: %w", versions[0].Path, err)
+		}
+		versions = versions[1:]
+	}
+	return nil
+}
+
+// listRecipeVersions returns the stored prior versions of the recipe identified by uid, ordered from oldest to newest.
+func listRecipeVersions(dataDir, uid string) ([]recipeVersion, error) {
+	dir := pathToRecipeVersionsDir(dataDir, uid)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var versions []recipeVersion
+	for _, e := range entries {
+		hash, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || !e.Type().IsRegular() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, recipeVersion{
+			Hash:    hash,
+			Path:    filepath.Join(dir, e.Name()),
+			ModTime: info.ModTime(),
+		})
+	}
+	slices.SortFunc(versions, func(a, b recipeVersion) int {
+		return cmp.Or(a.ModTime.Compare(b.ModTime), cmp.Compare(a.Hash, b.Hash))
+	})
+	return versions, nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TylerHendrickson/paprika"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpsertRecipeKeepVersions(t *testing.T) {
+	tempDir := t.TempDir()
+	cli := &CLI{DataDir: tempDir}
+	uid := "versn1"
+
+	hash := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"result":{"uid":"versn1","hash":"` + hash + `"}}`))
+	}))
+	defer server.Close()
+
+	client := newMockClient(t, server)
+	cmd := SyncCMD{KeepVersions: 2}
+
+	start := time.Now().Add(-time.Hour)
+	for i, h := range []string{"h1", "h2", "h3", "h4"} {
+		hash = h
+		saved, err := cmd.UpsertRecipe(context.Background(), cli, client, paprika.RecipeItem{UID: uid, Hash: h}, newTestLogger())
+		require.NoError(t, err)
+		require.True(t, saved)
+		// Ensure distinct modification times regardless of filesystem timestamp resolution
+		ts := start.Add(time.Duration(i) * time.Minute)
+		require.NoError(t, os.Chtimes(pathToRecipeJSONFile(tempDir, uid), ts, ts))
+
+		versions, err := listRecipeVersions(tempDir, uid)
+		require.NoError(t, err)
+		assert.Len(t, versions, min(i, 2), "versions should accumulate up to the limit")
+	}
+
+	versions, err := listRecipeVersions(tempDir, uid)
+	require.NoError(t, err)
+	require.Len(t, versions, 2)
+	assert.Equal(t, "h2", versions[0].Hash)
+	assert.Equal(t, "h3", versions[1].Hash)
+
+	data, err := os.ReadFile(pathToRecipeVersionFile(tempDir, uid, "h3"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"hash":"h3"`)
+	data, err = os.ReadFile(pathToRecipeJSONFile(tempDir, uid))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"hash":"h4"`)
+}
+
+func TestPurgeRemovesRecipeVersions(t *testing.T) {
+	tempDir := t.TempDir()
+	require.NoError(t, saveAsJSON([]paprika.RecipeItem{{UID: "other1", Hash: "h1"}}, pathToRecipesIndexFile(tempDir)))
+
+	uid := "versn2"
+	require.NoError(t, saveAsJSON(paprika.Recipe{UID: uid, Hash: "old"}, pathToRecipeJSONFile(tempDir, uid)))
+	require.NoError(t, retainRecipeVersion(tempDir, uid, "old", 1))
+	require.NoError(t, saveAsJSON(paprika.Recipe{UID: uid, Hash: "new"}, pathToRecipeJSONFile(tempDir, uid)))
+
+	err := purgeAndPrune(context.Background(), tempDir, pathToRecipesIndexFile(tempDir), "", time.Now(), purgePolicy{}, newTestLogger())
+	require.NoError(t, err)
+
+	_, err = os.Stat(pathToRecipeVersionsDir(tempDir, uid))
+	require.True(t, os.IsNotExist(err))
+	_, err = os.Stat(pathToRecipeDir(tempDir, uid))
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestPurgeRecipeDirWithOnlyVersions(t *testing.T) {
+	now := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+	newDataDir := func(t *testing.T) string {
+		tempDir := t.TempDir()
+		require.NoError(t, saveAsJSON([]paprika.RecipeItem{{UID: "keep1", Hash: "h2"}}, pathToRecipesIndexFile(tempDir)))
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: "keep1", Hash: "h2"}, pathToRecipeJSONFile(tempDir, "keep1")))
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: "keep1", Hash: "h1"}, pathToRecipeVersionFile(tempDir, "keep1", "h1")))
+		// The recipe file of an unindexed recipe is gone, but its prior versions remain
+		require.NoError(t, saveAsJSON(paprika.Recipe{UID: "gone1", Hash: "h1"}, pathToRecipeVersionFile(tempDir, "gone1", "h1")))
+		return tempDir
+	}
+
+	t.Run("marksRecipeDir", func(t *testing.T) {
+		tempDir := newDataDir(t)
+		policy := purgePolicy{PurgeAfter: time.Hour}
+		preflight, err := preflightPurge(pathToRecipesDir(tempDir), map[string]string{"keep1": "h2"}, now.Add(-time.Hour), policy)
+		require.NoError(t, err)
+		assert.Equal(t, purgePreflight{Local: 2, Unindexed: 1}, preflight)
+
+		_, err = purgeUnreferencedRecipes(context.Background(), tempDir, pathToRecipesIndexFile(tempDir), now, policy, newTestLogger())
+		require.NoError(t, err)
+		assert.FileExists(t, pathToRecipeDeleteMarkerFile(tempDir, "gone1"))
+		assert.NoFileExists(t, filepath.Join(pathToRecipeVersionsDir(tempDir, "gone1"), filenameRecipeDeleteMarker))
+		assert.NoFileExists(t, filepath.Join(pathToRecipeVersionsDir(tempDir, "keep1"), filenameRecipeDeleteMarker))
+		assert.FileExists(t, pathToRecipeVersionFile(tempDir, "gone1", "h1"))
+	})
+
+	t.Run("purgesRecipeDir", func(t *testing.T) {
+		tempDir := newDataDir(t)
+		policy := purgePolicy{MaxPurgePercent: 50}
+		preflight, err := preflightPurge(pathToRecipesDir(tempDir), map[string]string{"keep1": "h2"}, now, policy)
+		require.NoError(t, err)
+		assert.Equal(t, purgePreflight{Local: 2, Unindexed: 1, Purgeable: 1}, preflight, "versions directories are not counted as recipes")
+
+		require.NoError(t, purgeAndPrune(context.Background(), tempDir, pathToRecipesIndexFile(tempDir), "", now, policy, newTestLogger()))
+		assert.NoDirExists(t, pathToRecipeDir(tempDir, "gone1"))
+		assert.FileExists(t, pathToRecipeJSONFile(tempDir, "keep1"))
+		assert.FileExists(t, pathToRecipeVersionFile(tempDir, "keep1", "h1"))
+	})
+
+	t.Run("reindexSkipsVersions", func(t *testing.T) {
+		tempDir := newDataDir(t)
+		index, err := rebuildRecipesIndex(context.Background(), &CLI{DataDir: tempDir, ContentAddressed: true}, newTestLogger())
+		require.NoError(t, err)
+		assert.Equal(t, []paprika.RecipeItem{{UID: "keep1", Hash: "h2"}}, index)
+	})
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// workerStatsInterval is the interval between worker statistics logs (see --debug-workers).
+// It may be overridden in tests.
+var workerStatsInterval = 5 * time.Second
+
+// workerStats tracks the state of the recipe download workers and periodically logs it at debug level,
+// for tuning download concurrency. It is safe for concurrent use.
+// A nil *workerStats is valid and records nothing.
+type workerStats struct {
+	workers    int
+	queueDepth func() int
+	busy       atomic.Int64
+	completed  atomic.Int64
+	stopped    chan struct{}
+	done       chan struct{}
+}
+
+// startWorkerStats returns a workerStats for the given number of workers, which logs the number of items
+// waiting in the queue (as reported by queueDepth), busy and idle workers, and the rate of completed items
+// every workerStatsInterval until it is stopped.
+func startWorkerStats(workers int, queueDepth func() int, log zerolog.Logger) *workerStats {
+	s := &workerStats{
+		workers:    workers,
+		queueDepth: queueDepth,
+		stopped:    make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+	go s.report(log)
+	return s
+}
+
+func (s *workerStats) report(log zerolog.Logger) {
+	defer close(s.done)
+	ticker := time.NewTicker(workerStatsInterval)
+	defer ticker.Stop()
+	last := time.Now()
+	var lastCompleted int64
+	for {
+		select {
+		case <-s.stopped:
+			return
+		case now := <-ticker.C:
+			busy, completed := s.busy.Load(), s.completed.Load()
+			log.Debug().Int("queue-depth", s.queueDepth()).
+				Int64("busy-workers", busy).
+				Int64("idle-workers", int64(s.workers)-busy).
+				Int64("completed-items", completed).
+				Float64("completed-items-per-second", float64(completed-lastCompleted)/now.Sub(last).Seconds()).
+				Msg("recipe download worker stats")
+			last, lastCompleted = now, completed
+		}
+	}
+}
+
+// started records that a worker started a task.
+func (s *workerStats) started() {
+	if s == nil {
+		return
+	}
+	s.busy.Add(1)
+}
+
+// finished records that a worker completed a task.
+func (s *workerStats) finished() {
+	if s == nil {
+		return
+	}
+	s.busy.Add(-1)
+	s.completed.Add(1)
+}
+
+// stop stops logging worker stats, and waits for any in-progress log to be written.
+func (s *workerStats) stop() {
+	if s == nil {
+		return
+	}
+	close(s.stopped)
+	<-s.done
+}
@@ -1,6 +1,9 @@
 package paprika
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"time"
+)
 
 type RecipeItem struct {
 	Hash string `json:"hash,omitempty"`
@@ -70,6 +73,9 @@ type PantryItem struct {
 	Ingredient string `json:"ingredient,omitempty"`
 }
 
+// RecipeTimestampLayout is the time layout of timestamps (such as Recipe.Created) provided by the Paprika API.
+const RecipeTimestampLayout = "2006-01-02 15:04:05"
+
 type Recipe struct {
 	Rating          int      `json:"rating,omitempty"`
 	PhotoHash       string   `json:"photo_hash,omitempty"`
@@ -94,3 +100,9 @@ type Recipe struct {
 	NutritionalInfo string   `json:"nutritional_info,omitempty"`
 	Directions      string   `json:"directions,omitempty"`
 }
+
+// CreatedTime parses the Created timestamp of r.
+// Paprika timestamps do not include a time zone, so the result is in loc.
+func (r Recipe) CreatedTime(loc *time.Location) (time.Time, error) {
+	return time.ParseInLocation(RecipeTimestampLayout, r.Created, loc)
+}
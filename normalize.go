@@ -0,0 +1,60 @@
+package paprika
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// NormalizeRecipe returns a copy of r with formatting differences that do not affect its meaning removed,
+// so that semantically-equal recipes can be compared (or encoded and hashed) byte-for-byte.
+// Leading and trailing whitespace is trimmed from single-line fields, trailing whitespace and surrounding blank lines
+// are trimmed from multi-line fields (like the ingredients and directions), and categories are sorted with duplicates removed.
+func NormalizeRecipe(r Recipe) Recipe {
+	for _, field := range []*string{
+		&r.PhotoHash, &r.Photo, &r.UID, &r.Scale, &r.Source, &r.Hash, &r.SourceURL, &r.Difficulty,
+		&r.PhotoURL, &r.CookTime, &r.Name, &r.Created, &r.ImageURL, &r.PrepTime, &r.Servings,
+	} {
+		*field = strings.TrimSpace(*field)
+	}
+	for _, field := range r.textFields() {
+		*field = normalizeText(*field)
+	}
+
+	if len(r.Categories) == 0 {
+		r.Categories = nil
+	} else {
+		r.Categories = slices.Compact(slices.Sorted(slices.Values(r.Categories)))
+	}
+	return r
+}
+
+// NormalizeLineEndings returns a copy of r with the line endings of its multi-line text fields
+// (like the ingredients and directions) converted to LF, from CRLF or CR. Unlike NormalizeRecipe,
+// it makes no other changes, so that recipes can be stored with consistent line endings but otherwise as authored.
+func NormalizeLineEndings(r Recipe) Recipe {
+	for _, field := range r.textFields() {
+		*field = normalizeLineEndings(*field)
+	}
+	return r
+}
+
+// textFields returns pointers to the multi-line, human-readable text fields of r.
+func (r *Recipe) textFields() []*string {
+	return []*string{&r.Ingredients, &r.Notes, &r.NutritionalInfo, &r.Directions}
+}
+
+// normalizeLineEndings converts CRLF and CR line endings in s to LF.
+func normalizeLineEndings(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\r", "\n")
+}
+
+// normalizeText trims trailing whitespace from each line of s, normalizes line endings,
+// and trims leading and trailing blank lines.
+func normalizeText(s string) string {
+	lines := strings.Split(strings.ReplaceAll(s, "\r\n", "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRightFunc(line, unicode.IsSpace)
+	}
+	return strings.Trim(strings.Join(lines, "\n"), "\n")
+}
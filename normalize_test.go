@@ -0,0 +1,86 @@
+package paprika
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeRecipe(t *testing.T) {
+	base := Recipe{
+		UID:         "abc-123",
+		Name:        "Pancakes",
+		Ingredients: "1 cup flour\n2 eggs",
+		Directions:  "Mix.\n\nCook.",
+		Categories:  []string{"breakfast", "quick"},
+	}
+
+	for _, tt := range []struct {
+		name   string
+		modify func(r *Recipe)
+	}{
+		{"unchanged", func(r *Recipe) {}},
+		{"padded name", func(r *Recipe) { r.Name = "  Pancakes\t" }},
+		{"trailing whitespace in directions", func(r *Recipe) { r.Directions = "Mix.  \n\nCook. \n" }},
+		{"surrounding blank lines in ingredients", func(r *Recipe) { r.Ingredients = "\n1 cup flour\n2 eggs\n\n" }},
+		{"CRLF line endings", func(r *Recipe) { r.Directions = "Mix.\r\n\r\nCook." }},
+		{"reordered categories", func(r *Recipe) { r.Categories = []string{"quick", "breakfast"} }},
+		{"duplicate categories", func(r *Recipe) { r.Categories = []string{"quick", "breakfast", "quick"} }},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := base
+			r.Categories = append([]string(nil), base.Categories...)
+			tt.modify(&r)
+
+			want, err := json.Marshal(NormalizeRecipe(base))
+			require.NoError(t, err)
+			got, err := json.Marshal(NormalizeRecipe(r))
+			require.NoError(t, err)
+			assert.Equal(t, string(want), string(got))
+		})
+	}
+
+	t.Run("empty categories", func(t *testing.T) {
+		assert.Nil(t, NormalizeRecipe(Recipe{Categories: []string{}}).Categories)
+	})
+
+	t.Run("meaningful differences preserved", func(t *testing.T) {
+		r := base
+		r.Directions = "Mix.\nCook."
+		assert.NotEqual(t, NormalizeRecipe(base), NormalizeRecipe(r))
+		r = base
+		r.Ingredients = "  1 cup flour\n2 eggs"
+		assert.NotEqual(t, NormalizeRecipe(base), NormalizeRecipe(r), "indentation of the first line is kept")
+	})
+
+	t.Run("does not modify input", func(t *testing.T) {
+		r := base
+		r.Categories = []string{"quick", "breakfast"}
+		NormalizeRecipe(r)
+		assert.Equal(t, []string{"quick", "breakfast"}, r.Categories)
+	})
+}
+
+func TestNormalizeLineEndings(t *testing.T) {
+	r := Recipe{
+		UID:             "abc-123",
+		Name:            "Pancakes\r\n",
+		Ingredients:     "1 cup flour\r\n2 eggs\r\n",
+		Directions:      "Mix.\r\n\r\nCook.  \n",
+		Notes:           "Old Mac\rnotes",
+		NutritionalInfo: "None",
+	}
+	normalized := NormalizeLineEndings(r)
+	assert.Equal(t, Recipe{
+		UID:             "abc-123",
+		Name:            "Pancakes\r\n",
+		Ingredients:     "1 cup flour\n2 eggs\n",
+		Directions:      "Mix.\n\nCook.  \n",
+		Notes:           "Old Mac\nnotes",
+		NutritionalInfo: "None",
+	}, normalized, "only line endings of multi-line text fields should change")
+	assert.Equal(t, normalized, NormalizeLineEndings(normalized))
+	assert.Equal(t, "1 cup flour\r\n2 eggs\r\n", r.Ingredients, "original recipe should not be modified")
+}
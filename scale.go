@@ -0,0 +1,135 @@
+package paprika
+
+import (
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// quantityPattern matches a single quantity: a mixed number ("1 1/2"), a fraction ("1/2"),
+// a whole or decimal number optionally followed by a vulgar fraction ("1.5", "1½"), or a lone vulgar fraction ("½").
+const quantityPattern = `(?:\d+\s+\d+/\d+|\d+/\d+|\d+(?:\.\d+)?(?:\s*[¼½¾⅓⅔⅛⅜⅝⅞])?|[¼½¾⅓⅔⅛⅜⅝⅞])`
+
+// leadingQuantityRE matches the leading quantity (or quantity range, like "2-3" or "2 to 3") of a line.
+var leadingQuantityRE = regexp.MustCompile(`^(\s*)(` + quantityPattern + `)(?:(\s*(?:-|–|to)\s*)(` + quantityPattern + `))?`)
+
+var vulgarFractions = map[rune]float64{
+	'¼': 1.0 / 4, '½': 1.0 / 2, '¾': 3.0 / 4,
+	'⅓': 1.0 / 3, '⅔': 2.0 / 3,
+	'⅛': 1.0 / 8, '⅜': 3.0 / 8, '⅝': 5.0 / 8, '⅞': 7.0 / 8,
+}
+
+// formattedFractions are the fractional parts that scaled quantities are rounded to when close enough.
+var formattedFractions = []struct {
+	value float64
+	text  string
+}{
+	{1.0 / 8, "1/8"}, {1.0 / 4, "1/4"}, {1.0 / 3, "1/3"}, {3.0 / 8, "3/8"}, {1.0 / 2, "1/2"},
+	{5.0 / 8, "5/8"}, {2.0 / 3, "2/3"}, {3.0 / 4, "3/4"}, {7.0 / 8, "7/8"},
+}
+
+// Scaled returns a copy of r with its ingredient quantities and servings multiplied by factor.
+// Ingredient lines that do not begin with a numeric quantity are left unchanged.
+func (r Recipe) Scaled(factor float64) Recipe {
+	scaled := r
+	scaled.Categories = slices.Clone(r.Categories)
+
+	lines := strings.Split(r.Ingredients, "\n")
+	for i, line := range lines {
+		lines[i] = ScaleIngredientLine(line, factor)
+	}
+	scaled.Ingredients = strings.Join(lines, "\n")
+	scaled.Servings = ScaleIngredientLine(r.Servings, factor)
+	return scaled
+}
+
+// ScaleIngredientLine multiplies the leading quantity (or quantity range) of line by factor.
+// Lines that do not begin with a numeric quantity are returned unchanged.
+func ScaleIngredientLine(line string, factor float64) string {
+	m := leadingQuantityRE.FindStringSubmatchIndex(line)
+	if m == nil {
+		return line
+	}
+
+	var b strings.Builder
+	b.WriteString(line[m[2]:m[3]])
+	b.WriteString(scaleQuantity(line[m[4]:m[5]], factor))
+	if m[6] >= 0 {
+		b.WriteString(line[m[6]:m[7]])
+		b.WriteString(scaleQuantity(line[m[8]:m[9]], factor))
+	}
+	b.WriteString(line[m[1]:])
+	return b.String()
+}
+
+// scaleQuantity multiplies the quantity text q by factor and formats the result.
+func scaleQuantity(q string, factor float64) string {
+	v, ok := parseQuantity(q)
+	if !ok {
+		return q
+	}
+	return formatQuantity(v * factor)
+}
+
+// parseQuantity parses quantity text matched by quantityPattern.
+func parseQuantity(q string) (float64, bool) {
+	var total float64
+	for field := range strings.FieldsSeq(q) {
+		// Split any vulgar fraction suffix from a leading number (e.g. "1½")
+		if r := []rune(field); len(r) > 1 {
+			if v, ok := vulgarFractions[r[len(r)-1]]; ok {
+				total += v
+				field = string(r[:len(r)-1])
+			}
+		}
+		if r := []rune(field); len(r) == 1 {
+			if v, ok := vulgarFractions[r[0]]; ok {
+				total += v
+				continue
+			}
+		}
+		if num, den, isFraction := strings.Cut(field, "/"); isFraction {
+			n, err := strconv.ParseFloat(num, 64)
+			if err != nil {
+				return 0, false
+			}
+			d, err := strconv.ParseFloat(den, 64)
+			if err != nil || d == 0 {
+				return 0, false
+			}
+			total += n / d
+			continue
+		}
+		v, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return 0, false
+		}
+		total += v
+	}
+	return total, true
+}
+
+// formatQuantity formats v as a whole or mixed number when its fractional part is close to a
+// common cooking fraction, or as a decimal rounded to two places otherwise.
+func formatQuantity(v float64) string {
+	const tolerance = 0.01
+	whole := math.Floor(v)
+	frac := v - whole
+	if frac < tolerance {
+		return strconv.FormatFloat(whole, 'f', -1, 64)
+	}
+	if frac > 1-tolerance {
+		return strconv.FormatFloat(whole+1, 'f', -1, 64)
+	}
+	for _, f := range formattedFractions {
+		if math.Abs(frac-f.value) < tolerance {
+			if whole == 0 {
+				return f.text
+			}
+			return strconv.FormatFloat(whole, 'f', -1, 64) + " " + f.text
+		}
+	}
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
@@ -0,0 +1,63 @@
+package paprika
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScaleIngredientLine(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		factor float64
+		want   string
+	}{
+		{"double whole", "2 cups flour", 2, "4 cups flour"},
+		{"double decimal", "1.5 tsp salt", 2, "3 tsp salt"},
+		{"double fraction", "1/2 cup sugar", 2, "1 cup sugar"},
+		{"double mixed number", "1 1/2 cups milk", 2, "3 cups milk"},
+		{"double vulgar fraction", "½ onion", 2, "1 onion"},
+		{"double mixed vulgar fraction", "1½ cups stock", 2, "3 cups stock"},
+		{"double range", "2-3 cloves garlic", 2, "4-6 cloves garlic"},
+		{"double worded range", "2 to 3 cloves garlic", 2, "4 to 6 cloves garlic"},
+		{"halve whole", "3 eggs", 0.5, "1 1/2 eggs"},
+		{"halve fraction", "3/4 cup cream", 0.5, "3/8 cup cream"},
+		{"halve mixed number", "1 1/2 cups milk", 0.5, "3/4 cups milk"},
+		{"halve to decimal", "1/3 cup oil", 0.5, "0.17 cup oil"},
+		{"preserves indentation", "  4 carrots", 0.5, "  2 carrots"},
+		{"non-numeric passthrough", "salt to taste", 2, "salt to taste"},
+		{"section header passthrough", "For the sauce:", 2, "For the sauce:"},
+		{"empty line passthrough", "", 2, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, ScaleIngredientLine(tt.line, tt.factor))
+		})
+	}
+}
+
+func TestRecipeScaled(t *testing.T) {
+	r := Recipe{
+		UID:         "abc",
+		Name:        "Pancakes",
+		Servings:    "4 servings",
+		Ingredients: "1 1/2 cups flour\n2 eggs\npinch of salt",
+		Categories:  []string{"breakfast"},
+	}
+
+	doubled := r.Scaled(2)
+	assert.Equal(t, "8 servings", doubled.Servings)
+	assert.Equal(t, "3 cups flour\n4 eggs\npinch of salt", doubled.Ingredients)
+	assert.Equal(t, r.Name, doubled.Name)
+
+	halved := r.Scaled(0.5)
+	assert.Equal(t, "2 servings", halved.Servings)
+	assert.Equal(t, "3/4 cups flour\n1 eggs\npinch of salt", halved.Ingredients)
+
+	// Original recipe is unchanged
+	assert.Equal(t, "4 servings", r.Servings)
+	doubled.Categories[0] = "changed"
+	assert.Equal(t, "breakfast", r.Categories[0])
+}